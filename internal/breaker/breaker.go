@@ -0,0 +1,249 @@
+package breaker
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 熔断器状态
+const (
+	StateClosed   = "closed"    // 正常放行
+	StateOpen     = "open"      // 熔断中，直接拒绝
+	StateHalfOpen = "half_open" // 冷却结束，放行一个探测请求
+)
+
+// Config 熔断配置
+type Config struct {
+	Threshold int           // 连续失败多少次后熔断，<= 0 关闭熔断
+	Cooldown  time.Duration // 熔断后多久放行探测请求
+	PerModel  bool          // 按驱动 + 模型分别熔断，默认只按驱动
+}
+
+// DefaultConfig 默认配置
+func DefaultConfig() Config {
+	return Config{Threshold: 5, Cooldown: 30 * time.Second}
+}
+
+// ConfigFromEnv 从环境变量读取熔断配置，未设置的项使用默认值
+//
+//	BREAKER_THRESHOLD  连续失败次数阈值，设为 0 关闭熔断
+//	BREAKER_COOLDOWN   熔断冷却时长，如 30s
+//	BREAKER_PER_MODEL  设为 true 时按模型分别熔断
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if v := os.Getenv("BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid BREAKER_THRESHOLD %q", v)
+		}
+		cfg.Threshold = n
+	}
+	if v := os.Getenv("BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid BREAKER_COOLDOWN %q", v)
+		}
+		cfg.Cooldown = d
+	}
+	if v := os.Getenv("BREAKER_PER_MODEL"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid BREAKER_PER_MODEL %q", v)
+		}
+		cfg.PerModel = b
+	}
+	return cfg, nil
+}
+
+// OpenError 熔断中拒绝请求
+type OpenError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+// Error 实现 error 接口
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("upstream %s is temporarily unavailable (circuit open), retry after %ds", e.Key, int(e.RetryAfter.Seconds()+0.5))
+}
+
+// Status 单个熔断器的状态
+type Status struct {
+	Key      string     `json:"key"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"` // 连续失败次数
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"` // 何时放行探测请求
+	LastErr  string     `json:"last_error,omitempty"`
+}
+
+// breaker 单个驱动（或模型）的熔断器
+type breaker struct {
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool // 半开状态下已有探测请求在进行
+	lastErr  string
+}
+
+// Breakers 按驱动（可选按模型）划分的熔断器集合
+// nil 或 Threshold <= 0 时不熔断，所有方法都可安全调用
+type Breakers struct {
+	cfg Config
+	mu  sync.Mutex
+	m   map[string]*breaker
+	now func() time.Time
+}
+
+// New 创建熔断器集合
+func New(cfg Config) *Breakers {
+	return &Breakers{cfg: cfg, m: make(map[string]*breaker), now: time.Now}
+}
+
+// Enabled 是否开启熔断
+func (b *Breakers) Enabled() bool {
+	return b != nil && b.cfg.Threshold > 0
+}
+
+// Key 熔断器键：驱动名，按模型熔断时为 驱动/模型
+func (b *Breakers) Key(driver, model string) string {
+	if b.Enabled() && b.cfg.PerModel {
+		return driver + "/" + model
+	}
+	return driver
+}
+
+// Allow 判断是否放行请求；放行后必须调用 Success、Failure 或 Release 之一报告结果
+func (b *Breakers) Allow(key string) error {
+	if !b.Enabled() {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.m[key]
+	if !ok {
+		return nil
+	}
+	switch br.state {
+	case StateOpen:
+		wait := br.openedAt.Add(b.cfg.Cooldown).Sub(b.now())
+		if wait > 0 {
+			return &OpenError{Key: key, RetryAfter: wait}
+		}
+		br.state = StateHalfOpen
+		br.probing = true
+		log.Info().Str("api", "Breaker").Str("key", key).Msg("熔断冷却结束，放行探测请求")
+		return nil
+	case StateHalfOpen:
+		if br.probing {
+			return &OpenError{Key: key, RetryAfter: b.cfg.Cooldown}
+		}
+		br.probing = true
+	}
+	return nil
+}
+
+// Success 上游调用成功，关闭熔断
+func (b *Breakers) Success(key string) {
+	if !b.Enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.m[key]
+	if !ok {
+		return
+	}
+	if br.state != StateClosed {
+		log.Info().Str("api", "Breaker").Str("key", key).Msg("探测成功，熔断恢复")
+	}
+	delete(b.m, key)
+}
+
+// Failure 上游故障；连续失败达到阈值或探测失败时熔断
+func (b *Breakers) Failure(key string, err error) {
+	if !b.Enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.m[key]
+	if !ok {
+		br = &breaker{state: StateClosed}
+		b.m[key] = br
+	}
+	br.failures++
+	br.probing = false
+	if err != nil {
+		br.lastErr = err.Error()
+	}
+	if br.state == StateHalfOpen || (br.state == StateClosed && br.failures >= b.cfg.Threshold) {
+		br.state = StateOpen
+		br.openedAt = b.now()
+		log.Warn().Str("api", "Breaker").Str("key", key).Int("failures", br.failures).Dur("cooldown", b.cfg.Cooldown).Err(err).Msg("上游连续失败，熔断")
+	}
+}
+
+// Release 结果与上游健康无关（如 Token 失效、请求错误、客户端取消），只释放探测名额
+func (b *Breakers) Release(key string) {
+	if !b.Enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if br, ok := b.m[key]; ok {
+		br.probing = false
+	}
+}
+
+// Reset 手动恢复熔断器，key 为空时恢复全部
+func (b *Breakers) Reset(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if key == "" {
+		b.m = make(map[string]*breaker)
+		return
+	}
+	delete(b.m, key)
+}
+
+// List 返回有失败记录的熔断器，按键排序；一直正常的驱动不列出
+func (b *Breakers) List() []Status {
+	list := []Status{}
+	if !b.Enabled() {
+		return list
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, br := range b.m {
+		st := Status{Key: key, State: br.state, Failures: br.failures, LastErr: br.lastErr}
+		if br.state != StateClosed {
+			opened := br.openedAt
+			retry := opened.Add(b.cfg.Cooldown)
+			st.OpenedAt, st.RetryAt = &opened, &retry
+		}
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// Config 返回熔断配置
+func (b *Breakers) Config() Config {
+	if b == nil {
+		return Config{}
+	}
+	return b.cfg
+}
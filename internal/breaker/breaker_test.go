@@ -0,0 +1,89 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := New(Config{Threshold: 2, Cooldown: time.Minute})
+	b.now = func() time.Time { return now }
+	down := errors.New("503")
+
+	b.Failure("gemini", down)
+	if err := b.Allow("gemini"); err != nil {
+		t.Fatalf("should stay closed below threshold: %v", err)
+	}
+	b.Failure("gemini", down)
+
+	var openErr *OpenError
+	if err := b.Allow("gemini"); !errors.As(err, &openErr) || openErr.Key != "gemini" {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if err := b.Allow("xai"); err != nil {
+		t.Errorf("other drivers should not be affected: %v", err)
+	}
+
+	// 冷却结束后只放行一个探测请求
+	now = now.Add(time.Minute)
+	if err := b.Allow("gemini"); err != nil {
+		t.Fatalf("probe should be allowed: %v", err)
+	}
+	if err := b.Allow("gemini"); err == nil {
+		t.Error("only one probe at a time")
+	}
+	// 探测失败重新熔断
+	b.Failure("gemini", down)
+	if err := b.Allow("gemini"); err == nil {
+		t.Error("failed probe should reopen")
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow("gemini"); err != nil {
+		t.Fatalf("probe should be allowed: %v", err)
+	}
+	// 与上游健康无关的结果只释放探测名额
+	b.Release("gemini")
+	if st := b.List(); len(st) != 1 || st[0].State != StateHalfOpen {
+		t.Fatalf("expected half open, got %+v", st)
+	}
+	if err := b.Allow("gemini"); err != nil {
+		t.Fatalf("probe should be allowed after release: %v", err)
+	}
+	b.Success("gemini")
+	if st := b.List(); len(st) != 0 {
+		t.Errorf("expected recovered, got %+v", st)
+	}
+}
+
+func TestBreaker_SuccessResetsCount(t *testing.T) {
+	b := New(Config{Threshold: 2, Cooldown: time.Minute})
+	b.Failure("openai", nil)
+	b.Success("openai")
+	b.Failure("openai", nil)
+	if err := b.Allow("openai"); err != nil {
+		t.Errorf("failures are not consecutive: %v", err)
+	}
+}
+
+func TestBreaker_Key(t *testing.T) {
+	if k := New(Config{Threshold: 1}).Key("gemini", "gemini-2.5-pro"); k != "gemini" {
+		t.Errorf("key = %q", k)
+	}
+	if k := New(Config{Threshold: 1, PerModel: true}).Key("gemini", "gemini-2.5-pro"); k != "gemini/gemini-2.5-pro" {
+		t.Errorf("per-model key = %q", k)
+	}
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	var nilBreakers *Breakers
+	for _, b := range []*Breakers{nilBreakers, New(Config{})} {
+		b.Failure("gemini", nil)
+		b.Failure("gemini", nil)
+		if err := b.Allow("gemini"); err != nil {
+			t.Errorf("disabled breaker rejected request: %v", err)
+		}
+	}
+}
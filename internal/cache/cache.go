@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"puter2api/internal/puter"
+	"puter2api/internal/storage"
+	"puter2api/internal/types"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 缓存后端
+const (
+	BackendMemory = "memory"
+	BackendSQLite = "sqlite"
+)
+
+// Config 响应缓存配置
+type Config struct {
+	Backend    string        // 为空时关闭缓存
+	TTL        time.Duration // 缓存有效期
+	MaxEntries int           // 最多缓存条数
+	MaxBytes   int64         // 缓存总大小上限
+}
+
+// DefaultConfig 默认配置（缓存关闭）
+func DefaultConfig() Config {
+	return Config{
+		TTL:        time.Hour,
+		MaxEntries: 1000,
+		MaxBytes:   64 << 20,
+	}
+}
+
+// ConfigFromEnv 从环境变量读取响应缓存配置，未设置的项使用默认值
+//
+//	RESPONSE_CACHE              缓存后端：memory / sqlite，留空关闭
+//	RESPONSE_CACHE_TTL          缓存有效期，如 1h
+//	RESPONSE_CACHE_MAX_ENTRIES  最多缓存条数
+//	RESPONSE_CACHE_MAX_BYTES    缓存总大小上限（字节）
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	cfg.Backend = os.Getenv("RESPONSE_CACHE")
+	switch cfg.Backend {
+	case "", BackendMemory, BackendSQLite:
+	default:
+		return cfg, fmt.Errorf("invalid RESPONSE_CACHE %q, expected memory or sqlite", cfg.Backend)
+	}
+	if v := os.Getenv("RESPONSE_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return cfg, fmt.Errorf("invalid RESPONSE_CACHE_TTL %q", v)
+		}
+		cfg.TTL = ttl
+	}
+	if v := os.Getenv("RESPONSE_CACHE_MAX_ENTRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid RESPONSE_CACHE_MAX_ENTRIES %q", v)
+		}
+		cfg.MaxEntries = n
+	}
+	if v := os.Getenv("RESPONSE_CACHE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid RESPONSE_CACHE_MAX_BYTES %q", v)
+		}
+		cfg.MaxBytes = n
+	}
+	return cfg, nil
+}
+
+// backend 缓存存储
+type backend interface {
+	get(key string) ([]byte, bool)
+	set(key string, body []byte)
+	clear()
+	size() (entries int, bytes int64)
+}
+
+// Stats 缓存统计
+type Stats struct {
+	Enabled bool   `json:"enabled"`
+	Backend string `json:"backend,omitempty"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+	Stores  int64  `json:"stores"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// Cache 对话响应缓存，保存上游原始 NDJSON，命中时以流的形式回放
+// 零值和 nil 表示缓存关闭，所有方法都可安全调用
+type Cache struct {
+	cfg     Config
+	backend backend
+	hits    atomic.Int64
+	misses  atomic.Int64
+	stores  atomic.Int64
+}
+
+// New 根据配置创建缓存，未配置后端时返回 nil
+func New(cfg Config, store *storage.Storage) *Cache {
+	c := &Cache{cfg: cfg}
+	switch cfg.Backend {
+	case BackendMemory:
+		c.backend = newMemoryBackend(cfg)
+	case BackendSQLite:
+		c.backend = &sqliteBackend{store: store, cfg: cfg}
+	default:
+		return nil
+	}
+	return c
+}
+
+// Enabled 缓存是否开启
+func (c *Cache) Enabled() bool {
+	return c != nil && c.backend != nil
+}
+
+// Get 读取缓存
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if !c.Enabled() {
+		return nil, false
+	}
+	body, ok := c.backend.get(key)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return body, ok
+}
+
+// Set 写入缓存，超过总大小上限的单条响应不缓存
+func (c *Cache) Set(key string, body []byte) {
+	if !c.Enabled() || int64(len(body)) > c.cfg.MaxBytes {
+		return
+	}
+	c.backend.set(key, body)
+	c.stores.Add(1)
+}
+
+// Clear 清空缓存
+func (c *Cache) Clear() {
+	if c.Enabled() {
+		c.backend.clear()
+	}
+}
+
+// Stats 返回缓存统计
+func (c *Cache) Stats() Stats {
+	if !c.Enabled() {
+		return Stats{}
+	}
+	entries, bytes := c.backend.size()
+	return Stats{
+		Enabled: true,
+		Backend: c.cfg.Backend,
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Stores:  c.stores.Load(),
+		Entries: entries,
+		Bytes:   bytes,
+	}
+}
+
+// keyPayload 参与缓存键计算的请求内容
+type keyPayload struct {
+	Model    string               `json:"model"`
+	Messages []types.PuterMessage `json:"messages"`
+	Params   types.SamplingParams `json:"params"`
+	Tools    []types.OpenAITool   `json:"tools,omitempty"`
+}
+
+// Key 计算请求的缓存键：上游模型、转换后的消息、采样参数和原生工具定义的规范化哈希
+func Key(req puter.ChatRequest) string {
+	data, _ := json.Marshal(keyPayload{
+		Model:    req.Model,
+		Messages: req.Messages,
+		Params:   req.Params,
+		Tools:    req.Tools,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Cacheable 请求是否适合缓存：只有显式设置 temperature 为 0 的确定性请求才缓存。未设置时上游使用默认温度采样，
+// 每次的结果本就不同，回放同一份响应会改变语义
+func Cacheable(req puter.ChatRequest) bool {
+	t := req.Params.Temperature
+	return t != nil && *t == 0
+}
+
+// sqliteBackend 使用 SQLite 存储，重启后缓存仍然有效
+type sqliteBackend struct {
+	store *storage.Storage
+	cfg   Config
+}
+
+func (b *sqliteBackend) get(key string) ([]byte, bool) {
+	body, ok, err := b.store.GetCachedResponse(key)
+	if err != nil {
+		log.Error().Str("api", "Cache").Err(err).Msg("读取响应缓存失败")
+		return nil, false
+	}
+	return body, ok
+}
+
+func (b *sqliteBackend) set(key string, body []byte) {
+	if err := b.store.PutCachedResponse(key, body, b.cfg.TTL); err != nil {
+		log.Error().Str("api", "Cache").Err(err).Msg("写入响应缓存失败")
+		return
+	}
+	if err := b.store.TrimResponseCache(b.cfg.MaxEntries, b.cfg.MaxBytes); err != nil {
+		log.Error().Str("api", "Cache").Err(err).Msg("淘汰响应缓存失败")
+	}
+}
+
+func (b *sqliteBackend) clear() {
+	if err := b.store.ClearResponseCache(); err != nil {
+		log.Error().Str("api", "Cache").Err(err).Msg("清空响应缓存失败")
+	}
+}
+
+func (b *sqliteBackend) size() (int, int64) {
+	entries, bytes, err := b.store.ResponseCacheSize()
+	if err != nil {
+		log.Error().Str("api", "Cache").Err(err).Msg("读取响应缓存大小失败")
+	}
+	return entries, bytes
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"puter2api/internal/puter"
+	"puter2api/internal/storage"
+	"puter2api/internal/types"
+)
+
+func TestKey(t *testing.T) {
+	temp := 0.5
+	req := puter.ChatRequest{
+		Model:    "gpt-5",
+		Messages: []types.PuterMessage{{Role: "user", Content: "hi"}},
+		Params:   types.SamplingParams{Temperature: &temp},
+	}
+	if Key(req) != Key(req) {
+		t.Fatal("key is not stable")
+	}
+
+	other := req
+	other.Model = "gpt-5-mini"
+	if Key(other) == Key(req) {
+		t.Error("model should change the key")
+	}
+	hotter := 0.9
+	other = req
+	other.Params = types.SamplingParams{Temperature: &hotter}
+	if Key(other) == Key(req) {
+		t.Error("sampling params should change the key")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	c := New(Config{Backend: BackendMemory, TTL: time.Hour, MaxEntries: 2, MaxBytes: 10}, nil)
+
+	c.Set("a", []byte("aaa"))
+	c.Set("b", []byte("bbb"))
+	c.Get("a") // a 最近使用过，b 先被淘汰
+	c.Set("c", []byte("ccc"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should be evicted by entry limit")
+	}
+	if body, ok := c.Get("a"); !ok || string(body) != "aaa" {
+		t.Errorf("a = %q, %v", body, ok)
+	}
+
+	c.Set("big", []byte("0123456789a"))
+	if _, ok := c.Get("big"); ok {
+		t.Error("body larger than MaxBytes should not be cached")
+	}
+	c.Set("d", []byte("dddddddd"))
+	if st := c.Stats(); st.Entries != 1 || st.Bytes != 8 {
+		t.Errorf("byte limit not enforced: %+v", st)
+	}
+
+	st := c.Stats()
+	if !st.Enabled || st.Hits != 2 || st.Misses != 2 || st.Stores != 4 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestMemoryBackendTTL(t *testing.T) {
+	c := New(Config{Backend: BackendMemory, TTL: time.Millisecond, MaxEntries: 10, MaxBytes: 100}, nil)
+	c.Set("a", []byte("x"))
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expired entry returned")
+	}
+	if st := c.Stats(); st.Entries != 0 {
+		t.Errorf("expired entry not removed: %+v", st)
+	}
+}
+
+func TestSQLiteBackend(t *testing.T) {
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	c := New(Config{Backend: BackendSQLite, TTL: time.Hour, MaxEntries: 3, MaxBytes: 100}, store)
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprintf("k%d", i), []byte("body"))
+		time.Sleep(2 * time.Millisecond)
+	}
+	if st := c.Stats(); st.Entries != 3 || st.Bytes != 12 {
+		t.Errorf("entry limit not enforced: %+v", st)
+	}
+	if _, ok := c.Get("k0"); ok {
+		t.Error("oldest entry should be evicted")
+	}
+	if body, ok := c.Get("k4"); !ok || string(body) != "body" {
+		t.Errorf("k4 = %q, %v", body, ok)
+	}
+
+	c.Clear()
+	if st := c.Stats(); st.Entries != 0 {
+		t.Errorf("cache not cleared: %+v", st)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var c *Cache
+	if New(Config{}, nil) != nil || c.Enabled() {
+		t.Fatal("cache should be disabled without backend")
+	}
+	c.Set("a", []byte("x"))
+	if _, ok := c.Get("a"); ok {
+		t.Error("disabled cache returned a value")
+	}
+	if st := c.Stats(); st.Enabled {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	zero, sampled := 0.0, 0.9
+	tests := []struct {
+		temp *float64
+		want bool
+	}{
+		{nil, false},
+		{&zero, true},
+		{&sampled, false},
+	}
+	for _, tt := range tests {
+		req := puter.ChatRequest{Model: "gpt-5", Params: types.SamplingParams{Temperature: tt.temp}}
+		if got := Cacheable(req); got != tt.want {
+			t.Errorf("Cacheable(temperature=%v) = %v, want %v", tt.temp, got, tt.want)
+		}
+	}
+}
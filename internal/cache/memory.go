@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memoryEntry 内存缓存条目
+type memoryEntry struct {
+	key       string
+	body      []byte
+	expiresAt time.Time
+}
+
+// memoryBackend 进程内 LRU 缓存，超出条数或总大小时淘汰最久未使用的条目
+type memoryBackend struct {
+	cfg   Config
+	mu    sync.Mutex
+	order *list.List // 最近使用的在前
+	items map[string]*list.Element
+	bytes int64
+}
+
+func newMemoryBackend(cfg Config) *memoryBackend {
+	return &memoryBackend{cfg: cfg, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (b *memoryBackend) get(key string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	el, ok := b.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*memoryEntry)
+	if time.Now().After(entry.expiresAt) {
+		b.remove(el)
+		return nil, false
+	}
+	b.order.MoveToFront(el)
+	return entry.body, true
+}
+
+func (b *memoryBackend) set(key string, body []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if el, ok := b.items[key]; ok {
+		b.remove(el)
+	}
+	b.items[key] = b.order.PushFront(&memoryEntry{key: key, body: body, expiresAt: time.Now().Add(b.cfg.TTL)})
+	b.bytes += int64(len(body))
+
+	for b.order.Len() > b.cfg.MaxEntries || b.bytes > b.cfg.MaxBytes {
+		b.remove(b.order.Back())
+	}
+}
+
+// remove 删除条目，调用方持有锁
+func (b *memoryBackend) remove(el *list.Element) {
+	entry := b.order.Remove(el).(*memoryEntry)
+	delete(b.items, entry.key)
+	b.bytes -= int64(len(entry.body))
+}
+
+func (b *memoryBackend) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.order.Init()
+	b.items = make(map[string]*list.Element)
+	b.bytes = 0
+}
+
+func (b *memoryBackend) size() (int, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.order.Len(), b.bytes
+}
@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"errors"
+	"sync"
+
+	"puter2api/internal/storage"
+)
+
+// ErrInvalidAlias 别名或目标为空，或别名指向自身
+var ErrInvalidAlias = errors.New("alias and target are required and must differ")
+
+// Aliases 模型别名表：数据库持久化，内存中缓存以便每个请求查找
+type Aliases struct {
+	store *storage.Storage
+	mu    sync.RWMutex
+	list  []storage.ModelAlias
+	byID  map[string]string
+}
+
+// NewAliases 创建别名表并从数据库加载
+func NewAliases(store *storage.Storage) (*Aliases, error) {
+	a := &Aliases{store: store}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload 从数据库重新加载别名
+func (a *Aliases) reload() error {
+	list, err := a.store.GetAllAliases()
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]string, len(list))
+	for _, al := range list {
+		byID[al.Alias] = al.Target
+	}
+	a.mu.Lock()
+	a.list, a.byID = list, byID
+	a.mu.Unlock()
+	return nil
+}
+
+// Resolve 返回模型 ID 实际对应的 Puter 模型，不是别名时原样返回
+// 目标本身是别名时继续展开，遇到循环时停在循环开始前的最后一个模型
+func (a *Aliases) Resolve(model string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	seen := map[string]bool{model: true}
+	for {
+		target, ok := a.byID[model]
+		if !ok || seen[target] {
+			return model
+		}
+		seen[target] = true
+		model = target
+	}
+}
+
+// List 返回所有别名
+func (a *Aliases) List() []storage.ModelAlias {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]storage.ModelAlias(nil), a.list...)
+}
+
+// Set 添加或更新别名
+func (a *Aliases) Set(alias, target string) error {
+	if alias == "" || target == "" || alias == target {
+		return ErrInvalidAlias
+	}
+	if err := a.store.SetAlias(alias, target); err != nil {
+		return err
+	}
+	return a.reload()
+}
+
+// Delete 删除别名
+func (a *Aliases) Delete(alias string) error {
+	if err := a.store.DeleteAlias(alias); err != nil {
+		return err
+	}
+	return a.reload()
+}
@@ -0,0 +1,325 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"puter2api/internal/puter"
+	"puter2api/internal/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultTTL 模型目录默认的缓存有效期
+const DefaultTTL = 6 * time.Hour
+
+// errNoToken 没有可用 Token 时无法同步
+var errNoToken = errors.New("no available token to fetch model catalog")
+
+// maxRefreshTokens 一次同步最多尝试的 Token 数
+const maxRefreshTokens = 3
+
+// Overrides 本地覆盖列表：Include 中的模型始终列出，Exclude 中的模型始终隐藏，
+// Limits 覆盖上游元数据中的上下文限制
+type Overrides struct {
+	Include []string          `json:"include"`
+	Exclude []string          `json:"exclude"`
+	Limits  map[string]Limits `json:"limits"`
+}
+
+// Config 模型目录配置
+type Config struct {
+	TTL       time.Duration // 缓存有效期，<= 0 时不自动同步，只能手动刷新
+	Overrides Overrides
+	Cooldowns storage.TokenCooldowns // 同步时 Token 故障后的冷却时长，应与对话请求的重试策略一致
+}
+
+// ConfigFromEnv 从环境变量读取模型目录配置
+//
+//	MODEL_CATALOG_TTL  缓存有效期，如 6h；设为 0 关闭自动同步
+//	MODEL_OVERRIDES    本地覆盖列表文件 {"include": [...], "exclude": [...], "limits": {"<model>": {"context_window": ..., "max_output_tokens": ...}}}
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{TTL: DefaultTTL, Cooldowns: storage.DefaultTokenCooldowns()}
+	if v := os.Getenv("MODEL_CATALOG_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MODEL_CATALOG_TTL: %w", err)
+		}
+		cfg.TTL = ttl
+	}
+	if path := os.Getenv("MODEL_OVERRIDES"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg.Overrides); err != nil {
+			return cfg, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Diff 两次同步之间的模型变化
+type Diff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Total   int      `json:"total"`
+}
+
+// Status 模型目录状态
+type Status struct {
+	Source    string     `json:"source"` // upstream：上游同步；builtin：内置 model.json
+	Count     int        `json:"count"`
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+	Stale     bool       `json:"stale"`
+}
+
+// Catalog 模型目录：上游同步结果缓存在 SQLite 中，从未同步成功时使用内置列表
+type Catalog struct {
+	client   *puter.Client
+	store    *storage.Storage
+	builtin  []string
+	cfg      Config
+	refresh  sync.Mutex // 同一时间只进行一次同步
+	mu       sync.RWMutex
+	upstream []storage.CatalogModel
+	fetched  time.Time
+}
+
+// New 创建模型目录，builtin 为内置模型列表
+func New(client *puter.Client, store *storage.Storage, builtin []string, cfg Config) *Catalog {
+	return &Catalog{client: client, store: store, builtin: builtin, cfg: cfg}
+}
+
+// Load 从数据库加载缓存的模型目录
+func (c *Catalog) Load() error {
+	models, fetched, err := c.store.GetModelCatalog()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.upstream, c.fetched = models, fetched
+	c.mu.Unlock()
+	return nil
+}
+
+// Models 返回合并覆盖列表后的模型 ID
+func (c *Catalog) Models() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.merged(c.upstream)
+}
+
+// merged 合并上游目录与覆盖列表，保持原顺序并去重
+func (c *Catalog) merged(upstream []storage.CatalogModel) []string {
+	base := c.builtin
+	if len(upstream) > 0 {
+		base = make([]string, len(upstream))
+		for i, m := range upstream {
+			base[i] = m.ID
+		}
+	}
+
+	exclude := make(map[string]bool, len(c.cfg.Overrides.Exclude))
+	for _, id := range c.cfg.Overrides.Exclude {
+		exclude[id] = true
+	}
+	seen := make(map[string]bool, len(base))
+	models := make([]string, 0, len(base)+len(c.cfg.Overrides.Include))
+	for _, list := range [][]string{base, c.cfg.Overrides.Include} {
+		for _, id := range list {
+			if seen[id] || exclude[id] {
+				continue
+			}
+			seen[id] = true
+			models = append(models, id)
+		}
+	}
+	return models
+}
+
+// Status 返回模型目录状态
+func (c *Catalog) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st := Status{Source: "builtin", Count: len(c.merged(c.upstream))}
+	if len(c.upstream) > 0 {
+		fetched := c.fetched
+		st.Source = "upstream"
+		st.FetchedAt = &fetched
+	}
+	st.Stale = c.staleLocked()
+	return st
+}
+
+func (c *Catalog) staleLocked() bool {
+	if c.cfg.TTL <= 0 {
+		return false
+	}
+	return len(c.upstream) == 0 || time.Since(c.fetched) > c.cfg.TTL
+}
+
+// Refresh 立即从上游同步模型目录，返回与同步前相比的变化
+// 对话模型列表获取失败时放弃本次同步；图片/视频模型列表获取失败时只记录日志；Token 不可用时换 Token 重试
+func (c *Catalog) Refresh(ctx context.Context) (Diff, error) {
+	c.refresh.Lock()
+	defer c.refresh.Unlock()
+
+	fetched, err := c.fetchWithFailover(ctx)
+	if err != nil {
+		return Diff{}, err
+	}
+	if len(fetched) == 0 {
+		return Diff{}, errors.New("upstream returned an empty model catalog")
+	}
+
+	now := time.Now()
+	if err := c.store.SaveModelCatalog(fetched, now); err != nil {
+		return Diff{}, err
+	}
+
+	c.mu.Lock()
+	before := c.merged(c.upstream)
+	c.upstream, c.fetched = fetched, now
+	after := c.merged(c.upstream)
+	c.mu.Unlock()
+
+	diff := diffModels(before, after)
+	log.Info().
+		Str("api", "Catalog").
+		Int("total", diff.Total).
+		Int("added", len(diff.Added)).
+		Int("removed", len(diff.Removed)).
+		Msg("模型目录已同步")
+	return diff, nil
+}
+
+// fetchWithFailover 依次使用可用 Token（已排除冷却中和失效的）获取模型列表；
+// Token 本身的问题（失效、限流、余额不足）标记后换下一个 Token，其他错误直接返回
+func (c *Catalog) fetchWithFailover(ctx context.Context) ([]storage.CatalogModel, error) {
+	var tried []int64
+	lastErr := errNoToken
+	for range maxRefreshTokens {
+		t, err := c.store.GetActiveToken(tried...)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			break
+		}
+		tried = append(tried, t.ID)
+		c.store.UpdateTokenUsed(t.ID)
+
+		fetched, err := c.fetch(ctx, credentialFor(t))
+		if err == nil {
+			return fetched, nil
+		}
+		puterErr, ok := puter.AsError(err)
+		if !ok || !puterErr.TokenFault {
+			return nil, err
+		}
+		lastErr = err
+		c.markToken(t, puterErr.Code)
+		log.Warn().Str("api", "Catalog").Str("token", t.Name).Str("reason", puterErr.Code).Err(err).Msg("Token 不可用，换 Token 同步")
+	}
+	return nil, lastErr
+}
+
+// credentialFor 根据 Token 记录构造上游调用凭据
+func credentialFor(t *storage.Token) puter.Credential {
+	return puter.Credential{Token: t.Token, BaseURL: t.BaseURL, Proxy: t.Proxy, Profile: t.Profile}
+}
+
+// fetch 获取对话、图片和视频模型列表；对话模型列表获取失败时返回错误，图片/视频模型列表获取失败时只记录日志
+func (c *Catalog) fetch(ctx context.Context, cred puter.Credential) ([]storage.CatalogModel, error) {
+	var fetched []storage.CatalogModel
+	for _, kind := range []string{puter.ModelKindChat, puter.ModelKindImage, puter.ModelKindVideo} {
+		models, err := c.client.ListModels(ctx, kind, cred)
+		if err != nil {
+			if kind == puter.ModelKindChat {
+				return nil, err
+			}
+			log.Warn().Str("api", "Catalog").Str("kind", kind).Err(err).Msg("获取模型列表失败")
+			continue
+		}
+		for _, m := range models {
+			fetched = append(fetched, storage.CatalogModel{
+				ID:              m.ID,
+				Kind:            kind,
+				ContextWindow:   m.ContextWindow,
+				MaxOutputTokens: m.MaxOutputTokens,
+			})
+		}
+	}
+	return fetched, nil
+}
+
+// markToken 按错误类型标记 Token：失效的停用，限流和余额不足的按配置的冷却时长进入冷却
+func (c *Catalog) markToken(t *storage.Token, code string) {
+	fault := storage.TokenRateLimited
+	switch code {
+	case puter.CodeAuthFailed:
+		fault = storage.TokenInvalid
+	case puter.CodeInsufficientFunds:
+		fault = storage.TokenOutOfFunds
+	}
+	if err := c.store.MarkTokenFault(t.ID, fault, c.cfg.Cooldowns); err != nil {
+		log.Error().Str("api", "Catalog").Str("token", t.Name).Err(err).Msg("更新 Token 状态失败")
+	}
+}
+
+// Run 后台同步：缓存过期时刷新，直到 ctx 取消；TTL <= 0 时不自动同步
+func (c *Catalog) Run(ctx context.Context) {
+	if c.cfg.TTL <= 0 {
+		return
+	}
+	// 过期检查比 TTL 更频繁，以便首次同步失败（如尚未添加 Token）后尽快重试
+	interval := min(c.cfg.TTL, 5*time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.mu.RLock()
+		stale := c.staleLocked()
+		c.mu.RUnlock()
+		if stale {
+			if _, err := c.Refresh(ctx); err != nil && ctx.Err() == nil {
+				log.Warn().Str("api", "Catalog").Err(err).Msg("同步模型目录失败，继续使用缓存")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// diffModels 比较两个模型列表
+func diffModels(before, after []string) Diff {
+	old := make(map[string]bool, len(before))
+	for _, id := range before {
+		old[id] = true
+	}
+	cur := make(map[string]bool, len(after))
+	diff := Diff{Added: []string{}, Removed: []string{}, Total: len(after)}
+	for _, id := range after {
+		cur[id] = true
+		if !old[id] {
+			diff.Added = append(diff.Added, id)
+		}
+	}
+	for _, id := range before {
+		if !cur[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
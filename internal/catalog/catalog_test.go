@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"puter2api/internal/puter"
+	"puter2api/internal/storage"
+)
+
+func TestMerged(t *testing.T) {
+	c := &Catalog{
+		builtin: []string{"a", "b"},
+		cfg:     Config{Overrides: Overrides{Include: []string{"local", "c"}, Exclude: []string{"b", "hidden"}}},
+	}
+	if got := c.merged(nil); !reflect.DeepEqual(got, []string{"a", "local", "c"}) {
+		t.Errorf("builtin merge = %v", got)
+	}
+	upstream := []storage.CatalogModel{{ID: "c", Kind: "chat"}, {ID: "hidden", Kind: "chat"}, {ID: "flux", Kind: "image"}}
+	if got := c.merged(upstream); !reflect.DeepEqual(got, []string{"c", "flux", "local"}) {
+		t.Errorf("upstream merge = %v", got)
+	}
+}
+
+func TestDiffModels(t *testing.T) {
+	diff := diffModels([]string{"a", "b", "c"}, []string{"b", "d", "c", "e"})
+	if !reflect.DeepEqual(diff.Added, []string{"d", "e"}) || !reflect.DeepEqual(diff.Removed, []string{"a"}) || diff.Total != 4 {
+		t.Errorf("diff = %+v", diff)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	a, err := NewAliases(store)
+	if err != nil {
+		t.Fatalf("NewAliases: %v", err)
+	}
+	if err := a.Set("opus", "opus"); err == nil {
+		t.Error("expected error for self alias")
+	}
+	a.Set("claude-opus-4-5-20251101", "claude-opus-4-5")
+	a.Set("opus", "claude-opus-4-5-20251101")
+
+	if got := a.Resolve("claude-opus-4-5-20251101"); got != "claude-opus-4-5" {
+		t.Errorf("Resolve = %s", got)
+	}
+	// 目标本身是别名时继续展开
+	if got := a.Resolve("opus"); got != "claude-opus-4-5" {
+		t.Errorf("Resolve(opus) = %s", got)
+	}
+	if got := a.Resolve("gpt-4o"); got != "gpt-4o" {
+		t.Errorf("non-alias should pass through, got %s", got)
+	}
+
+	// 重新加载后仍然存在
+	reloaded, _ := NewAliases(store)
+	if len(reloaded.List()) != 2 {
+		t.Errorf("persisted aliases = %v", reloaded.List())
+	}
+	// 循环别名不会死循环
+	reloaded.Set("claude-opus-4-5", "opus")
+	if got := reloaded.Resolve("opus"); got != "claude-opus-4-5" {
+		t.Errorf("cyclic Resolve(opus) = %s", got)
+	}
+	reloaded.Delete("opus")
+	if got := reloaded.Resolve("opus"); got != "opus" {
+		t.Errorf("deleted alias still resolves to %s", got)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	c := &Catalog{
+		cfg: Config{Overrides: Overrides{Limits: map[string]Limits{"gpt-4o": {ContextWindow: 64000}}}},
+		upstream: []storage.CatalogModel{
+			{ID: "claude-sonnet-4-5", Kind: "chat", ContextWindow: 1000000},
+			{ID: "gpt-4o", Kind: "chat", ContextWindow: 128000, MaxOutputTokens: 4096},
+		},
+	}
+	tests := []struct {
+		model string
+		want  Limits
+	}{
+		// 上游元数据优先，缺失的字段用内置默认值补全
+		{"claude-sonnet-4-5", Limits{1000000, 64000}},
+		// 本地覆盖优先于上游元数据
+		{"gpt-4o", Limits{64000, 4096}},
+		{"openrouter:google/gemini-2.5-pro", Limits{1048576, 65536}},
+		{"o1-mini", Limits{128000, 65536}},
+		{"unknown-model", Limits{DefaultContextWindow, 0}},
+	}
+	for _, tt := range tests {
+		if got := c.Limits(tt.model); got != tt.want {
+			t.Errorf("Limits(%q) = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestRefresh_FailsOverToNextToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer bad" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"unauthorized"}}`))
+			return
+		}
+		w.Write([]byte(`{"models":["gpt-4o"]}`))
+	}))
+	defer srv.Close()
+
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+	for _, tok := range []string{"bad", "good"} {
+		added, err := store.AddToken(tok, tok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.UpdateTokenValid(added.ID, true)
+	}
+
+	c := New(puter.NewClient(puter.Endpoint{BaseURL: srv.URL}, nil), store, nil, Config{})
+	if _, err := c.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if got := c.Models(); !reflect.DeepEqual(got, []string{"gpt-4o"}) {
+		t.Errorf("Models = %v", got)
+	}
+	// 失效的 Token 被停用
+	tokens, _ := store.GetAllTokens()
+	for _, tok := range tokens {
+		if tok.Name == "bad" && tok.IsValid {
+			t.Error("expected unauthorized token to be marked invalid")
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package catalog
+
+import "strings"
+
+// DefaultContextWindow 没有任何元数据的模型使用的上下文窗口（token）
+const DefaultContextWindow = 128000
+
+// Limits 模型的上下文限制（token），0 表示未知
+type Limits struct {
+	ContextWindow   int `json:"context_window,omitempty"`
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+}
+
+// merge 用 fallback 补全未知的限制
+func (l Limits) merge(fallback Limits) Limits {
+	if l.ContextWindow <= 0 {
+		l.ContextWindow = fallback.ContextWindow
+	}
+	if l.MaxOutputTokens <= 0 {
+		l.MaxOutputTokens = fallback.MaxOutputTokens
+	}
+	return l
+}
+
+// builtinLimits 上游没有提供元数据时按模型 ID 前缀使用的默认限制，按顺序匹配，更具体的前缀在前
+var builtinLimits = []struct {
+	prefix string
+	limits Limits
+}{
+	{"claude-", Limits{200000, 64000}},
+	{"gpt-5", Limits{400000, 128000}},
+	{"gpt-4.1", Limits{1047576, 32768}},
+	{"gpt-4o", Limits{128000, 16384}},
+	{"gpt-4.5", Limits{128000, 16384}},
+	{"gpt-4-turbo", Limits{128000, 4096}},
+	{"gpt-4", Limits{8192, 8192}},
+	{"gpt-3.5", Limits{16385, 4096}},
+	{"o1-mini", Limits{128000, 65536}},
+	{"o1", Limits{200000, 100000}},
+	{"o3", Limits{200000, 100000}},
+	{"o4", Limits{200000, 100000}},
+	{"gemini-", Limits{1048576, 65536}},
+	{"grok-4", Limits{256000, 0}},
+	{"grok-", Limits{131072, 0}},
+	{"deepseek-", Limits{128000, 8192}},
+}
+
+// builtinLimitsFor 返回模型的内置默认限制；渠道前缀（openrouter:）和厂商前缀（vendor/）不参与匹配
+func builtinLimitsFor(model string) Limits {
+	id := strings.ToLower(model)
+	if i := strings.LastIndexAny(id, ":/"); i >= 0 {
+		id = id[i+1:]
+	}
+	for _, b := range builtinLimits {
+		if strings.HasPrefix(id, b.prefix) {
+			return b.limits
+		}
+	}
+	return Limits{}
+}
+
+// Limits 返回模型的上下文限制，优先级依次为本地覆盖、上游元数据、内置默认值；
+// 上下文窗口始终大于 0，最大输出未知时为 0
+func (c *Catalog) Limits(model string) Limits {
+	var l Limits
+	if c != nil {
+		l = c.cfg.Overrides.Limits[model]
+		c.mu.RLock()
+		for _, m := range c.upstream {
+			if m.ID == model {
+				l = l.merge(Limits{m.ContextWindow, m.MaxOutputTokens})
+				break
+			}
+		}
+		c.mu.RUnlock()
+	}
+	return l.merge(builtinLimitsFor(model)).merge(Limits{ContextWindow: DefaultContextWindow})
+}
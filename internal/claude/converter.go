@@ -3,36 +3,169 @@ package claude
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
+	"puter2api/internal/tokenizer"
 	"puter2api/internal/types"
 )
 
-// GetMessageText 获取消息文本内容
+// GetMessageText 获取消息文本内容（不含图片）
 func GetMessageText(m *types.ClaudeMessage) string {
+	text, _ := messageContent(m, false)
+	return text
+}
+
+// GetMessageParts 获取消息的结构化内容，消息不含图片时返回 nil
+func GetMessageParts(m *types.ClaudeMessage) []types.PuterContentPart {
+	_, parts := messageContent(m, false)
+	return parts
+}
+
+// messageContent 返回消息的文本内容；消息含图片（或 nativeTools 时含工具调用）时
+// 同时返回按顺序排列的内容片段。未使用原生工具调用时 tool_use / tool_result 渲染为标签文本
+func messageContent(m *types.ClaudeMessage, nativeTools bool) (string, []types.PuterContentPart) {
 	var text string
 	if err := json.Unmarshal(m.Content, &text); err == nil {
-		return text
+		return text, nil
 	}
 
 	var blocks []types.ContentBlock
-	if err := json.Unmarshal(m.Content, &blocks); err == nil {
-		var result string
-		for _, b := range blocks {
-			switch b.Type {
-			case "text":
-				result += b.Text
-			case "tool_use":
-				inputStr, _ := json.Marshal(b.Input)
-				result += fmt.Sprintf("\n<tool_call>\n{\"name\": \"%s\", \"id\": \"%s\", \"input\": %s}\n</tool_call>\n", b.Name, b.ID, string(inputStr))
-			case "tool_result":
-				var contentStr string
-				if err := json.Unmarshal(b.Content, &contentStr); err != nil {
-					contentStr = string(b.Content)
-				}
-				result += fmt.Sprintf("\n<tool_result id=\"%s\">\n%s\n</tool_result>\n", b.ToolUseID, contentStr)
-			}
+	if err := json.Unmarshal(m.Content, &blocks); err != nil {
+		return "", nil
+	}
+	b := contentBuilder{nativeTools: nativeTools}
+	for _, blk := range blocks {
+		switch blk.Type {
+		case "text":
+			b.text(blk.Text)
+		case "image":
+			b.image(blk.Source)
+		case "tool_use":
+			b.toolUse(blk)
+		case "tool_result":
+			b.toolResult(blk)
+		}
+	}
+	return b.result()
+}
+
+// contentBuilder 拼接消息文本，遇到图片或原生工具调用时切分为内容片段
+type contentBuilder struct {
+	nativeTools bool
+	all         strings.Builder // 全部文本（工具调用也以标签形式计入，用于估算）
+	cur         strings.Builder // 上一个结构化片段之后的文本
+	parts       []types.PuterContentPart
+	structured  int
+}
+
+func (b *contentBuilder) text(s string) {
+	b.all.WriteString(s)
+	b.cur.WriteString(s)
+}
+
+func (b *contentBuilder) image(src *types.ImageSource) {
+	url := imageURL(src)
+	if url == "" {
+		return
+	}
+	b.add(types.PuterContentPart{Type: "image_url", ImageURL: &types.PuterImageURL{URL: url}})
+}
+
+func (b *contentBuilder) toolUse(blk types.ContentBlock) {
+	inputStr, _ := json.Marshal(blk.Input)
+	tagged := fmt.Sprintf("\n<tool_call>\n{\"name\": \"%s\", \"id\": \"%s\", \"input\": %s}\n</tool_call>\n", blk.Name, blk.ID, string(inputStr))
+	if !b.nativeTools {
+		b.text(tagged)
+		return
+	}
+	input := blk.Input
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+	b.all.WriteString(tagged)
+	b.add(types.PuterContentPart{Type: "tool_use", ID: blk.ID, Name: blk.Name, Input: input})
+}
+
+// toolResult 写入 tool_result：字符串内容直接使用，内容块数组中的文本按行拼接、图片单独成片段
+func (b *contentBuilder) toolResult(blk types.ContentBlock) {
+	content, images := toolResultContent(blk.Content)
+	if !b.nativeTools {
+		b.text(fmt.Sprintf("\n<tool_result id=\"%s\">\n%s", blk.ToolUseID, content))
+		for _, src := range images {
+			b.image(src)
 		}
-		return result
+		b.text("\n</tool_result>\n")
+		return
+	}
+	b.all.WriteString(fmt.Sprintf("\n<tool_result id=\"%s\">\n%s\n</tool_result>\n", blk.ToolUseID, content))
+	b.add(types.PuterContentPart{Type: "tool_result", ToolUseID: blk.ToolUseID, Content: content})
+	for _, src := range images {
+		b.image(src)
+	}
+}
+
+// add 追加一个结构化片段，之前累积的文本先成为文本片段
+func (b *contentBuilder) add(part types.PuterContentPart) {
+	b.flush()
+	b.parts = append(b.parts, part)
+	b.structured++
+}
+
+func (b *contentBuilder) flush() {
+	if b.cur.Len() > 0 {
+		b.parts = append(b.parts, types.PuterContentPart{Type: "text", Text: b.cur.String()})
+		b.cur.Reset()
+	}
+}
+
+func (b *contentBuilder) result() (string, []types.PuterContentPart) {
+	if b.structured == 0 {
+		return b.all.String(), nil
+	}
+	b.flush()
+	return b.all.String(), b.parts
+}
+
+// toolResultContent 提取 tool_result 的文本内容和图片
+func toolResultContent(content json.RawMessage) (string, []*types.ImageSource) {
+	var str string
+	if err := json.Unmarshal(content, &str); err == nil {
+		return str, nil
+	}
+	var blocks []types.ContentBlock
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return string(content), nil
+	}
+	var texts []string
+	var images []*types.ImageSource
+	for _, blk := range blocks {
+		switch blk.Type {
+		case "text":
+			texts = append(texts, blk.Text)
+		case "image":
+			images = append(images, blk.Source)
+		}
+	}
+	return strings.Join(texts, "\n"), images
+}
+
+// imageURL 将图片来源转换为上游接受的地址，base64 图片转为 data URL
+func imageURL(src *types.ImageSource) string {
+	if src == nil {
+		return ""
+	}
+	switch src.Type {
+	case "base64":
+		if src.Data == "" {
+			return ""
+		}
+		mediaType := src.MediaType
+		if mediaType == "" {
+			mediaType = "image/png"
+		}
+		return "data:" + mediaType + ";base64," + src.Data
+	case "url":
+		return src.URL
 	}
 	return ""
 }
@@ -78,11 +211,69 @@ func BuildSystemPrompt(originalSystem json.RawMessage, tools json.RawMessage) st
 	return systemText
 }
 
-// 上下文字符限制（约等于 100k tokens，按 4 字符/token 估算）
-const MaxContextChars = 700000
+// NativeTools 将 Claude 工具定义转换为原生工具调用使用的函数定义
+func NativeTools(tools json.RawMessage) []types.OpenAITool {
+	if len(tools) == 0 {
+		return nil
+	}
+	var toolDefs []types.ToolDef
+	if err := json.Unmarshal(tools, &toolDefs); err != nil {
+		return nil
+	}
+	result := make([]types.OpenAITool, 0, len(toolDefs))
+	for _, t := range toolDefs {
+		result = append(result, types.OpenAITool{
+			Type: "function",
+			Function: types.OpenAIToolFunction{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.InputSchema,
+			},
+		})
+	}
+	return result
+}
+
+// MaxContextTokens 未指定上下文窗口时的默认 token 预算，超出时截断旧消息
+const MaxContextTokens = 175000
 
-// ConvertMessages 转换 Claude 消息为 Puter 消息，并在超出限制时截断旧消息
+// ConvertOptions 消息转换选项
+type ConvertOptions struct {
+	NativeTools   bool              // 工具调用和结果以结构化片段发送，而不是标签文本
+	Tokenizer     tokenizer.Encoder // 截断时的 token 计数器，nil 时使用默认估算
+	ContextWindow int               // 模型的上下文窗口，0 使用 MaxContextTokens，< 0 不截断
+	ReserveTokens int               // 为输出预留的 token 数（max_tokens），从上下文窗口中扣除
+	ExtraTokens   int               // 消息之外的输入 token 数，如原生工具定义
+	Compactor     Compactor         // 非 nil 时超出窗口的历史先尝试压缩为摘要，失败时退回截断
+	SummaryTokens int               // 为摘要预留的 token 数
+}
+
+// ContextOverflowError 截断到只剩首条 user 消息和最新一轮对话后仍放不进上下文窗口
+type ContextOverflowError struct {
+	InputTokens   int // 无法再截断的最少输入 token 数
+	ReserveTokens int // 为输出预留的 token 数
+	ContextWindow int
+}
+
+func (e *ContextOverflowError) Error() string {
+	if e.ReserveTokens > 0 {
+		return fmt.Sprintf("input length and `max_tokens` exceed context limit: %d + %d > %d, decrease input length or `max_tokens` and try again",
+			e.InputTokens, e.ReserveTokens, e.ContextWindow)
+	}
+	return fmt.Sprintf("prompt is too long: %d tokens > %d maximum", e.InputTokens, e.ContextWindow)
+}
+
+// ConvertMessages 转换 Claude 消息为 Puter 消息，并在超出默认预算时截断旧消息
+// 截断后仍放不下时只返回 system prompt，由上游报错
 func ConvertMessages(messages []types.ClaudeMessage, systemPrompt string) []types.PuterMessage {
+	result, _ := ConvertMessagesWith(messages, systemPrompt, ConvertOptions{})
+	return result
+}
+
+// ConvertMessagesWith 按指定选项转换 Claude 消息为 Puter 消息
+// 超出上下文窗口时按对话单元截断，规则见 truncateMessages；放不下时返回 *ContextOverflowError
+// 和只含 system prompt 的结果
+func ConvertMessagesWith(messages []types.ClaudeMessage, systemPrompt string, opts ConvertOptions) ([]types.PuterMessage, error) {
 	var result []types.PuterMessage
 
 	// 先添加 system prompt
@@ -96,32 +287,37 @@ func ConvertMessages(messages []types.ClaudeMessage, systemPrompt string) []type
 	// 转换所有消息
 	var allMessages []types.PuterMessage
 	for _, m := range messages {
+		text, parts := messageContent(&m, opts.NativeTools)
 		allMessages = append(allMessages, types.PuterMessage{
 			Role:    m.Role,
-			Content: GetMessageText(&m),
+			Content: text,
+			Parts:   parts,
 		})
 	}
 
-	// 计算 system prompt 占用的字符数
-	usedChars := len(systemPrompt)
+	window := opts.ContextWindow
+	if window == 0 {
+		window = MaxContextTokens
+	}
+	if window < 0 {
+		return append(result, allMessages...), nil
+	}
 
-	// 从后往前累加，保留最新的消息
-	var keptMessages []types.PuterMessage
-	for i := len(allMessages) - 1; i >= 0; i-- {
-		msgLen := len(allMessages[i].Content)
-		if usedChars+msgLen > MaxContextChars {
-			// 超出限制，停止添加更早的消息
-			break
-		}
-		usedChars += msgLen
-		keptMessages = append([]types.PuterMessage{allMessages[i]}, keptMessages...)
+	enc := opts.Tokenizer
+	if enc == nil {
+		enc = tokenizer.ForModel("")
 	}
+	budget := window - opts.ReserveTokens
 
-	// 确保第一条消息是 user 角色（Claude API 要求）
-	for len(keptMessages) > 0 && keptMessages[0].Role != "user" {
-		keptMessages = keptMessages[1:]
+	// system prompt 和消息之外的输入始终保留
+	baseTokens := opts.ExtraTokens
+	if systemPrompt != "" {
+		baseTokens += tokenizer.CountMessage(enc, result[0])
 	}
 
-	result = append(result, keptMessages...)
-	return result
+	kept, minInput, ok := truncateMessages(messages, allMessages, enc, budget-baseTokens, opts.Compactor, opts.SummaryTokens)
+	if !ok {
+		return result, &ContextOverflowError{InputTokens: baseTokens + minInput, ReserveTokens: opts.ReserveTokens, ContextWindow: window}
+	}
+	return append(result, kept...), nil
 }
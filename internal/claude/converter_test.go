@@ -2,9 +2,11 @@ package claude
 
 import (
 	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
 
+	"puter2api/internal/tokenizer"
 	"puter2api/internal/types"
 )
 
@@ -319,7 +321,7 @@ func TestConvertMessages_EnsuresUserFirst(t *testing.T) {
 
 func TestConvertMessages_ContextTruncation(t *testing.T) {
 	// 创建一个超长消息
-	longContent := strings.Repeat("x", MaxContextChars+1000)
+	longContent := strings.Repeat("word ", MaxContextTokens+1000)
 	messages := []types.ClaudeMessage{
 		{Role: "user", Content: json.RawMessage(`"` + longContent + `"`)},
 		{Role: "assistant", Content: json.RawMessage(`"Response"`)},
@@ -334,9 +336,9 @@ func TestConvertMessages_ContextTruncation(t *testing.T) {
 		t.Errorf("expected truncation to occur")
 	}
 
-	// 最后一条消息应该被保留
+	// 最后一条消息应该被保留（首条消息放不下时省略提示加在它开头）
 	lastMsg := result[len(result)-1]
-	if lastMsg.Content != "Short message" {
+	if !strings.HasSuffix(lastMsg.Content, "Short message") {
 		t.Errorf("expected last message to be preserved")
 	}
 }
@@ -379,10 +381,9 @@ func TestConvertMessages_WithToolBlocks(t *testing.T) {
 
 // ==================== 超长上下文测试 ====================
 
-func TestConvertMessages_VeryLongContext_200K(t *testing.T) {
-	// 测试 200k 字符的上下文（应该在限制内）
-	const chars200K = 200000
-	longContent := strings.Repeat("a", chars200K)
+func TestConvertMessages_VeryLongContext_50KTokens(t *testing.T) {
+	// 测试约 50k tokens 的上下文（应该在限制内）
+	longContent := strings.Repeat("lorem ", 50000)
 
 	messages := []types.ClaudeMessage{
 		{Role: "user", Content: json.RawMessage(`"` + longContent + `"`)},
@@ -390,20 +391,19 @@ func TestConvertMessages_VeryLongContext_200K(t *testing.T) {
 
 	result := ConvertMessages(messages, "")
 
-	// 200k 在 700k 限制内，应该保留
+	// 50k 在 MaxContextTokens 限制内，应该保留
 	if len(result) != 1 {
 		t.Fatalf("expected 1 message, got %d", len(result))
 	}
 
-	if len(result[0].Content) != chars200K {
-		t.Errorf("expected content length %d, got %d", chars200K, len(result[0].Content))
+	if result[0].Content != longContent {
+		t.Errorf("expected content to be preserved, got length %d", len(result[0].Content))
 	}
 }
 
-func TestConvertMessages_VeryLongContext_500K(t *testing.T) {
-	// 测试 500k 字符的上下文（应该在限制内）
-	const chars500K = 500000
-	longContent := strings.Repeat("b", chars500K)
+func TestConvertMessages_VeryLongContext_150KTokens(t *testing.T) {
+	// 测试约 150k tokens 的上下文（应该在限制内）
+	longContent := strings.Repeat("ipsum ", 150000)
 
 	messages := []types.ClaudeMessage{
 		{Role: "user", Content: json.RawMessage(`"` + longContent + `"`)},
@@ -411,20 +411,19 @@ func TestConvertMessages_VeryLongContext_500K(t *testing.T) {
 
 	result := ConvertMessages(messages, "")
 
-	// 500k 在 700k 限制内，应该保留
+	// 150k 在 MaxContextTokens 限制内，应该保留
 	if len(result) != 1 {
 		t.Fatalf("expected 1 message, got %d", len(result))
 	}
 
-	if len(result[0].Content) != chars500K {
-		t.Errorf("expected content length %d, got %d", chars500K, len(result[0].Content))
+	if result[0].Content != longContent {
+		t.Errorf("expected content to be preserved, got length %d", len(result[0].Content))
 	}
 }
 
-func TestConvertMessages_VeryLongContext_800K_Truncation(t *testing.T) {
-	// 测试 800k 字符的上下文（超过 700k 限制）
-	const chars800K = 800000
-	longContent := strings.Repeat("c", chars800K)
+func TestConvertMessages_VeryLongContext_200KTokens_Truncation(t *testing.T) {
+	// 测试约 200k tokens 的上下文（超过 MaxContextTokens 限制）
+	longContent := strings.Repeat("dolor ", 200000)
 
 	messages := []types.ClaudeMessage{
 		{Role: "user", Content: json.RawMessage(`"` + longContent + `"`)},
@@ -438,7 +437,7 @@ func TestConvertMessages_VeryLongContext_800K_Truncation(t *testing.T) {
 	// 最后一条消息必须被保留
 	found := false
 	for _, msg := range result {
-		if msg.Content == "Final question" {
+		if strings.HasSuffix(msg.Content, "Final question") {
 			found = true
 			break
 		}
@@ -449,18 +448,81 @@ func TestConvertMessages_VeryLongContext_800K_Truncation(t *testing.T) {
 
 	// 超长消息不应该被保留
 	for _, msg := range result {
-		if len(msg.Content) == chars800K {
-			t.Errorf("800k message should have been truncated")
+		if msg.Content == longContent {
+			t.Errorf("200k-token message should have been truncated")
 		}
 	}
 }
 
-func TestConvertMessages_MultipleMessages_TotalExceeds700K(t *testing.T) {
-	// 多条消息总和超过 700k
-	const chars300K = 300000
-	msg1 := strings.Repeat("1", chars300K)
-	msg2 := strings.Repeat("2", chars300K)
-	msg3 := strings.Repeat("3", chars300K) // 总共 900k
+func TestConvertMessages_UsesTokenizer(t *testing.T) {
+	// 同一内容按校准系数更高的计数器计数时会超出预算
+	content := strings.Repeat("word ", 1000)
+	messages := []types.ClaudeMessage{
+		{Role: "user", Content: json.RawMessage(`"` + content + `"`)},
+	}
+
+	if result, err := ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 1100}); err != nil || len(result) != 1 {
+		t.Errorf("expected message to fit the default tokenizer, got %d messages, err %v", len(result), err)
+	}
+	_, err := ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 1100, Tokenizer: tokenizer.ForModel("claude-sonnet-4-5")})
+	var overflow *ContextOverflowError
+	if !errors.As(err, &overflow) {
+		t.Errorf("expected overflow with the claude tokenizer, got %v", err)
+	}
+	if result, err := ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: -1}); err != nil || len(result) != 1 {
+		t.Errorf("negative ContextWindow should disable truncation")
+	}
+}
+
+func TestConvertMessagesWith_ReservesOutput(t *testing.T) {
+	content := strings.Repeat("word ", 500)
+	messages := []types.ClaudeMessage{
+		{Role: "user", Content: json.RawMessage(`"` + content + `"`)},
+		{Role: "assistant", Content: json.RawMessage(`"ok"`)},
+		{Role: "user", Content: json.RawMessage(`"` + content + `"`)},
+	}
+
+	result, err := ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 2000})
+	if err != nil || len(result) != 3 {
+		t.Fatalf("expected all messages to fit, got %d, err %v", len(result), err)
+	}
+	// 预留输出后只放得下最新一轮
+	result, err = ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 2000, ReserveTokens: 1000})
+	if err != nil || len(result) != 1 || !strings.HasSuffix(result[0].Content, content) {
+		t.Errorf("expected only the latest user message, got %d, err %v", len(result), err)
+	}
+}
+
+func TestConvertMessagesWith_Overflow(t *testing.T) {
+	content := strings.Repeat("word ", 500)
+	messages := []types.ClaudeMessage{
+		{Role: "user", Content: json.RawMessage(`"` + content + `"`)},
+	}
+
+	_, err := ConvertMessagesWith(messages, "Be brief.", ConvertOptions{ContextWindow: 1000, ReserveTokens: 800})
+	var overflow *ContextOverflowError
+	if !errors.As(err, &overflow) {
+		t.Fatalf("expected ContextOverflowError, got %v", err)
+	}
+	if overflow.InputTokens <= 500 || overflow.ReserveTokens != 800 || overflow.ContextWindow != 1000 {
+		t.Errorf("unexpected overflow %+v", overflow)
+	}
+	if !strings.Contains(err.Error(), "max_tokens") {
+		t.Errorf("expected max_tokens in message, got %q", err.Error())
+	}
+
+	_, err = ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 100})
+	if err == nil || !strings.HasPrefix(err.Error(), "prompt is too long") {
+		t.Errorf("expected prompt too long error, got %v", err)
+	}
+}
+
+func TestConvertMessages_MultipleMessages_TotalExceedsBudget(t *testing.T) {
+	// 多条消息总和超过 token 预算
+	const tokens70K = 70000
+	msg1 := strings.Repeat("alpha ", tokens70K)
+	msg2 := strings.Repeat("beta ", tokens70K)
+	msg3 := strings.Repeat("gamma ", tokens70K) // 总共约 210k
 
 	messages := []types.ClaudeMessage{
 		{Role: "user", Content: json.RawMessage(`"` + msg1 + `"`)},
@@ -470,15 +532,15 @@ func TestConvertMessages_MultipleMessages_TotalExceeds700K(t *testing.T) {
 
 	result := ConvertMessages(messages, "")
 
-	// 计算总字符数
-	totalChars := 0
+	// 计算总 token 数
+	totalTokens := 0
 	for _, msg := range result {
-		totalChars += len(msg.Content)
+		totalTokens += tokenizer.CountMessage(tokenizer.ForModel(""), msg)
 	}
 
-	// 总字符数应该不超过 MaxContextChars
-	if totalChars > MaxContextChars {
-		t.Errorf("total chars %d exceeds MaxContextChars %d", totalChars, MaxContextChars)
+	// 总 token 数应该不超过 MaxContextTokens
+	if totalTokens > MaxContextTokens {
+		t.Errorf("total tokens %d exceeds MaxContextTokens %d", totalTokens, MaxContextTokens)
 	}
 
 	// 最新的消息（msg3）应该被保留
@@ -490,9 +552,9 @@ func TestConvertMessages_MultipleMessages_TotalExceeds700K(t *testing.T) {
 
 func TestConvertMessages_LongSystemPrompt_WithMessages(t *testing.T) {
 	// 测试长 system prompt 与消息的组合
-	const chars400K = 400000
-	longSystemPrompt := strings.Repeat("s", chars400K)
-	msgContent := strings.Repeat("m", chars400K) // system + msg = 800k > 700k
+	const tokens100K = 100000
+	longSystemPrompt := strings.Repeat("system ", tokens100K)
+	msgContent := strings.Repeat("message ", tokens100K) // system + msg 约 200k，超出预算
 
 	messages := []types.ClaudeMessage{
 		{Role: "user", Content: json.RawMessage(`"` + msgContent + `"`)},
@@ -513,26 +575,20 @@ func TestConvertMessages_LongSystemPrompt_WithMessages(t *testing.T) {
 		t.Errorf("expected system prompt to be preserved")
 	}
 
-	// 由于 system prompt 占用了 400k，用户消息 400k 会导致总共 800k > 700k
-	// 用户消息应该被截断
-	totalChars := 0
-	for _, msg := range result {
-		totalChars += len(msg.Content)
+	// system prompt 计入预算，用户消息放不下，应该被截断
+	if len(result) != 1 {
+		t.Errorf("expected only the system message, got %d messages", len(result))
 	}
-
-	// 注意：当前实现中 system prompt 不计入截断逻辑的 usedChars 初始值
-	// 这里测试实际行为
-	t.Logf("Total chars in result: %d, messages count: %d", totalChars, len(result))
 }
 
 func TestConvertMessages_PreservesNewestMessages(t *testing.T) {
 	// 验证从后往前保留消息的逻辑
-	const chars250K = 250000
+	const tokens60K = 60000
 
 	messages := []types.ClaudeMessage{
-		{Role: "user", Content: json.RawMessage(`"` + strings.Repeat("1", chars250K) + `"`)},
-		{Role: "assistant", Content: json.RawMessage(`"` + strings.Repeat("2", chars250K) + `"`)},
-		{Role: "user", Content: json.RawMessage(`"` + strings.Repeat("3", chars250K) + `"`)},
+		{Role: "user", Content: json.RawMessage(`"` + strings.Repeat("one ", tokens60K) + `"`)},
+		{Role: "assistant", Content: json.RawMessage(`"` + strings.Repeat("two ", tokens60K) + `"`)},
+		{Role: "user", Content: json.RawMessage(`"` + strings.Repeat("three ", tokens60K) + `"`)},
 		{Role: "assistant", Content: json.RawMessage(`"newest_response"`)},
 		{Role: "user", Content: json.RawMessage(`"newest_question"`)},
 	}
@@ -561,11 +617,10 @@ func TestConvertMessages_PreservesNewestMessages(t *testing.T) {
 
 func TestConvertMessages_ExactlyAtLimit(t *testing.T) {
 	// 测试刚好在限制边界的情况
-	// MaxContextChars = 700000
-	const exactLimit = MaxContextChars - 100 // 留一点余量
+	content := strings.Repeat("word ", MaxContextTokens-100) // 留一点余量
 
 	messages := []types.ClaudeMessage{
-		{Role: "user", Content: json.RawMessage(`"` + strings.Repeat("x", exactLimit) + `"`)},
+		{Role: "user", Content: json.RawMessage(`"` + content + `"`)},
 	}
 
 	result := ConvertMessages(messages, "")
@@ -574,17 +629,17 @@ func TestConvertMessages_ExactlyAtLimit(t *testing.T) {
 		t.Fatalf("expected 1 message, got %d", len(result))
 	}
 
-	if len(result[0].Content) != exactLimit {
-		t.Errorf("expected content length %d, got %d", exactLimit, len(result[0].Content))
+	if result[0].Content != content {
+		t.Errorf("expected content to be preserved, got length %d", len(result[0].Content))
 	}
 }
 
 func TestConvertMessages_JustOverLimit(t *testing.T) {
 	// 测试刚好超过限制的情况
-	const justOver = MaxContextChars + 100
+	justOver := strings.Repeat("yes ", MaxContextTokens+100)
 
 	messages := []types.ClaudeMessage{
-		{Role: "user", Content: json.RawMessage(`"` + strings.Repeat("y", justOver) + `"`)},
+		{Role: "user", Content: json.RawMessage(`"` + justOver + `"`)},
 		{Role: "assistant", Content: json.RawMessage(`"short"`)},
 		{Role: "user", Content: json.RawMessage(`"also short"`)},
 	}
@@ -593,7 +648,7 @@ func TestConvertMessages_JustOverLimit(t *testing.T) {
 
 	// 第一条超长消息应该被跳过
 	for _, msg := range result {
-		if len(msg.Content) == justOver {
+		if msg.Content == justOver {
 			t.Errorf("message just over limit should have been truncated")
 		}
 	}
@@ -605,7 +660,7 @@ func TestConvertMessages_JustOverLimit(t *testing.T) {
 		if msg.Content == "short" {
 			hasShort = true
 		}
-		if msg.Content == "also short" {
+		if strings.HasSuffix(msg.Content, "also short") {
 			hasAlsoShort = true
 		}
 	}
@@ -616,3 +671,109 @@ func TestConvertMessages_JustOverLimit(t *testing.T) {
 	// 注意：由于确保 user 在前的逻辑，"short" (assistant) 可能被移除
 	t.Logf("hasShort: %v, hasAlsoShort: %v, result count: %d", hasShort, hasAlsoShort, len(result))
 }
+
+func TestConvertMessages_ImageBlocks(t *testing.T) {
+	messages := []types.ClaudeMessage{
+		{Role: "user", Content: json.RawMessage(`[
+			{"type": "text", "text": "What is this?"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/jpeg", "data": "AAAA"}},
+			{"type": "image", "source": {"type": "url", "url": "https://example.com/a.png"}}
+		]`)},
+	}
+
+	result := ConvertMessages(messages, "")
+	if len(result) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(result))
+	}
+	msg := result[0]
+	if msg.Content != "What is this?" {
+		t.Errorf("text content should exclude images, got %q", msg.Content)
+	}
+	if len(msg.Parts) != 3 {
+		t.Fatalf("expected 3 parts, got %d", len(msg.Parts))
+	}
+	if msg.Parts[1].ImageURL == nil || msg.Parts[1].ImageURL.URL != "data:image/jpeg;base64,AAAA" {
+		t.Errorf("expected data URL for base64 image, got %+v", msg.Parts[1])
+	}
+	if msg.Parts[2].ImageURL == nil || msg.Parts[2].ImageURL.URL != "https://example.com/a.png" {
+		t.Errorf("expected url image, got %+v", msg.Parts[2])
+	}
+}
+
+func TestConvertMessages_ImageInToolResult(t *testing.T) {
+	messages := []types.ClaudeMessage{
+		{Role: "user", Content: json.RawMessage(`[{"type": "tool_result", "tool_use_id": "toolu_1", "content": [
+			{"type": "text", "text": "screenshot"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "BBBB"}}
+		]}]`)},
+	}
+
+	result := ConvertMessages(messages, "")
+	msg := result[0]
+	if strings.Contains(msg.Content, "BBBB") {
+		t.Errorf("image data should not be rendered as text")
+	}
+	if !strings.Contains(msg.Content, "screenshot") || !msg.HasImages() {
+		t.Errorf("expected text and image parts, got %+v", msg)
+	}
+	last := msg.Parts[len(msg.Parts)-1]
+	if last.Type != "text" || !strings.Contains(last.Text, "</tool_result>") {
+		t.Errorf("expected closing tag after image, got %+v", last)
+	}
+}
+
+func TestConvertMessages_TextOnlyHasNoParts(t *testing.T) {
+	messages := []types.ClaudeMessage{
+		{Role: "user", Content: json.RawMessage(`[{"type": "text", "text": "hi"}]`)},
+	}
+
+	result := ConvertMessages(messages, "")
+	if result[0].Parts != nil {
+		t.Errorf("text-only message should not carry parts")
+	}
+}
+
+func TestConvertMessagesWith_NativeTools(t *testing.T) {
+	messages := []types.ClaudeMessage{
+		{Role: "user", Content: json.RawMessage(`"List files"`)},
+		{Role: "assistant", Content: json.RawMessage(`[
+			{"type": "text", "text": "Checking."},
+			{"type": "tool_use", "id": "toolu_1", "name": "ls", "input": {"path": "."}}
+		]`)},
+		{Role: "user", Content: json.RawMessage(`[{"type": "tool_result", "tool_use_id": "toolu_1", "content": "a.go"}]`)},
+	}
+
+	result, err := ConvertMessagesWith(messages, "", ConvertOptions{NativeTools: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(result))
+	}
+
+	assistant := result[1]
+	if len(assistant.Parts) != 2 || assistant.Parts[1].Type != "tool_use" || assistant.Parts[1].Name != "ls" {
+		t.Fatalf("expected text + tool_use parts, got %+v", assistant.Parts)
+	}
+	if string(assistant.Parts[1].Input) != `{"path": "."}` {
+		t.Errorf("unexpected tool input: %s", assistant.Parts[1].Input)
+	}
+
+	user := result[2]
+	if len(user.Parts) != 1 || user.Parts[0].Type != "tool_result" || user.Parts[0].ToolUseID != "toolu_1" || user.Parts[0].Content != "a.go" {
+		t.Errorf("expected tool_result part, got %+v", user.Parts)
+	}
+	if !strings.Contains(user.Content, "a.go") {
+		t.Errorf("text content should still include the result for estimation")
+	}
+}
+
+func TestNativeTools(t *testing.T) {
+	tools := NativeTools(json.RawMessage(`[{"name": "ls", "description": "List", "input_schema": {"type": "object"}}]`))
+	if len(tools) != 1 || tools[0].Type != "function" || tools[0].Function.Name != "ls" {
+		t.Fatalf("unexpected tools: %+v", tools)
+	}
+	if string(tools[0].Function.Parameters) != `{"type": "object"}` {
+		t.Errorf("unexpected parameters: %s", tools[0].Function.Parameters)
+	}
+}
@@ -0,0 +1,113 @@
+package claude
+
+// BlockSink content block 事件的接收方：流式响应为 SSEWriter，非流式响应为 MessageBuilder
+type BlockSink interface {
+	SendTextBlockStart(index int)
+	SendTextDelta(index int, text string)
+	SendThinkingBlockStart(index int)
+	SendThinkingDelta(index int, thinking string)
+	SendSignatureDelta(index int, signature string)
+	SendToolUseBlockStart(index int, id, name string)
+	SendInputJSONDelta(index int, partialJSON string)
+	SendBlockStop(index int)
+}
+
+// BlockEmitter 将 ToolCallParser 的事件和推理内容转换为 Claude content block 事件
+type BlockEmitter struct {
+	sse        BlockSink
+	index      int
+	inThinking bool
+	inText     bool
+	inTool     bool
+	toolCalls  int
+	hasContent bool // 是否已发送过文本或工具块
+}
+
+// NewBlockEmitter 创建 content block 事件发送器
+func NewBlockEmitter(sse BlockSink) *BlockEmitter {
+	return &BlockEmitter{sse: sse}
+}
+
+// Emit 发送一组解析事件
+func (e *BlockEmitter) Emit(events []ToolStreamEvent) {
+	for _, ev := range events {
+		e.closeThinking()
+		e.hasContent = true
+		switch ev.Type {
+		case EventText:
+			if !e.inText {
+				e.sse.SendTextBlockStart(e.index)
+				e.inText = true
+			}
+			e.sse.SendTextDelta(e.index, ev.Text)
+		case EventToolStart:
+			e.closeText()
+			e.sse.SendToolUseBlockStart(e.index, ev.ID, ev.Name)
+			e.inTool = true
+			e.toolCalls++
+		case EventToolInput:
+			e.sse.SendInputJSONDelta(e.index, ev.PartialJSON)
+		case EventToolEnd:
+			e.sse.SendBlockStop(e.index)
+			e.index++
+			e.inTool = false
+		}
+	}
+}
+
+// EmitThinking 发送推理内容，连续的推理内容合并到同一个 thinking 块；signature 非空时附带签名
+func (e *BlockEmitter) EmitThinking(thinking, signature string) {
+	if !e.inThinking {
+		e.closeText()
+		if e.inTool {
+			e.sse.SendBlockStop(e.index)
+			e.index++
+			e.inTool = false
+		}
+		e.sse.SendThinkingBlockStart(e.index)
+		e.inThinking = true
+	}
+	if thinking != "" {
+		e.sse.SendThinkingDelta(e.index, thinking)
+	}
+	if signature != "" {
+		e.sse.SendSignatureDelta(e.index, signature)
+	}
+}
+
+// Finish 关闭未结束的块并返回 stop_reason
+func (e *BlockEmitter) Finish() string {
+	e.closeThinking()
+	if e.inTool {
+		e.sse.SendBlockStop(e.index)
+		e.index++
+		e.inTool = false
+	}
+	// 没有任何文本或工具内容时也要发送空文本块，否则 Claude Code 会报错
+	if !e.hasContent {
+		e.sse.SendTextBlockStart(e.index)
+		e.inText = true
+	}
+	e.closeText()
+
+	if e.toolCalls > 0 {
+		return "tool_use"
+	}
+	return "end_turn"
+}
+
+func (e *BlockEmitter) closeThinking() {
+	if e.inThinking {
+		e.sse.SendBlockStop(e.index)
+		e.index++
+		e.inThinking = false
+	}
+}
+
+func (e *BlockEmitter) closeText() {
+	if e.inText {
+		e.sse.SendBlockStop(e.index)
+		e.index++
+		e.inText = false
+	}
+}
@@ -0,0 +1,90 @@
+package claude
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlockEmitter_TextThenToolUse(t *testing.T) {
+	c, w := createTestContext()
+	emitter := NewBlockEmitter(NewSSEWriter(c))
+
+	emitter.Emit(feedAll("Checking.", `<tool_call>{"name": "ls", "input": {"path": "."}}</tool_call>`))
+	stopReason := emitter.Finish()
+
+	if stopReason != "tool_use" {
+		t.Errorf("expected stop_reason tool_use, got %s", stopReason)
+	}
+
+	body := w.Body.String()
+	if strings.Count(body, "event: content_block_start") != 2 {
+		t.Errorf("expected 2 content blocks")
+	}
+	if strings.Count(body, "event: content_block_stop") != 2 {
+		t.Errorf("expected 2 content_block_stop events")
+	}
+	if !strings.Contains(body, `"index":1,"delta":{"type":"input_json_delta"`) {
+		t.Errorf("expected input_json_delta on block 1")
+	}
+}
+
+func TestBlockEmitter_EmptyResponseSendsTextBlock(t *testing.T) {
+	c, w := createTestContext()
+	emitter := NewBlockEmitter(NewSSEWriter(c))
+
+	stopReason := emitter.Finish()
+
+	if stopReason != "end_turn" {
+		t.Errorf("expected stop_reason end_turn, got %s", stopReason)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `"content_block":{"type":"text","text":""}`) {
+		t.Errorf("expected empty text block")
+	}
+	if !strings.Contains(body, "event: content_block_stop") {
+		t.Errorf("expected block to be closed")
+	}
+}
+
+func TestBlockEmitter_ThinkingThenText(t *testing.T) {
+	c, w := createTestContext()
+	emitter := NewBlockEmitter(NewSSEWriter(c))
+
+	emitter.EmitThinking("Let me ", "")
+	emitter.EmitThinking("think.", "sig")
+	emitter.Emit(feedAll("Answer."))
+	stopReason := emitter.Finish()
+
+	if stopReason != "end_turn" {
+		t.Errorf("expected stop_reason end_turn, got %s", stopReason)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `"index":0,"content_block":{"type":"thinking","thinking":""}`) {
+		t.Errorf("expected thinking block at index 0")
+	}
+	if strings.Count(body, `"type":"thinking_delta"`) != 2 {
+		t.Errorf("expected 2 thinking_delta events")
+	}
+	if !strings.Contains(body, `"delta":{"type":"signature_delta","signature":"sig"}`) {
+		t.Errorf("expected signature_delta")
+	}
+	if !strings.Contains(body, `"index":1,"delta":{"type":"text_delta","text":"Answer."}`) {
+		t.Errorf("expected text on block 1")
+	}
+	if strings.Count(body, "event: content_block_stop") != 2 {
+		t.Errorf("expected 2 content_block_stop events")
+	}
+}
+
+func TestBlockEmitter_ThinkingOnlyAddsEmptyText(t *testing.T) {
+	c, w := createTestContext()
+	emitter := NewBlockEmitter(NewSSEWriter(c))
+
+	emitter.EmitThinking("hmm", "")
+	emitter.Finish()
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"index":1,"content_block":{"type":"text","text":""}`) {
+		t.Errorf("expected empty text block after thinking")
+	}
+}
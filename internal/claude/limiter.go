@@ -0,0 +1,136 @@
+package claude
+
+import (
+	"sort"
+	"strings"
+
+	"puter2api/internal/tokenizer"
+)
+
+// 本地限制触发时的 stop_reason
+const (
+	StopReasonMaxTokens    = "max_tokens"
+	StopReasonStopSequence = "stop_sequence"
+)
+
+// OutputLimiter 在本地执行上游驱动不支持的 stop sequences 和 max_tokens
+//
+// 文本按到达顺序输入；可能是 stop sequence 开头的尾部会暂存，确认不匹配后再放行。
+// 命中 stop sequence 或输出达到 max_tokens 后 Feed 返回 done，调用方应停止读取上游。
+type OutputLimiter struct {
+	stops     []string
+	maxTokens int               // 0 表示不限制
+	enc       tokenizer.Encoder // 计数器，与输出用量统计一致
+	tokens    int               // 已放行文本的估算 token 数
+	held      string
+	done      bool
+	reason    string
+	sequence  string
+}
+
+// NewOutputLimiter 创建输出限制器，stops 为空且 maxTokens 为 0 时不做任何限制
+// enc 为模型的 token 计数器，nil 时使用默认估算
+func NewOutputLimiter(stops []string, maxTokens int, enc tokenizer.Encoder) *OutputLimiter {
+	if enc == nil {
+		enc = tokenizer.ForModel("")
+	}
+	l := &OutputLimiter{maxTokens: maxTokens, enc: enc}
+	for _, s := range stops {
+		if s != "" {
+			l.stops = append(l.stops, s)
+		}
+	}
+	return l
+}
+
+// Feed 输入一段上游文本，返回可以下发的文本；done 为 true 时应停止读取
+func (l *OutputLimiter) Feed(text string) (out string, done bool) {
+	if l.done {
+		return "", true
+	}
+	if len(l.stops) == 0 {
+		out = l.truncate(text)
+		return out, l.done
+	}
+
+	buf := l.held + text
+	l.held = ""
+	if idx, seq := indexStop(buf, l.stops); idx >= 0 {
+		out = l.truncate(buf[:idx])
+		if !l.done {
+			l.done = true
+			l.reason = StopReasonStopSequence
+			l.sequence = seq
+		}
+		return out, true
+	}
+
+	hold := 0
+	for _, s := range l.stops {
+		if n := partialPrefixLen(buf, s); n > hold {
+			hold = n
+		}
+	}
+	out = l.truncate(buf[:len(buf)-hold])
+	if !l.done {
+		l.held = buf[len(buf)-hold:]
+	}
+	return out, l.done
+}
+
+// Finish 上游正常结束时调用，返回暂存的文本
+func (l *OutputLimiter) Finish() string {
+	if l.done {
+		return ""
+	}
+	out := l.truncate(l.held)
+	l.held = ""
+	return out
+}
+
+// StopReason 返回本地限制触发的 stop_reason 及命中的 stop sequence，未触发时为空
+func (l *OutputLimiter) StopReason() (reason, sequence string) {
+	return l.reason, l.sequence
+}
+
+// truncate 按 max_tokens 截断文本，超出时标记结束
+func (l *OutputLimiter) truncate(text string) string {
+	if l.maxTokens <= 0 || text == "" {
+		return text
+	}
+	n := l.enc.Count(text)
+	if l.tokens+n <= l.maxTokens {
+		l.tokens += n
+		return text
+	}
+
+	// 在字符边界上二分查找放得下的最长前缀
+	remaining := l.maxTokens - l.tokens
+	var offsets []int
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	k := sort.Search(len(offsets), func(i int) bool {
+		return l.enc.Count(text[:offsets[i]]) > remaining
+	})
+	cut := 0
+	if k > 0 {
+		cut = offsets[k-1]
+	}
+
+	l.tokens = l.maxTokens
+	l.done = true
+	l.reason = StopReasonMaxTokens
+	return text[:cut]
+}
+
+// indexStop 返回最早出现的 stop sequence 的位置，没有时返回 -1
+func indexStop(s string, stops []string) (int, string) {
+	best, seq := -1, ""
+	for _, stop := range stops {
+		if idx := strings.Index(s, stop); idx >= 0 && (best < 0 || idx < best) {
+			best, seq = idx, stop
+		}
+	}
+	return best, seq
+}
@@ -0,0 +1,101 @@
+package claude
+
+import (
+	"strings"
+	"testing"
+
+	"puter2api/internal/tokenizer"
+)
+
+// feedLimiter 依次输入文本块，返回下发的全部文本
+func feedLimiter(l *OutputLimiter, chunks ...string) string {
+	var sb strings.Builder
+	for _, chunk := range chunks {
+		out, done := l.Feed(chunk)
+		sb.WriteString(out)
+		if done {
+			return sb.String()
+		}
+	}
+	sb.WriteString(l.Finish())
+	return sb.String()
+}
+
+func TestOutputLimiter_NoLimits(t *testing.T) {
+	l := NewOutputLimiter(nil, 0, nil)
+	got := feedLimiter(l, "Hello", ", world")
+
+	if got != "Hello, world" {
+		t.Errorf("unexpected output: %q", got)
+	}
+	if reason, _ := l.StopReason(); reason != "" {
+		t.Errorf("expected no stop reason, got %s", reason)
+	}
+}
+
+func TestOutputLimiter_StopSequenceAcrossChunks(t *testing.T) {
+	l := NewOutputLimiter([]string{"\nEND"}, 0, nil)
+	got := feedLimiter(l, "line one\nE", "ND trailing", " more")
+
+	if got != "line one" {
+		t.Errorf("unexpected output: %q", got)
+	}
+	reason, seq := l.StopReason()
+	if reason != StopReasonStopSequence || seq != "\nEND" {
+		t.Errorf("got reason=%s seq=%q", reason, seq)
+	}
+}
+
+func TestOutputLimiter_PartialStopReleasedOnFinish(t *testing.T) {
+	l := NewOutputLimiter([]string{"STOP"}, 0, nil)
+	got := feedLimiter(l, "almost ST")
+
+	if got != "almost ST" {
+		t.Errorf("held text should be released on finish, got %q", got)
+	}
+	if reason, _ := l.StopReason(); reason != "" {
+		t.Errorf("expected no stop reason, got %s", reason)
+	}
+}
+
+func TestOutputLimiter_EarliestStopWins(t *testing.T) {
+	l := NewOutputLimiter([]string{"b", "a"}, 0, nil)
+	got := feedLimiter(l, "xxab")
+
+	if got != "xx" {
+		t.Errorf("unexpected output: %q", got)
+	}
+	if _, seq := l.StopReason(); seq != "a" {
+		t.Errorf("expected earliest sequence a, got %q", seq)
+	}
+}
+
+func TestOutputLimiter_MaxTokens(t *testing.T) {
+	l := NewOutputLimiter(nil, 5, nil)
+	got := feedLimiter(l, "one two three ", "four five six seven eight")
+
+	if n := tokenizer.Count(got); n > 5 {
+		t.Errorf("output exceeds max_tokens: %d tokens in %q", n, got)
+	}
+	if !strings.HasPrefix(got, "one two three") {
+		t.Errorf("expected leading text to be kept, got %q", got)
+	}
+	if reason, _ := l.StopReason(); reason != StopReasonMaxTokens {
+		t.Errorf("expected max_tokens, got %s", reason)
+	}
+}
+
+func TestOutputLimiter_UsesModelTokenizer(t *testing.T) {
+	// 同一上限下，计数更高的计数器截断得更早
+	text := strings.Repeat("word ", 200)
+	def := feedLimiter(NewOutputLimiter(nil, 100, nil), text)
+	enc := tokenizer.ForModel("claude-sonnet-4-5")
+	got := feedLimiter(NewOutputLimiter(nil, 100, enc), text)
+
+	if n := enc.Count(got); n > 100 {
+		t.Errorf("output exceeds max_tokens for model tokenizer: %d", n)
+	}
+	if len(got) >= len(def) {
+		t.Errorf("expected earlier cutoff with the claude tokenizer, got %d vs %d bytes", len(got), len(def))
+	}
+}
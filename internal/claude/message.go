@@ -0,0 +1,103 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+
+	"puter2api/internal/types"
+)
+
+// MessageBuilder 收集 content block 事件，拼成非流式响应的完整 Message
+// 与 SSEWriter 接收同一组事件，保证两种响应的内容一致
+type MessageBuilder struct {
+	blocks []any
+	text   strings.Builder // 当前文本块或思考块的内容
+	input  strings.Builder // 当前工具块的 input JSON
+	sig    string
+}
+
+// NewMessageBuilder 创建 Message 构造器
+func NewMessageBuilder() *MessageBuilder {
+	return &MessageBuilder{}
+}
+
+// SendTextBlockStart 开始文本块
+func (b *MessageBuilder) SendTextBlockStart(index int) {
+	b.text.Reset()
+	b.blocks = append(b.blocks, types.TextContentBlock{Type: "text"})
+}
+
+// SendTextDelta 追加文本
+func (b *MessageBuilder) SendTextDelta(index int, text string) {
+	b.text.WriteString(text)
+}
+
+// SendThinkingBlockStart 开始思考块
+func (b *MessageBuilder) SendThinkingBlockStart(index int) {
+	b.text.Reset()
+	b.sig = ""
+	b.blocks = append(b.blocks, types.ThinkingContentBlock{Type: "thinking"})
+}
+
+// SendThinkingDelta 追加思考内容
+func (b *MessageBuilder) SendThinkingDelta(index int, thinking string) {
+	b.text.WriteString(thinking)
+}
+
+// SendSignatureDelta 记录思考块签名
+func (b *MessageBuilder) SendSignatureDelta(index int, signature string) {
+	b.sig = signature
+}
+
+// SendToolUseBlockStart 开始工具块
+func (b *MessageBuilder) SendToolUseBlockStart(index int, id, name string) {
+	b.input.Reset()
+	b.blocks = append(b.blocks, types.ToolUseContentBlock{Type: "tool_use", ID: id, Name: name})
+}
+
+// SendInputJSONDelta 追加工具 input JSON 片段
+func (b *MessageBuilder) SendInputJSONDelta(index int, partialJSON string) {
+	b.input.WriteString(partialJSON)
+}
+
+// SendBlockStop 结束当前块，写入累积的内容
+func (b *MessageBuilder) SendBlockStop(index int) {
+	if len(b.blocks) == 0 {
+		return
+	}
+	last := len(b.blocks) - 1
+	switch blk := b.blocks[last].(type) {
+	case types.TextContentBlock:
+		blk.Text = b.text.String()
+		b.blocks[last] = blk
+	case types.ThinkingContentBlock:
+		blk.Thinking, blk.Signature = b.text.String(), b.sig
+		b.blocks[last] = blk
+	case types.ToolUseContentBlock:
+		blk.Input = json.RawMessage(b.input.String())
+		if !json.Valid(blk.Input) {
+			blk.Input = json.RawMessage("{}")
+		}
+		b.blocks[last] = blk
+	}
+}
+
+// Message 返回完整的 Message；stopSequence 为空表示未命中 stop sequence
+func (b *MessageBuilder) Message(msgID, model, stopReason, stopSequence string, usage types.Usage) types.ClaudeResponse {
+	msg := types.ClaudeResponse{
+		ID:         msgID,
+		Type:       "message",
+		Role:       "assistant",
+		Content:    b.blocks,
+		Model:      model,
+		StopReason: &stopReason,
+		Usage:      usage,
+	}
+	if msg.Content == nil {
+		msg.Content = []any{}
+	}
+	if stopSequence != "" {
+		msg.StopSequence = &stopSequence
+	}
+	return msg
+}
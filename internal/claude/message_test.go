@@ -0,0 +1,66 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"puter2api/internal/types"
+)
+
+func TestMessageBuilder_Blocks(t *testing.T) {
+	builder := NewMessageBuilder()
+	emitter := NewBlockEmitter(builder)
+
+	emitter.EmitThinking("Let me ", "")
+	emitter.EmitThinking("look.", "sig")
+	emitter.Emit(feedAll("Checking.", `<tool_call>{"name": "ls", "input": {"path": "."}}</tool_call>`))
+	stopReason := emitter.Finish()
+
+	msg := builder.Message("msg_1", "claude-sonnet-4-5", stopReason, "", types.Usage{InputTokens: 10, OutputTokens: 5})
+	if *msg.StopReason != "tool_use" || msg.StopSequence != nil {
+		t.Errorf("unexpected stop: %v, %v", *msg.StopReason, msg.StopSequence)
+	}
+	if len(msg.Content) != 3 {
+		t.Fatalf("expected 3 blocks, got %d: %+v", len(msg.Content), msg.Content)
+	}
+	if blk := msg.Content[0].(types.ThinkingContentBlock); blk.Thinking != "Let me look." || blk.Signature != "sig" {
+		t.Errorf("unexpected thinking block: %+v", blk)
+	}
+	if blk := msg.Content[1].(types.TextContentBlock); blk.Text != "Checking." {
+		t.Errorf("unexpected text block: %+v", blk)
+	}
+	blk := msg.Content[2].(types.ToolUseContentBlock)
+	if blk.Name != "ls" || blk.ID == "" {
+		t.Errorf("unexpected tool block: %+v", blk)
+	}
+	var input map[string]string
+	if err := json.Unmarshal(blk.Input, &input); err != nil || input["path"] != "." {
+		t.Errorf("unexpected tool input: %s", blk.Input)
+	}
+}
+
+func TestMessageBuilder_EmptyResponse(t *testing.T) {
+	builder := NewMessageBuilder()
+	emitter := NewBlockEmitter(builder)
+	stopReason := emitter.Finish()
+
+	data, _ := json.Marshal(builder.Message("msg_1", "gpt-5", stopReason, "", types.Usage{}))
+	body := string(data)
+	for _, want := range []string{`"content":[{"type":"text","text":""}]`, `"stop_reason":"end_turn"`, `"stop_sequence":null`, `"type":"message"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in %s", want, body)
+		}
+	}
+}
+
+func TestMessageBuilder_StopSequence(t *testing.T) {
+	builder := NewMessageBuilder()
+	msg := builder.Message("msg_1", "gpt-5", StopReasonStopSequence, "END", types.Usage{})
+	if msg.StopSequence == nil || *msg.StopSequence != "END" || *msg.StopReason != "stop_sequence" {
+		t.Errorf("unexpected stop: %+v", msg)
+	}
+	if msg.Content == nil {
+		t.Error("content should be an empty array, not null")
+	}
+}
@@ -28,8 +28,13 @@ func (w *SSEWriter) SendEvent(event string, data interface{}) {
 	w.c.Writer.Flush()
 }
 
-// SendMessageStart 发送 message_start 事件
-func (w *SSEWriter) SendMessageStart(msgID, model string) {
+// SendMessageStart 发送 message_start 事件，inputTokens 为输入 token 数
+func (w *SSEWriter) SendMessageStart(msgID, model string, inputTokens int) {
+	w.SendMessageStartUsage(msgID, model, types.Usage{InputTokens: inputTokens})
+}
+
+// SendMessageStartUsage 发送 message_start 事件，usage 为初始用量（可包含缓存命中的输入用量）
+func (w *SSEWriter) SendMessageStartUsage(msgID, model string, usage types.Usage) {
 	w.SendEvent("message_start", types.MessageStartEvent{
 		Type: "message_start",
 		Message: types.MessageStartDetail{
@@ -38,7 +43,7 @@ func (w *SSEWriter) SendMessageStart(msgID, model string) {
 			Role:    "assistant",
 			Content: []types.ContentBlock{},
 			Model:   model,
-			Usage:   types.Usage{InputTokens: 100, OutputTokens: 0},
+			Usage:   types.Usage{InputTokens: usage.InputTokens, CacheReadInputTokens: usage.CacheReadInputTokens},
 		},
 	})
 }
@@ -61,6 +66,33 @@ func (w *SSEWriter) SendTextDelta(index int, text string) {
 	})
 }
 
+// SendThinkingBlockStart 发送思考块开始事件
+func (w *SSEWriter) SendThinkingBlockStart(index int) {
+	w.SendEvent("content_block_start", types.ContentBlockStartEvent{
+		Type:         "content_block_start",
+		Index:        index,
+		ContentBlock: types.ThinkingContentBlock{Type: "thinking", Thinking: ""},
+	})
+}
+
+// SendThinkingDelta 发送思考内容增量
+func (w *SSEWriter) SendThinkingDelta(index int, thinking string) {
+	w.SendEvent("content_block_delta", types.ContentBlockDeltaEvent{
+		Type:  "content_block_delta",
+		Index: index,
+		Delta: types.ThinkingDelta{Type: "thinking_delta", Thinking: thinking},
+	})
+}
+
+// SendSignatureDelta 发送思考块签名
+func (w *SSEWriter) SendSignatureDelta(index int, signature string) {
+	w.SendEvent("content_block_delta", types.ContentBlockDeltaEvent{
+		Type:  "content_block_delta",
+		Index: index,
+		Delta: types.SignatureDelta{Type: "signature_delta", Signature: signature},
+	})
+}
+
 // SendToolUseBlockStart 发送工具使用块开始事件
 func (w *SSEWriter) SendToolUseBlockStart(index int, id, name string) {
 	w.SendEvent("content_block_start", types.ContentBlockStartEvent{
@@ -92,12 +124,21 @@ func (w *SSEWriter) SendBlockStop(index int) {
 	})
 }
 
-// SendMessageDelta 发送消息增量事件
-func (w *SSEWriter) SendMessageDelta(stopReason string, outputTokens int) {
+// SendMessageDelta 发送消息增量事件，usage 为最终用量
+func (w *SSEWriter) SendMessageDelta(stopReason string, usage types.Usage) {
+	w.sendMessageDelta(types.MessageDelta{StopReason: stopReason}, usage)
+}
+
+// SendStopSequenceDelta 命中 stop sequence 时发送消息增量事件
+func (w *SSEWriter) SendStopSequenceDelta(sequence string, usage types.Usage) {
+	w.sendMessageDelta(types.MessageDelta{StopReason: StopReasonStopSequence, StopSequence: &sequence}, usage)
+}
+
+func (w *SSEWriter) sendMessageDelta(delta types.MessageDelta, usage types.Usage) {
 	w.SendEvent("message_delta", types.MessageDeltaEvent{
 		Type:  "message_delta",
-		Delta: types.MessageDelta{StopReason: stopReason},
-		Usage: types.DeltaUsage{OutputTokens: outputTokens},
+		Delta: delta,
+		Usage: types.DeltaUsage{InputTokens: usage.InputTokens, OutputTokens: usage.OutputTokens, CacheReadInputTokens: usage.CacheReadInputTokens},
 	})
 }
 
@@ -105,3 +146,11 @@ func (w *SSEWriter) SendMessageDelta(stopReason string, outputTokens int) {
 func (w *SSEWriter) SendMessageStop() {
 	w.SendEvent("message_stop", types.MessageStopEvent{Type: "message_stop"})
 }
+
+// SendError 发送 error 事件（流已开始后无法再返回 HTTP 错误码）
+func (w *SSEWriter) SendError(errType, message string) {
+	w.SendEvent("error", types.ErrorEvent{
+		Type:  "error",
+		Error: types.ErrorDetail{Type: errType, Message: message},
+	})
+}
@@ -66,7 +66,7 @@ func TestSSEWriter_SendMessageStart(t *testing.T) {
 	c, w := createTestContext()
 	sse := NewSSEWriter(c)
 
-	sse.SendMessageStart("msg_123", "claude-3-opus")
+	sse.SendMessageStart("msg_123", "claude-3-opus", 100)
 
 	body := w.Body.String()
 
@@ -96,6 +96,9 @@ func TestSSEWriter_SendMessageStart(t *testing.T) {
 	if event.Message.ID != "msg_123" {
 		t.Errorf("expected message ID 'msg_123', got '%s'", event.Message.ID)
 	}
+	if event.Message.Usage.InputTokens != 100 {
+		t.Errorf("expected input_tokens 100, got %d", event.Message.Usage.InputTokens)
+	}
 }
 
 // ==================== TextBlock 测试 ====================
@@ -253,7 +256,7 @@ func TestSSEWriter_SendMessageDelta_EndTurn(t *testing.T) {
 	c, w := createTestContext()
 	sse := NewSSEWriter(c)
 
-	sse.SendMessageDelta("end_turn", 150)
+	sse.SendMessageDelta("end_turn", types.Usage{OutputTokens: 150})
 
 	body := w.Body.String()
 
@@ -266,13 +269,29 @@ func TestSSEWriter_SendMessageDelta_EndTurn(t *testing.T) {
 	if !strings.Contains(body, `"output_tokens":150`) {
 		t.Errorf("expected output_tokens 150")
 	}
+	if strings.Contains(body, `"input_tokens"`) {
+		t.Errorf("expected input_tokens to be omitted when unknown")
+	}
+}
+
+func TestSSEWriter_SendMessageDelta_WithInputTokens(t *testing.T) {
+	c, w := createTestContext()
+	sse := NewSSEWriter(c)
+
+	sse.SendMessageDelta("end_turn", types.Usage{InputTokens: 1200, OutputTokens: 80})
+
+	body := w.Body.String()
+
+	if !strings.Contains(body, `"usage":{"input_tokens":1200,"output_tokens":80}`) {
+		t.Errorf("expected cumulative usage, got %s", body)
+	}
 }
 
 func TestSSEWriter_SendMessageDelta_ToolUse(t *testing.T) {
 	c, w := createTestContext()
 	sse := NewSSEWriter(c)
 
-	sse.SendMessageDelta("tool_use", 200)
+	sse.SendMessageDelta("tool_use", types.Usage{OutputTokens: 200})
 
 	body := w.Body.String()
 
@@ -299,6 +318,32 @@ func TestSSEWriter_SendMessageStop(t *testing.T) {
 	}
 }
 
+// ==================== Error 测试 ====================
+
+func TestSSEWriter_SendError(t *testing.T) {
+	c, w := createTestContext()
+	sse := NewSSEWriter(c)
+
+	sse.SendError("api_error", "upstream closed")
+
+	body := w.Body.String()
+
+	if !strings.Contains(body, "event: error") {
+		t.Errorf("expected error event")
+	}
+
+	var event types.ErrorEvent
+	if err := json.Unmarshal([]byte(extractJSONFromSSE(body)), &event); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if event.Error.Type != "api_error" {
+		t.Errorf("expected error type 'api_error', got '%s'", event.Error.Type)
+	}
+	if event.Error.Message != "upstream closed" {
+		t.Errorf("expected message 'upstream closed', got '%s'", event.Error.Message)
+	}
+}
+
 // ==================== 完整流程测试 ====================
 
 func TestSSEWriter_FullTextResponse(t *testing.T) {
@@ -306,12 +351,12 @@ func TestSSEWriter_FullTextResponse(t *testing.T) {
 	sse := NewSSEWriter(c)
 
 	// 模拟完整的文本响应流程
-	sse.SendMessageStart("msg_test", "claude-3-opus")
+	sse.SendMessageStart("msg_test", "claude-3-opus", 100)
 	sse.SendTextBlockStart(0)
 	sse.SendTextDelta(0, "Hello, ")
 	sse.SendTextDelta(0, "world!")
 	sse.SendBlockStop(0)
-	sse.SendMessageDelta("end_turn", 10)
+	sse.SendMessageDelta("end_turn", types.Usage{OutputTokens: 10})
 	sse.SendMessageStop()
 
 	body := w.Body.String()
@@ -345,7 +390,7 @@ func TestSSEWriter_FullToolUseResponse(t *testing.T) {
 	sse := NewSSEWriter(c)
 
 	// 模拟带工具调用的响应流程
-	sse.SendMessageStart("msg_tool", "claude-3-opus")
+	sse.SendMessageStart("msg_tool", "claude-3-opus", 100)
 
 	// 文本块
 	sse.SendTextBlockStart(0)
@@ -357,7 +402,7 @@ func TestSSEWriter_FullToolUseResponse(t *testing.T) {
 	sse.SendInputJSONDelta(1, `{"query": "test"}`)
 	sse.SendBlockStop(1)
 
-	sse.SendMessageDelta("tool_use", 50)
+	sse.SendMessageDelta("tool_use", types.Usage{OutputTokens: 50})
 	sse.SendMessageStop()
 
 	body := w.Body.String()
@@ -378,7 +423,7 @@ func TestSSEWriter_MultipleToolCalls(t *testing.T) {
 	c, w := createTestContext()
 	sse := NewSSEWriter(c)
 
-	sse.SendMessageStart("msg_multi", "claude-3-opus")
+	sse.SendMessageStart("msg_multi", "claude-3-opus", 100)
 
 	// 多个工具调用
 	for i := 0; i < 3; i++ {
@@ -387,7 +432,7 @@ func TestSSEWriter_MultipleToolCalls(t *testing.T) {
 		sse.SendBlockStop(i)
 	}
 
-	sse.SendMessageDelta("tool_use", 100)
+	sse.SendMessageDelta("tool_use", types.Usage{OutputTokens: 100})
 	sse.SendMessageStop()
 
 	body := w.Body.String()
@@ -0,0 +1,375 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"puter2api/internal/types"
+)
+
+const (
+	toolCallOpenTag  = "<tool_call>"
+	toolCallCloseTag = "</tool_call>"
+
+	// maxBufferedToolCall 无法流式解析的标签最多缓冲的字节数，超出仍未闭合时按文本下发
+	maxBufferedToolCall = 64 << 10
+)
+
+// ToolStreamEventType 流式解析事件类型
+type ToolStreamEventType int
+
+const (
+	// EventText 普通文本，可直接下发
+	EventText ToolStreamEventType = iota
+	// EventToolStart 工具调用开始（ID、Name 已确定）
+	EventToolStart
+	// EventToolInput 工具调用参数的 JSON 片段
+	EventToolInput
+	// EventToolEnd 工具调用结束
+	EventToolEnd
+)
+
+// ToolStreamEvent 流式解析事件
+type ToolStreamEvent struct {
+	Type        ToolStreamEventType
+	Text        string // EventText
+	ID          string // EventToolStart
+	Name        string // EventToolStart
+	PartialJSON string // EventToolInput
+}
+
+// toolCallHeaderRe 匹配 tool_call 开头的 name（及可选 id），直到 input 值开始处
+var toolCallHeaderRe = regexp.MustCompile(`^\s*\{\s*"name"\s*:\s*"((?:[^"\\]|\\.)*)"\s*,\s*(?:"id"\s*:\s*"((?:[^"\\]|\\.)*)"\s*,\s*)?"input"\s*:\s*`)
+
+// ToolCallParser 增量解析上游文本中的 <tool_call> 标签
+//
+// 普通文本立即放行，可能是标签开头的片段会被暂存；进入标签后若能识别出
+// name 和 input 的起始位置则边收边发 input JSON，否则缓冲到闭合标签再整体解析
+// （超过 maxBufferedToolCall 仍未闭合时按文本下发）。input JSON 未完整就遇到闭合标签时，
+// 补齐括号结束工具调用，标签之后的内容继续作为文本。
+type ToolCallParser struct {
+	pending string // 文本状态下暂存的内容（可能是被切断的开标签）
+	heldWS  string // 暂存的尾部空白，后面跟着文本时才下发
+
+	inTag     bool
+	tagWS     string // 进入标签前暂存的空白，标签未闭合退回文本时恢复
+	body      string // 标签内已接收的内容
+	streaming bool   // 是否已开始下发 input JSON
+	consumed  int    // streaming 模式下 body 中已处理的字节数
+	input     jsonValueScanner
+
+	blockStart bool // 当前处于新文本段的开头（需要去掉前导空白）
+	toolCount  int
+}
+
+// NewToolCallParser 创建流式工具调用解析器
+func NewToolCallParser() *ToolCallParser {
+	return &ToolCallParser{blockStart: true}
+}
+
+// ToolCallCount 已解析出的工具调用数量
+func (p *ToolCallParser) ToolCallCount() int {
+	return p.toolCount
+}
+
+// Feed 输入一段上游文本，返回可以立即下发的事件
+func (p *ToolCallParser) Feed(chunk string) []ToolStreamEvent {
+	var events []ToolStreamEvent
+	if p.inTag {
+		p.body += chunk
+	} else {
+		p.pending += chunk
+	}
+
+	for {
+		if p.inTag {
+			done, evs := p.processTag()
+			events = append(events, evs...)
+			if !done {
+				return events
+			}
+			continue
+		}
+
+		idx := strings.Index(p.pending, toolCallOpenTag)
+		if idx < 0 {
+			// 暂存可能是开标签前缀的尾部
+			hold := partialPrefixLen(p.pending, toolCallOpenTag)
+			events = append(events, p.emitText(p.pending[:len(p.pending)-hold])...)
+			p.pending = p.pending[len(p.pending)-hold:]
+			return events
+		}
+
+		events = append(events, p.emitText(p.pending[:idx])...)
+		p.tagWS = p.heldWS
+		p.heldWS = ""
+		p.inTag = true
+		p.body = p.pending[idx+len(toolCallOpenTag):]
+		p.pending = ""
+		p.streaming = false
+		p.consumed = 0
+		p.input = jsonValueScanner{}
+	}
+}
+
+// Finish 上游结束时调用，冲刷暂存内容
+func (p *ToolCallParser) Finish() []ToolStreamEvent {
+	var events []ToolStreamEvent
+	if p.inTag {
+		p.inTag = false
+		if p.streaming {
+			// 标签未闭合，但参数已开始下发，只能就此结束
+			events = append(events, ToolStreamEvent{Type: EventToolEnd})
+		} else if evs, ok := p.completeBufferedCall(p.body); ok {
+			events = append(events, evs...)
+		} else {
+			p.heldWS = p.tagWS
+			events = append(events, p.emitText(toolCallOpenTag+p.body)...)
+		}
+		p.body = ""
+	}
+	events = append(events, p.emitText(p.pending)...)
+	p.pending = ""
+	p.heldWS = ""
+	return events
+}
+
+// processTag 处理标签内的内容，返回标签是否已闭合
+func (p *ToolCallParser) processTag() (bool, []ToolStreamEvent) {
+	var events []ToolStreamEvent
+
+	if !p.streaming {
+		if m := toolCallHeaderRe.FindStringSubmatchIndex(p.body); m != nil {
+			rest := strings.TrimLeft(p.body[m[1]:], " \t\r\n")
+			if strings.HasPrefix(rest, "{") {
+				name := unquoteJSONString(p.body[m[2]:m[3]])
+				id := ""
+				if m[4] >= 0 {
+					id = unquoteJSONString(p.body[m[4]:m[5]])
+				}
+				if id == "" {
+					id = fmt.Sprintf("toolu_%d_%d", time.Now().UnixNano(), p.toolCount)
+				}
+				p.toolCount++
+				p.streaming = true
+				p.consumed = len(p.body) - len(rest)
+				events = append(events, ToolStreamEvent{Type: EventToolStart, ID: id, Name: name})
+			}
+		}
+	}
+
+	if p.streaming {
+		if !p.input.done {
+			n := p.input.feed(p.body[p.consumed:])
+			if n > 0 {
+				events = append(events, ToolStreamEvent{Type: EventToolInput, PartialJSON: p.body[p.consumed : p.consumed+n]})
+				p.consumed += n
+			}
+			if p.input.broken {
+				return p.abortInput(events)
+			}
+			if !p.input.done {
+				return false, events
+			}
+		}
+		// input 已完整，等待闭合标签
+		idx := strings.Index(p.body[p.consumed:], toolCallCloseTag)
+		if idx < 0 {
+			return false, events
+		}
+		rest := p.body[p.consumed+idx+len(toolCallCloseTag):]
+		p.finishTag(rest)
+		return true, append(events, ToolStreamEvent{Type: EventToolEnd})
+	}
+
+	idx := strings.Index(p.body, toolCallCloseTag)
+	if idx < 0 {
+		if len(p.body) > maxBufferedToolCall {
+			// 迟迟不闭合的标签不再扣留后续内容
+			p.inTag = false
+			p.heldWS = p.tagWS
+			events = append(events, p.emitText(toolCallOpenTag+p.body)...)
+			p.body = ""
+			return true, events
+		}
+		return false, events
+	}
+	body := p.body[:idx]
+	rest := p.body[idx+len(toolCallCloseTag):]
+	p.finishTag(rest)
+	if evs, ok := p.completeBufferedCall(body); ok {
+		events = append(events, evs...)
+	}
+	// 解析失败的标签与 ParseToolCalls 一致，直接丢弃
+	return true, events
+}
+
+// abortInput input JSON 未完整就出现了 JSON 之外的 '<'（通常是闭合标签）：补齐括号结束工具调用。
+// 闭合标签之后的内容作为文本继续处理；闭合标签可能被切断时等待更多内容
+func (p *ToolCallParser) abortInput(events []ToolStreamEvent) (bool, []ToolStreamEvent) {
+	rest := p.body[p.consumed:]
+	if len(rest) < len(toolCallCloseTag) && strings.HasPrefix(toolCallCloseTag, rest) {
+		return false, events
+	}
+	rest = strings.TrimPrefix(rest, toolCallCloseTag)
+	if closing := p.input.closing(); closing != "" {
+		events = append(events, ToolStreamEvent{Type: EventToolInput, PartialJSON: closing})
+	}
+	p.finishTag(rest)
+	return true, append(events, ToolStreamEvent{Type: EventToolEnd})
+}
+
+// finishTag 退出标签状态，把闭合标签之后的内容放回文本缓冲
+func (p *ToolCallParser) finishTag(rest string) {
+	p.inTag = false
+	p.body = ""
+	p.pending = rest
+	p.blockStart = true
+}
+
+// completeBufferedCall 整体解析缓冲的工具调用
+func (p *ToolCallParser) completeBufferedCall(body string) ([]ToolStreamEvent, bool) {
+	var call types.ParsedToolCall
+	if err := json.Unmarshal([]byte(strings.TrimSpace(body)), &call); err != nil {
+		return nil, false
+	}
+	if call.ID == "" {
+		call.ID = fmt.Sprintf("toolu_%d_%d", time.Now().UnixNano(), p.toolCount)
+	}
+	if len(call.Input) == 0 {
+		call.Input = json.RawMessage("{}")
+	}
+	p.toolCount++
+	return []ToolStreamEvent{
+		{Type: EventToolStart, ID: call.ID, Name: call.Name},
+		{Type: EventToolInput, PartialJSON: string(call.Input)},
+		{Type: EventToolEnd},
+	}, true
+}
+
+// emitText 下发文本：去掉文本段开头的空白，尾部空白暂存到后面有文本时再发
+func (p *ToolCallParser) emitText(text string) []ToolStreamEvent {
+	text = p.heldWS + text
+	p.heldWS = ""
+	if p.blockStart {
+		text = strings.TrimLeft(text, " \t\r\n")
+	}
+	trimmed := strings.TrimRight(text, " \t\r\n")
+	p.heldWS = text[len(trimmed):]
+	if trimmed == "" {
+		return nil
+	}
+	p.blockStart = false
+	return []ToolStreamEvent{{Type: EventText, Text: trimmed}}
+}
+
+// NativeToolEvents 将上游原生工具调用块转换为解析事件，input 为 JSON 字符串时先解码
+func NativeToolEvents(chunk types.PuterStreamChunk, index int) []ToolStreamEvent {
+	id := chunk.ID
+	if id == "" {
+		id = fmt.Sprintf("toolu_%d_%d", time.Now().UnixNano(), index)
+	}
+	return []ToolStreamEvent{
+		{Type: EventToolStart, ID: id, Name: chunk.Name},
+		{Type: EventToolInput, PartialJSON: string(NativeToolInput(chunk.Input))},
+		{Type: EventToolEnd},
+	}
+}
+
+// NativeToolInput 规范化原生工具调用的参数：空值为 {}，JSON 字符串解码为其内容
+func NativeToolInput(input json.RawMessage) json.RawMessage {
+	if len(input) == 0 || string(input) == "null" {
+		return json.RawMessage("{}")
+	}
+	var encoded string
+	if err := json.Unmarshal(input, &encoded); err == nil {
+		if encoded == "" || !json.Valid([]byte(encoded)) {
+			return json.RawMessage("{}")
+		}
+		return json.RawMessage(encoded)
+	}
+	return input
+}
+
+// partialPrefixLen 返回 s 末尾与 tag 开头重合的最长长度
+func partialPrefixLen(s, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// unquoteJSONString 解码 JSON 字符串内容（不含引号）
+func unquoteJSONString(s string) string {
+	var out string
+	if err := json.Unmarshal([]byte(`"`+s+`"`), &out); err != nil {
+		return s
+	}
+	return out
+}
+
+// jsonValueScanner 追踪一个 JSON 对象/数组何时结束
+type jsonValueScanner struct {
+	open     []byte // 尚未闭合的 { 和 [
+	inString bool
+	escape   bool
+	done     bool
+	broken   bool // 字符串之外出现了 '<'，JSON 不完整
+}
+
+// feed 扫描 data，返回属于当前 JSON 值的字节数；遇到字符串之外的 '<' 时停在它之前并标记 broken
+func (s *jsonValueScanner) feed(data string) int {
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		if s.inString {
+			switch {
+			case s.escape:
+				s.escape = false
+			case ch == '\\':
+				s.escape = true
+			case ch == '"':
+				s.inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			s.inString = true
+		case '<':
+			s.broken = true
+			return i
+		case '{', '[':
+			s.open = append(s.open, ch)
+		case '}', ']':
+			if len(s.open) > 0 {
+				s.open = s.open[:len(s.open)-1]
+			}
+			if len(s.open) == 0 {
+				s.done = true
+				return i + 1
+			}
+		}
+	}
+	return len(data)
+}
+
+// closing 返回补齐未闭合字符串和括号所需的后缀
+func (s *jsonValueScanner) closing() string {
+	var sb strings.Builder
+	if s.inString {
+		sb.WriteByte('"')
+	}
+	for i := len(s.open) - 1; i >= 0; i-- {
+		if s.open[i] == '{' {
+			sb.WriteByte('}')
+		} else {
+			sb.WriteByte(']')
+		}
+	}
+	return sb.String()
+}
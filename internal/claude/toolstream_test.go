@@ -0,0 +1,258 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"puter2api/internal/types"
+)
+
+// feedAll 按给定分片依次输入，返回所有事件
+func feedAll(chunks ...string) []ToolStreamEvent {
+	p := NewToolCallParser()
+	var events []ToolStreamEvent
+	for _, c := range chunks {
+		events = append(events, p.Feed(c)...)
+	}
+	return append(events, p.Finish()...)
+}
+
+// splitEvery 按固定长度切分字符串
+func splitEvery(s string, n int) []string {
+	var parts []string
+	for len(s) > n {
+		parts = append(parts, s[:n])
+		s = s[n:]
+	}
+	return append(parts, s)
+}
+
+// collectText 拼接全部文本事件
+func collectText(events []ToolStreamEvent) string {
+	var sb strings.Builder
+	for _, ev := range events {
+		if ev.Type == EventText {
+			sb.WriteString(ev.Text)
+		}
+	}
+	return sb.String()
+}
+
+// collectCalls 按工具调用聚合 name 与完整 input
+func collectCalls(events []ToolStreamEvent) []struct{ Name, Input string } {
+	var calls []struct{ Name, Input string }
+	for _, ev := range events {
+		switch ev.Type {
+		case EventToolStart:
+			calls = append(calls, struct{ Name, Input string }{Name: ev.Name})
+		case EventToolInput:
+			calls[len(calls)-1].Input += ev.PartialJSON
+		}
+	}
+	return calls
+}
+
+func TestToolCallParser_PlainTextPassesThrough(t *testing.T) {
+	p := NewToolCallParser()
+
+	events := p.Feed("Hello ")
+	if collectText(events) != "Hello" {
+		t.Errorf("expected text before trailing whitespace to be emitted immediately, got %q", collectText(events))
+	}
+
+	events = p.Feed("world, how are you?")
+	if collectText(events) != " world, how are you?" {
+		t.Errorf("expected held whitespace to be flushed with following text, got %q", collectText(events))
+	}
+}
+
+func TestToolCallParser_HoldsPartialOpenTag(t *testing.T) {
+	p := NewToolCallParser()
+
+	events := p.Feed("Let me check.<tool_")
+	if collectText(events) != "Let me check." {
+		t.Errorf("expected partial tag to be held back, got %q", collectText(events))
+	}
+
+	events = p.Feed("call>{\"name\": \"search\", \"input\": {\"q\": \"go\"}}</tool_call>")
+	calls := collectCalls(events)
+	if len(calls) != 1 || calls[0].Name != "search" {
+		t.Fatalf("expected one search call, got %+v", calls)
+	}
+	if calls[0].Input != `{"q": "go"}` {
+		t.Errorf("unexpected input: %s", calls[0].Input)
+	}
+}
+
+func TestToolCallParser_FalsePartialTagIsFlushed(t *testing.T) {
+	events := feedAll("a <tool", "box> b")
+
+	if collectText(events) != "a <toolbox> b" {
+		t.Errorf("expected non-tag text to pass through, got %q", collectText(events))
+	}
+	if len(collectCalls(events)) != 0 {
+		t.Errorf("expected no tool calls")
+	}
+}
+
+func TestToolCallParser_StreamsInputIncrementally(t *testing.T) {
+	p := NewToolCallParser()
+
+	events := p.Feed(`<tool_call>{"name": "write", "input": {"content": "abc`)
+	if len(events) < 2 || events[0].Type != EventToolStart || events[0].Name != "write" {
+		t.Fatalf("expected tool start before input is complete, got %+v", events)
+	}
+	if events[1].Type != EventToolInput || events[1].PartialJSON != `{"content": "abc` {
+		t.Errorf("expected partial input to be emitted, got %+v", events[1])
+	}
+
+	events = p.Feed(`def"}}</tool_call>`)
+	if len(events) != 2 || events[0].PartialJSON != `def"}` || events[1].Type != EventToolEnd {
+		t.Errorf("expected remaining input and end, got %+v", events)
+	}
+}
+
+func TestToolCallParser_MultipleToolCalls(t *testing.T) {
+	text := "I'll run both.\n<tool_call>\n{\"name\": \"a\", \"input\": {\"x\": 1}}\n</tool_call>\n<tool_call>\n{\"name\": \"b\", \"input\": {\"y\": [1, {\"z\": \"}\"}]}}\n</tool_call>"
+
+	for _, size := range []int{1, 3, 7, len(text)} {
+		events := feedAll(splitEvery(text, size)...)
+
+		calls := collectCalls(events)
+		if len(calls) != 2 {
+			t.Fatalf("chunk size %d: expected 2 calls, got %d", size, len(calls))
+		}
+		if calls[0].Name != "a" || calls[1].Name != "b" {
+			t.Errorf("chunk size %d: unexpected names %+v", size, calls)
+		}
+		if !json.Valid([]byte(calls[1].Input)) || calls[1].Input != `{"y": [1, {"z": "}"}]}` {
+			t.Errorf("chunk size %d: unexpected input %s", size, calls[1].Input)
+		}
+		if collectText(events) != "I'll run both." {
+			t.Errorf("chunk size %d: unexpected text %q", size, collectText(events))
+		}
+	}
+}
+
+func TestToolCallParser_UsesProvidedID(t *testing.T) {
+	events := feedAll(`<tool_call>{"name": "t", "id": "toolu_fixed", "input": {}}</tool_call>`)
+
+	if events[0].Type != EventToolStart || events[0].ID != "toolu_fixed" {
+		t.Errorf("expected provided id, got %+v", events[0])
+	}
+}
+
+func TestToolCallParser_BufferedWhenInputBeforeName(t *testing.T) {
+	events := feedAll(`<tool_call>{"input": {"a": 1}, "name": "late"}`, `</tool_call>`)
+
+	calls := collectCalls(events)
+	if len(calls) != 1 || calls[0].Name != "late" {
+		t.Fatalf("expected buffered call to be parsed, got %+v", calls)
+	}
+	if calls[0].Input != `{"a": 1}` {
+		t.Errorf("unexpected input %s", calls[0].Input)
+	}
+}
+
+func TestToolCallParser_MalformedCallDropped(t *testing.T) {
+	events := feedAll("before <tool_call>{invalid}</tool_call> after")
+
+	if len(collectCalls(events)) != 0 {
+		t.Errorf("expected malformed call to be dropped")
+	}
+	if collectText(events) != "beforeafter" {
+		t.Errorf("unexpected text %q", collectText(events))
+	}
+}
+
+func TestToolCallParser_UnclosedTagBecomesText(t *testing.T) {
+	events := feedAll("Example: <tool_call> not json")
+
+	if len(collectCalls(events)) != 0 {
+		t.Errorf("expected no calls")
+	}
+	if collectText(events) != "Example: <tool_call> not json" {
+		t.Errorf("unexpected text %q", collectText(events))
+	}
+}
+
+func TestToolCallParser_TextAfterToolCall(t *testing.T) {
+	events := feedAll(`<tool_call>{"name": "a", "input": {}}</tool_call>`, "\n\nDone.")
+
+	last := events[len(events)-1]
+	if last.Type != EventText || last.Text != "Done." {
+		t.Errorf("expected trailing text block, got %+v", last)
+	}
+}
+
+func TestToolCallParser_MatchesParseToolCalls(t *testing.T) {
+	text := "Sure.\n<tool_call>\n{\"name\": \"read\", \"input\": {\"path\": \"/tmp/a\"}}\n</tool_call>"
+
+	want, wantText := ParseToolCalls(text)
+	events := feedAll(splitEvery(text, 5)...)
+	got := collectCalls(events)
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d calls, got %d", len(want), len(got))
+	}
+	if got[0].Name != want[0].Name || got[0].Input != string(want[0].Input) {
+		t.Errorf("expected %+v, got %+v", want[0], got[0])
+	}
+	if collectText(events) != wantText {
+		t.Errorf("expected text %q, got %q", wantText, collectText(events))
+	}
+}
+
+func TestNativeToolEvents(t *testing.T) {
+	events := NativeToolEvents(types.PuterStreamChunk{Type: "tool_use", ID: "toolu_9", Name: "ls", Input: json.RawMessage(`"{\"path\":\".\"}"`)}, 0)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0].ID != "toolu_9" || events[0].Name != "ls" {
+		t.Errorf("unexpected start event: %+v", events[0])
+	}
+	if events[1].PartialJSON != `{"path":"."}` {
+		t.Errorf("string input should be decoded, got %s", events[1].PartialJSON)
+	}
+
+	events = NativeToolEvents(types.PuterStreamChunk{Type: "tool_use", Name: "noop"}, 1)
+	if events[0].ID == "" || events[1].PartialJSON != "{}" {
+		t.Errorf("expected generated id and empty input, got %+v", events)
+	}
+}
+
+func TestToolCallParser_UnbalancedInputClosedByTag(t *testing.T) {
+	text := `<tool_call>{"name": "a", "input": {"x": [1, 2</tool_call> after text`
+	events := feedAll(splitEvery(text, 3)...)
+
+	calls := collectCalls(events)
+	if len(calls) != 1 || calls[0].Input != `{"x": [1, 2]}` {
+		t.Fatalf("expected repaired input, got %+v", calls)
+	}
+	if !json.Valid([]byte(calls[0].Input)) {
+		t.Errorf("expected valid JSON, got %s", calls[0].Input)
+	}
+	// 闭合标签之后的内容应立即作为文本下发，而不是等到 Finish
+	p := NewToolCallParser()
+	var streamed []ToolStreamEvent
+	for _, c := range splitEvery(text, 3) {
+		streamed = append(streamed, p.Feed(c)...)
+	}
+	if collectText(streamed) != "after text" {
+		t.Errorf("expected trailing text before Finish, got %q", collectText(streamed))
+	}
+}
+
+func TestToolCallParser_UnclosedBufferedTagIsCapped(t *testing.T) {
+	p := NewToolCallParser()
+	events := p.Feed(`<tool_call>{"input": "` + strings.Repeat("x", maxBufferedToolCall) + `"`)
+	events = append(events, p.Feed(" and more text")...)
+
+	if len(collectCalls(events)) != 0 {
+		t.Errorf("expected no calls")
+	}
+	if text := collectText(events); !strings.HasPrefix(text, "<tool_call>") || !strings.HasSuffix(text, " and more text") {
+		t.Errorf("expected oversized tag to be flushed as text before Finish")
+	}
+}
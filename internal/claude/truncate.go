@@ -0,0 +1,203 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"puter2api/internal/tokenizer"
+	"puter2api/internal/types"
+)
+
+// omittedMarker 截断处插入的提示，让模型知道更早的对话已被省略
+const omittedMarker = "[Earlier conversation omitted: %d messages]"
+
+// summaryMarker 压缩后替换历史的摘要
+const summaryMarker = "[Summary of %d earlier messages]\n%s"
+
+// compactionHeadroom 生成新摘要时保留部分最多占用可用预算的比例（1/n），
+// 给后续轮次留出空间，使它们能复用同一份缓存的摘要
+const compactionHeadroom = 4
+
+// Compactor 将截断掉的历史压缩为摘要（见 ConvertOptions.Compactor）
+type Compactor interface {
+	// Cached 返回 prefix 已缓存的摘要
+	Cached(prefix []types.PuterMessage) (string, bool)
+	// Summarize 生成 prefix 的摘要
+	Summarize(prefix []types.PuterMessage) (string, error)
+	// Compacted 报告本次转换使用了摘要，messages 为被替换的历史消息条数
+	Compacted(messages int)
+}
+
+// truncateMessages 在 budget 内按对话单元截断消息：
+//
+//   - 只在安全位置截断（见 cutPoints），不会拆开 tool_use / tool_result 配对或多段 assistant 回复
+//   - 保留第一条 user 消息（之前的非 user 消息丢弃），并在它之后插入省略提示
+//   - 第一条 user 消息本身放不下时放弃保留，从能放下的最早用户轮次开始，省略提示置于其开头
+//
+// compactor 非 nil 时先尝试用摘要代替省略提示（见 compactMessages）。
+// src 与 messages 一一对应。放不下时返回 false 和无法再截断的最少输入 token 数
+func truncateMessages(src []types.ClaudeMessage, messages []types.PuterMessage, enc tokenizer.Encoder, budget int, compactor Compactor, summaryTokens int) ([]types.PuterMessage, int, bool) {
+	first := -1
+	for i, m := range messages {
+		if m.Role == "user" {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil, 0, true
+	}
+
+	// suffix[i] 为 messages[i:] 的 token 数
+	suffix := make([]int, len(messages)+1)
+	for i := len(messages) - 1; i >= first; i-- {
+		suffix[i] = suffix[i+1] + tokenizer.CountMessage(enc, messages[i])
+	}
+	if suffix[first] <= budget {
+		return messages[first:], 0, true
+	}
+
+	// 省略提示的长度与省略条数基本无关，按最长的情况计数
+	marker := tokenizer.CountMessage(enc, types.PuterMessage{Content: fmt.Sprintf(omittedMarker, len(messages))})
+	pinned := suffix[first] - suffix[first+1] + marker
+
+	cuts := cutPoints(src, first+1)
+	if compactor != nil {
+		if kept, ok := compactMessages(compactor, messages, suffix, first, cuts, enc, budget, summaryTokens); ok {
+			return kept, 0, true
+		}
+	}
+	minInput := suffix[first]
+	for _, i := range cuts {
+		if pinned+suffix[i] <= budget {
+			return withOmitted(messages[first], messages[i:], fmt.Sprintf(omittedMarker, i-first-1)), 0, true
+		}
+		minInput = min(minInput, pinned+suffix[i])
+	}
+	for _, i := range cuts {
+		if messages[i].Role != "user" {
+			continue
+		}
+		if marker+suffix[i] <= budget {
+			return prependOmitted(messages[i:], i-first), 0, true
+		}
+		minInput = min(minInput, marker+suffix[i])
+	}
+	return nil, minInput, false
+}
+
+// cutPoints 返回 from 之后可以截断的位置（升序）：保留的部分从这里开始
+//
+//   - 不含 tool_result 的 user 消息：一个新的用户轮次
+//   - 紧跟在 tool_result 之后的 assistant 消息：agent 循环中的新一步，之前的工具调用都已有结果
+func cutPoints(src []types.ClaudeMessage, from int) []int {
+	var cuts []int
+	for i := max(from, 1); i < len(src); i++ {
+		switch src[i].Role {
+		case "user":
+			if !hasToolResult(&src[i]) {
+				cuts = append(cuts, i)
+			}
+		case "assistant":
+			if src[i-1].Role == "user" && hasToolResult(&src[i-1]) {
+				cuts = append(cuts, i)
+			}
+		}
+	}
+	return cuts
+}
+
+// hasToolResult 消息是否包含 tool_result 块；字符串内容中模拟的 <tool_result> 标签同样计入
+// （OpenAI 请求在 prompt 中模拟工具调用时，tool 消息会被转换为这种形式）
+func hasToolResult(m *types.ClaudeMessage) bool {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return strings.Contains(text, "<tool_result")
+	}
+	var blocks []types.ContentBlock
+	if err := json.Unmarshal(m.Content, &blocks); err != nil {
+		return false
+	}
+	for _, blk := range blocks {
+		if blk.Type == "tool_result" {
+			return true
+		}
+	}
+	return false
+}
+
+// compactMessages 用摘要替换第一条 user 消息与截断位置之间的历史。优先使用已有缓存摘要的截断位置；
+// 否则在保留部分不超过可用预算 1/compactionHeadroom 的最早位置生成新摘要，都不满足时取最靠后的位置。
+// 摘要失败或放不下时返回 false，由调用方退回截断
+func compactMessages(c Compactor, messages []types.PuterMessage, suffix []int, first int, cuts []int, enc tokenizer.Encoder, budget, summaryTokens int) ([]types.PuterMessage, bool) {
+	head := suffix[first] - suffix[first+1]
+	avail := budget - head - summaryTokens
+	var fitting []int
+	for _, i := range cuts {
+		if suffix[i] <= avail {
+			fitting = append(fitting, i)
+		}
+	}
+	if len(fitting) == 0 {
+		return nil, false
+	}
+
+	summarized := func(i int, summary string) ([]types.PuterMessage, bool) {
+		note := types.PuterMessage{Content: fmt.Sprintf(summaryMarker, i-first-1, summary)}
+		if head+tokenizer.CountMessage(enc, note)+suffix[i] > budget {
+			return nil, false
+		}
+		c.Compacted(i - first - 1)
+		return withOmitted(messages[first], messages[i:], note.Content), true
+	}
+
+	for _, i := range fitting {
+		if summary, ok := c.Cached(messages[first+1 : i]); ok {
+			if kept, ok := summarized(i, summary); ok {
+				return kept, true
+			}
+		}
+	}
+
+	cut := fitting[len(fitting)-1]
+	for _, i := range fitting {
+		if suffix[i] <= avail/compactionHeadroom {
+			cut = i
+			break
+		}
+	}
+	summary, err := c.Summarize(messages[first+1 : cut])
+	if err != nil {
+		return nil, false
+	}
+	return summarized(cut, summary)
+}
+
+// withOmitted 拼接首条 user 消息、省略提示（或摘要）和保留的对话。保留部分以 user 开始时提示作为 assistant 消息插入，
+// 否则附加在首条 user 消息末尾，保持 user / assistant 交替
+func withOmitted(first types.PuterMessage, tail []types.PuterMessage, marker string) []types.PuterMessage {
+	result := make([]types.PuterMessage, 0, len(tail)+2)
+	if tail[0].Role == "user" {
+		result = append(result, first, types.PuterMessage{Role: "assistant", Content: marker})
+		return append(result, tail...)
+	}
+
+	first.Content += "\n\n" + marker
+	if len(first.Parts) > 0 {
+		first.Parts = append(first.Parts[:len(first.Parts):len(first.Parts)], types.PuterContentPart{Type: "text", Text: marker})
+	}
+	result = append(result, first)
+	return append(result, tail...)
+}
+
+// prependOmitted 不保留首条 user 消息时，把省略提示加在保留部分的第一条 user 消息开头
+func prependOmitted(tail []types.PuterMessage, omitted int) []types.PuterMessage {
+	marker := fmt.Sprintf(omittedMarker, omitted)
+	first := tail[0]
+	first.Content = marker + "\n\n" + first.Content
+	if len(first.Parts) > 0 {
+		first.Parts = append([]types.PuterContentPart{{Type: "text", Text: marker}}, first.Parts...)
+	}
+	return append([]types.PuterMessage{first}, tail[1:]...)
+}
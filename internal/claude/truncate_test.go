@@ -0,0 +1,194 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"puter2api/internal/types"
+)
+
+func textMessage(role, text string) types.ClaudeMessage {
+	content, _ := json.Marshal(text)
+	return types.ClaudeMessage{Role: role, Content: content}
+}
+
+func blocksMessage(role, blocks string) types.ClaudeMessage {
+	return types.ClaudeMessage{Role: role, Content: json.RawMessage(blocks)}
+}
+
+func TestTruncate_KeepsFirstUserMessage(t *testing.T) {
+	filler := strings.Repeat("word ", 400)
+	messages := []types.ClaudeMessage{
+		textMessage("user", "Original task"),
+		textMessage("assistant", filler),
+		textMessage("user", filler),
+		textMessage("assistant", filler),
+		textMessage("user", "Latest question"),
+	}
+
+	result, err := ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 {
+		t.Fatalf("expected first message, marker and latest turn, got %d messages", len(result))
+	}
+	if result[0].Content != "Original task" {
+		t.Errorf("expected first user message to be kept, got %q", result[0].Content)
+	}
+	if result[1].Role != "assistant" || result[1].Content != "[Earlier conversation omitted: 3 messages]" {
+		t.Errorf("unexpected marker %+v", result[1])
+	}
+	if result[2].Content != "Latest question" {
+		t.Errorf("expected latest question, got %q", result[2].Content)
+	}
+}
+
+func TestTruncate_KeepsToolPairs(t *testing.T) {
+	big := strings.Repeat("line ", 800)
+	messages := []types.ClaudeMessage{
+		textMessage("user", "Fix the bug"),
+		blocksMessage("assistant", `[{"type":"tool_use","id":"call_1","name":"read","input":{"path":"a.go"}}]`),
+		blocksMessage("user", `[{"type":"tool_result","tool_use_id":"call_1","content":"`+big+`"}]`),
+		blocksMessage("assistant", `[{"type":"text","text":"Now edit"},{"type":"tool_use","id":"call_2","name":"edit","input":{"path":"a.go"}}]`),
+		blocksMessage("user", `[{"type":"tool_result","tool_use_id":"call_2","content":"ok"}]`),
+	}
+
+	result, err := ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(result))
+	}
+	// 保留部分以 assistant 开始，省略提示附加在首条 user 消息末尾
+	if !strings.HasPrefix(result[0].Content, "Fix the bug") || !strings.Contains(result[0].Content, "omitted: 2 messages") {
+		t.Errorf("unexpected first message %q", result[0].Content)
+	}
+	if result[1].Role != "assistant" || !strings.Contains(result[1].Content, "call_2") {
+		t.Errorf("expected second tool call to be kept, got %+v", result[1])
+	}
+	for _, m := range result {
+		if strings.Contains(m.Content, "call_1") {
+			t.Errorf("dropped tool exchange should not be partially kept: %q", m.Content)
+		}
+	}
+}
+
+func TestTruncate_NativeToolsMarkerPart(t *testing.T) {
+	messages := []types.ClaudeMessage{
+		blocksMessage("user", `[{"type":"text","text":"Task"},{"type":"image","source":{"type":"url","url":"https://example.com/a.png"}}]`),
+		blocksMessage("assistant", `[{"type":"tool_use","id":"call_1","name":"read","input":{}}]`),
+		blocksMessage("user", `[{"type":"tool_result","tool_use_id":"call_1","content":"`+strings.Repeat("x ", 3000)+`"}]`),
+		textMessage("assistant", "Done"),
+	}
+
+	// 图片按固定开销计数
+	result, err := ConvertMessagesWith(messages, "", ConvertOptions{NativeTools: true, ContextWindow: 2500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(result))
+	}
+	parts := result[0].Parts
+	if len(parts) == 0 || parts[len(parts)-1].Type != "text" || !strings.Contains(parts[len(parts)-1].Text, "omitted") {
+		t.Errorf("expected marker part, got %+v", parts)
+	}
+	// 原消息的内容片段不应被修改
+	if orig := GetMessageParts(&messages[0]); len(orig) != 2 {
+		t.Errorf("expected original parts to be untouched, got %d", len(orig))
+	}
+}
+
+func TestTruncate_NoSafeCut(t *testing.T) {
+	messages := []types.ClaudeMessage{
+		textMessage("user", "Task"),
+		blocksMessage("assistant", `[{"type":"tool_use","id":"call_1","name":"read","input":{}}]`),
+		blocksMessage("user", `[{"type":"tool_result","tool_use_id":"call_1","content":"`+strings.Repeat("x ", 800)+`"}]`),
+	}
+
+	if _, err := ConvertMessagesWith(messages, "", ConvertOptions{ContextWindow: 300}); err == nil {
+		t.Error("expected overflow when the only tool exchange cannot be split")
+	}
+}
+
+// fakeCompactor 按前缀条数返回摘要的测试压缩器
+type fakeCompactor struct {
+	cached    map[int]string
+	calls     int
+	compacted int
+	err       error
+}
+
+func (f *fakeCompactor) Cached(prefix []types.PuterMessage) (string, bool) {
+	s, ok := f.cached[len(prefix)]
+	return s, ok
+}
+
+func (f *fakeCompactor) Summarize(prefix []types.PuterMessage) (string, error) {
+	f.calls++
+	return "summary", f.err
+}
+
+func (f *fakeCompactor) Compacted(messages int) {
+	f.compacted = messages
+}
+
+func compactionMessages() []types.ClaudeMessage {
+	filler := strings.Repeat("word ", 200)
+	messages := []types.ClaudeMessage{textMessage("user", "Original task")}
+	for range 5 {
+		messages = append(messages, textMessage("assistant", filler), textMessage("user", filler))
+	}
+	return messages
+}
+
+func TestCompact_ReplacesPrefixWithSummary(t *testing.T) {
+	f := &fakeCompactor{}
+	opts := ConvertOptions{ContextWindow: 1000, Compactor: f, SummaryTokens: 100}
+	result, err := ConvertMessagesWith(compactionMessages(), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 1 || f.compacted == 0 {
+		t.Fatalf("expected one summary, calls=%d compacted=%d", f.calls, f.compacted)
+	}
+	if result[0].Content != "Original task" || result[1].Role != "assistant" || !strings.HasSuffix(result[1].Content, "\nsummary") {
+		t.Errorf("unexpected head %+v %+v", result[0], result[1])
+	}
+	// 新摘要给后续轮次留出空间：保留部分不超过可用预算的 1/compactionHeadroom
+	if kept := len(result) - 2; kept != 1 {
+		t.Errorf("expected 1 kept message after summary, got %d", kept)
+	}
+}
+
+func TestCompact_ReusesCachedSummary(t *testing.T) {
+	// 缓存了较短前缀的摘要时优先复用，不再调用摘要模型
+	f := &fakeCompactor{cached: map[int]string{5: "cached"}}
+	opts := ConvertOptions{ContextWindow: 1200, Compactor: f, SummaryTokens: 100}
+	result, err := ConvertMessagesWith(compactionMessages(), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 0 || f.compacted != 5 {
+		t.Fatalf("expected cached summary, calls=%d compacted=%d", f.calls, f.compacted)
+	}
+	if !strings.HasPrefix(result[1].Content, "[Summary of 5 earlier messages]") || len(result) != 7 {
+		t.Errorf("unexpected result: %d messages, %q", len(result), result[1].Content)
+	}
+}
+
+func TestCompact_FallsBackToTruncation(t *testing.T) {
+	f := &fakeCompactor{err: errors.New("upstream down")}
+	opts := ConvertOptions{ContextWindow: 1000, Compactor: f, SummaryTokens: 100}
+	result, err := ConvertMessagesWith(compactionMessages(), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.compacted != 0 || !strings.HasPrefix(result[1].Content, "[Earlier conversation omitted") {
+		t.Errorf("expected truncation marker, got %q", result[1].Content)
+	}
+}
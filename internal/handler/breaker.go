@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListBreakers 获取驱动熔断器状态
+func (h *Handler) ListBreakers(c *gin.Context) {
+	cfg := h.breakers.Config()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   h.breakers.Enabled(),
+		"threshold": cfg.Threshold,
+		"cooldown":  cfg.Cooldown.String(),
+		"per_model": cfg.PerModel,
+		"breakers":  h.breakers.List(),
+	})
+}
+
+// ResetBreaker 手动恢复熔断器：DELETE /api/breakers?key=xxx，不带 key 时恢复全部
+func (h *Handler) ResetBreaker(c *gin.Context) {
+	h.breakers.Reset(c.Query("key"))
+	c.JSON(http.StatusOK, gin.H{"message": "breaker reset"})
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"puter2api/internal/cache"
+	"puter2api/internal/puter"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// 响应缓存相关请求头
+const (
+	cacheBypassHeader = "X-Cache-Bypass" // 客户端设为 true 时不读也不写响应缓存
+	cacheStatusHeader = "X-Cache"        // 响应头：HIT / MISS / BYPASS
+)
+
+// cacheTicket 一次请求的缓存状态
+type cacheTicket struct {
+	hit    bool // 命中响应缓存
+	shared bool // 共享了进行中的相同请求
+}
+
+// cacheBypassed 客户端是否要求跳过缓存：X-Cache-Bypass: true 或 Cache-Control: no-cache / no-store
+func cacheBypassed(c *gin.Context) bool {
+	if v := c.GetHeader(cacheBypassHeader); v == "1" || strings.EqualFold(v, "true") {
+		return true
+	}
+	cc := strings.ToLower(c.GetHeader("Cache-Control"))
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store")
+}
+
+// openCached 先查响应缓存，命中时回放缓存内容，否则调用上游（含降级、合并相同请求）
+// 上游完整返回后响应写入缓存；客户端要求跳过或请求不确定（见 cache.Cacheable）时不读也不写缓存
+func (h *Handler) openCached(c *gin.Context, api string, req puter.ChatRequest, prepare prepareFunc) (*puter.Stream, puter.ChatRequest, cacheTicket, error) {
+	var ticket cacheTicket
+	key := cache.Key(req)
+	cacheable := false
+	if h.cache.Enabled() {
+		if cacheBypassed(c) || !cache.Cacheable(req) {
+			c.Header(cacheStatusHeader, "BYPASS")
+		} else {
+			if body, ok := h.cache.Get(key); ok {
+				log.Info().Str("api", api).Str("model", req.Model).Msg("命中响应缓存")
+				c.Header(cacheStatusHeader, "HIT")
+				ticket.hit = true
+				return puter.NewReplayStream(io.NopCloser(bytes.NewReader(body))), req, ticket, nil
+			}
+			c.Header(cacheStatusHeader, "MISS")
+			cacheable = true
+		}
+	}
+
+	stream, chatReq, shared, err := h.openShared(c.Request.Context(), api, key, req, prepare, cacheable)
+	ticket.shared = shared
+	return stream, chatReq, ticket, err
+}
+
+// CacheStats 获取响应缓存统计
+func (h *Handler) CacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.cache.Stats())
+}
+
+// ClearCache 清空响应缓存
+func (h *Handler) ClearCache(c *gin.Context) {
+	h.cache.Clear()
+	c.JSON(http.StatusOK, gin.H{"message": "cache cleared"})
+}
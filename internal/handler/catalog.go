@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"puter2api/internal/catalog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// catalogRefreshTimeout 手动刷新模型目录的超时
+const catalogRefreshTimeout = 60 * time.Second
+
+// CatalogStatus 获取模型目录状态
+func (h *Handler) CatalogStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.catalog.Status())
+}
+
+// RefreshCatalog 立即从上游同步模型目录，返回新增和移除的模型
+func (h *Handler) RefreshCatalog(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), catalogRefreshTimeout)
+	defer cancel()
+
+	diff, err := h.catalog.Refresh(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"diff":   diff,
+		"status": h.catalog.Status(),
+	})
+}
+
+// ListAliases 获取所有模型别名
+func (h *Handler) ListAliases(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"aliases": h.aliases.List()})
+}
+
+// SetAlias 添加或更新模型别名
+func (h *Handler) SetAlias(c *gin.Context) {
+	var req struct {
+		Alias  string `json:"alias"`  // 客户端使用的模型 ID
+		Target string `json:"target"` // 实际的 Puter 模型
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req.Alias, req.Target = strings.TrimSpace(req.Alias), strings.TrimSpace(req.Target)
+	if err := h.aliases.Set(req.Alias, req.Target); err != nil {
+		if errors.Is(err, catalog.ErrInvalidAlias) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "alias saved", "alias": req.Alias, "target": req.Target})
+}
+
+// DeleteAlias 删除模型别名：DELETE /api/aliases?alias=xxx（模型 ID 可能含 / 和 :，因此不放在路径中）
+func (h *Handler) DeleteAlias(c *gin.Context) {
+	alias := c.Query("alias")
+	if alias == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "alias is required"})
+		return
+	}
+	if err := h.aliases.Delete(alias); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "alias deleted"})
+}
@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"puter2api/internal/cache"
+	"puter2api/internal/claude"
+	"puter2api/internal/puter"
+	"puter2api/internal/tokenizer"
+	"puter2api/internal/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// compactedHeader 响应头：超出上下文窗口的历史被摘要替换时，值为被替换的消息条数
+// 这是向客户端报告压缩的唯一途径，响应中的 usage 只含标准字段；服务端在请求完成日志的 compacted 字段记录同一数值
+const compactedHeader = "X-Context-Compacted"
+
+// summaryPrompt 摘要模型的 system prompt
+const summaryPrompt = `You compress conversation history for another AI assistant that will continue the conversation.
+Summarize the transcript below. Preserve the user's goals and constraints, decisions made, facts and results learned,
+tool calls and their outcomes, file names, identifiers and any open tasks. Omit pleasantries.
+Write the summary as plain text without any preamble.`
+
+// CompactionConfig 上下文压缩配置：超出上下文窗口的历史交给较便宜的模型生成摘要，而不是直接丢弃
+type CompactionConfig struct {
+	Model     string        // 生成摘要的模型，为空时关闭压缩，只截断
+	MaxTokens int           // 摘要的 token 上限
+	CacheTTL  time.Duration // 摘要缓存有效期
+}
+
+// CompactionFromEnv 从环境变量读取上下文压缩配置，未设置或非法的项使用默认值
+//
+//	COMPACTION_MODEL       生成摘要的模型，如 gpt-5-nano；留空关闭
+//	COMPACTION_MAX_TOKENS  摘要的 token 上限，默认 1024
+//	COMPACTION_CACHE_TTL   摘要缓存有效期，默认 24h
+func CompactionFromEnv() CompactionConfig {
+	cfg := CompactionConfig{
+		Model:     os.Getenv("COMPACTION_MODEL"),
+		MaxTokens: 1024,
+		CacheTTL:  24 * time.Hour,
+	}
+	if v, err := strconv.Atoi(os.Getenv("COMPACTION_MAX_TOKENS")); err == nil && v > 0 {
+		cfg.MaxTokens = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("COMPACTION_CACHE_TTL")); err == nil && v > 0 {
+		cfg.CacheTTL = v
+	}
+	return cfg
+}
+
+// summaryFailureCooldown 摘要失败后暂停压缩的时间，期间直接截断，避免后续轮次反复调用失败的摘要模型
+const summaryFailureCooldown = time.Minute
+
+// errSummaryCooldown 摘要模型最近失败，暂不压缩
+var errSummaryCooldown = errors.New("summary model failed recently")
+
+// compactor 上下文压缩器，摘要按被摘要前缀的哈希缓存，后续轮次可以直接复用
+type compactor struct {
+	cfg       CompactionConfig
+	summaries *cache.Cache
+
+	mu          sync.Mutex
+	failedUntil time.Time // 摘要失败后的冷却截止时间
+}
+
+// newCompactor 创建压缩器，未配置摘要模型时返回 nil
+func newCompactor(cfg CompactionConfig) *compactor {
+	if cfg.Model == "" {
+		return nil
+	}
+	summaries := cache.New(cache.Config{
+		Backend:    cache.BackendMemory,
+		TTL:        cfg.CacheTTL,
+		MaxEntries: 1000,
+		MaxBytes:   16 << 20,
+	}, nil)
+	return &compactor{cfg: cfg, summaries: summaries}
+}
+
+// key 摘要缓存键：摘要模型和被摘要的消息
+func (p *compactor) key(prefix []types.PuterMessage) string {
+	return cache.Key(puter.ChatRequest{Model: p.cfg.Model, Messages: prefix})
+}
+
+// coolingDown 摘要模型是否处于失败冷却期
+func (p *compactor) coolingDown() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(p.failedUntil)
+}
+
+// failed 记录一次摘要失败，开始冷却
+func (p *compactor) failed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failedUntil = time.Now().Add(summaryFailureCooldown)
+}
+
+// compaction 一次请求的压缩状态，实现 claude.Compactor；降级时按模型分别记录是否使用了摘要
+type compaction struct {
+	h     *Handler
+	ctx   context.Context
+	api   string
+	model string         // 正在转换的模型
+	used  map[string]int // 模型 → 被摘要替换的历史消息条数
+}
+
+// newCompaction 为一次请求创建压缩状态，未开启压缩时返回 nil
+func (h *Handler) newCompaction(ctx context.Context, api string) *compaction {
+	if h.compactor == nil {
+		return nil
+	}
+	return &compaction{h: h, ctx: ctx, api: api, used: make(map[string]int)}
+}
+
+// apply 为模型的消息转换开启压缩
+func (p *compaction) apply(opts *claude.ConvertOptions, model string) {
+	if p == nil {
+		return
+	}
+	p.model = model
+	delete(p.used, model)
+	opts.Compactor = p
+	opts.SummaryTokens = p.h.compactor.cfg.MaxTokens
+}
+
+// compacted 返回模型的请求中被摘要替换的历史消息条数
+func (p *compaction) compacted(model string) int {
+	if p == nil {
+		return 0
+	}
+	return p.used[model]
+}
+
+func (p *compaction) Cached(prefix []types.PuterMessage) (string, bool) {
+	summary, ok := p.h.compactor.summaries.Get(p.h.compactor.key(prefix))
+	return string(summary), ok
+}
+
+func (p *compaction) Summarize(prefix []types.PuterMessage) (string, error) {
+	if p.h.compactor.coolingDown() {
+		return "", errSummaryCooldown
+	}
+	cfg := p.h.compactor.cfg
+	model := p.h.aliases.Resolve(cfg.Model)
+	history := p.fit(model, prefix)
+	if len(history) == 0 {
+		log.Warn().Str("api", p.api).Str("model", cfg.Model).Int("messages", len(prefix)).Msg("历史超出摘要模型的上下文窗口，改为截断")
+		return "", errors.New("history exceeds the summary model's context window")
+	}
+	req := puter.ChatRequest{
+		Model: model,
+		Messages: []types.PuterMessage{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: transcript(history)},
+		},
+		Params: types.SamplingParams{MaxTokens: cfg.MaxTokens},
+	}
+	summary, err := p.summarize(req)
+	if err != nil {
+		if !puter.IsCancelled(err) {
+			p.h.compactor.failed()
+		}
+		log.Warn().Str("api", p.api).Str("model", cfg.Model).Int("messages", len(prefix)).Err(err).Msg("压缩上下文失败，改为截断")
+		return "", err
+	}
+	p.h.compactor.summaries.Set(p.h.compactor.key(prefix), []byte(summary))
+	log.Info().
+		Str("api", p.api).
+		Str("model", cfg.Model).
+		Int("messages", len(prefix)).
+		Int("summarized", len(history)).
+		Int("summary_len", len(summary)).
+		Msg("已压缩上下文")
+	return summary, nil
+}
+
+// fit 按摘要模型的上下文窗口裁剪待摘要的历史，放不下时从最旧的消息开始丢弃
+func (p *compaction) fit(model string, prefix []types.PuterMessage) []types.PuterMessage {
+	opts := p.h.contextOptions(model, p.h.compactor.cfg.MaxTokens, false, nil, nil)
+	window := opts.ContextWindow
+	if window < 0 {
+		return prefix
+	}
+	if window == 0 {
+		window = claude.MaxContextTokens
+	}
+	budget := window - opts.ReserveTokens - tokenizer.CountMessage(opts.Tokenizer, types.PuterMessage{Role: "system", Content: summaryPrompt})
+	total := tokenizer.CountMessage(opts.Tokenizer, types.PuterMessage{Role: "user"})
+	costs := make([]int, len(prefix))
+	for i := range prefix {
+		costs[i] = opts.Tokenizer.Count(transcript(prefix[i : i+1]))
+		total += costs[i]
+	}
+	start := 0
+	for start < len(prefix) && total > budget {
+		total -= costs[start]
+		start++
+	}
+	return prefix[start:]
+}
+
+// summarize 调用摘要模型并读取完整回复
+func (p *compaction) summarize(req puter.ChatRequest) (string, error) {
+	stream, err := p.h.openStream(p.ctx, p.api, req)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	summary, err := stream.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", errors.New("empty summary")
+	}
+	return summary, nil
+}
+
+func (p *compaction) Compacted(messages int) {
+	p.used[p.model] = messages
+}
+
+// transcript 将消息渲染为纯文本对话记录，图片以占位符表示
+func transcript(messages []types.PuterMessage) string {
+	var sb strings.Builder
+	for _, m := range messages {
+		sb.WriteString(m.Role)
+		sb.WriteString(": ")
+		sb.WriteString(m.Content)
+		if m.HasImages() {
+			sb.WriteString(" [image]")
+		}
+		sb.WriteString("\n\n")
+	}
+	return sb.String()
+}
+
+// setCompacted 在响应头中报告本次请求压缩了多少条历史消息，必须在写响应体之前调用
+// 压缩发生在每个请求自己的 prepare 中，命中缓存或共享其他请求的响应时同样报告本请求的转换结果
+func setCompacted(c *gin.Context, messages int) {
+	if messages > 0 {
+		c.Header(compactedHeader, strconv.Itoa(messages))
+	}
+}
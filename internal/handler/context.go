@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"puter2api/internal/claude"
+	"puter2api/internal/tokenizer"
+	"puter2api/internal/types"
+)
+
+// contextOptions 按模型的上下文窗口构建消息转换选项：为 max_tokens 预留输出空间（不超过模型的最大输出），
+// 原生工具定义计入输入。maxTokens 为 0 时不预留；comp 非 nil 时超出的历史先尝试压缩为摘要
+func (h *Handler) contextOptions(model string, maxTokens int, nativeTools bool, tools []types.OpenAITool, comp *compaction) claude.ConvertOptions {
+	enc := tokenizer.ForModel(model)
+	limits := h.catalog.Limits(model)
+	reserve := maxTokens
+	if limits.MaxOutputTokens > 0 {
+		reserve = min(reserve, limits.MaxOutputTokens)
+	}
+	opts := claude.ConvertOptions{
+		NativeTools:   nativeTools,
+		Tokenizer:     enc,
+		ContextWindow: limits.ContextWindow,
+		ReserveTokens: reserve,
+		ExtraTokens:   tokenizer.CountTools(enc, tools),
+	}
+	comp.apply(&opts, model)
+	return opts
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+
+	"puter2api/internal/tokenizer"
+	"puter2api/internal/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// HandleCountTokens 处理 /v1/messages/count_tokens 请求：按实际发送给上游的内容
+// （转换后的 system prompt、消息和原生工具定义）估算输入 token 数，不调用上游
+func (h *Handler) HandleCountTokens(c *gin.Context) {
+	var req types.ClaudeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Error().Str("api", "CountTokens").Err(err).Msg("JSON 解析失败")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"type":  "error",
+			"error": gin.H{"type": "invalid_request_error", "message": err.Error()},
+		})
+		return
+	}
+
+	model := req.Model
+	if model == "" {
+		model = defaultClaudeModel
+	}
+	upstreamModel := h.resolveAlias("CountTokens", model)
+
+	// 计数时不截断，客户端据此决定是否需要压缩上下文
+	chatReq, err := h.claudePrepare(req, claudeSamplingParams(req), false, nil)(upstreamModel)
+	if err != nil {
+		writeClaudeError(c, err)
+		return
+	}
+	enc := tokenizer.ForModel(chatReq.Model)
+	n := tokenizer.CountInput(enc, chatReq.Messages, chatReq.Tools)
+
+	log.Debug().Str("api", "CountTokens").Str("model", chatReq.Model).Str("tokenizer", enc.Name()).Int("input_tokens", n).Msg("计数完成")
+	c.JSON(http.StatusOK, gin.H{"input_tokens": n})
+}
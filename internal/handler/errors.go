@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"puter2api/internal/breaker"
+	"puter2api/internal/claude"
+	"puter2api/internal/puter"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestError 调用上游之前发现的请求错误
+type requestError struct {
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// newRequestError 创建请求错误，对外返回 invalid_request_error
+func newRequestError(format string, args ...any) error {
+	return &requestError{message: fmt.Sprintf(format, args...)}
+}
+
+// apiError 对外返回的错误，Anthropic 与 OpenAI 的状态码和类型各自独立
+type apiError struct {
+	ClaudeStatus int
+	ClaudeType   string // Anthropic error.type
+	OpenAIStatus int
+	OpenAIType   string // OpenAI error.type
+	OpenAICode   string // OpenAI error.code
+	Message      string
+}
+
+// toAPIError 将内部错误映射为对外错误
+func toAPIError(err error) apiError {
+	switch {
+	case errors.Is(err, errNoToken):
+		return apiError{401, "authentication_error", 401, "authentication_error", "invalid_api_key", err.Error()}
+	case errors.Is(err, errTokenStore):
+		return apiError{500, "api_error", 500, "api_error", "internal_error", "failed to get token"}
+	}
+
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return apiError{400, "invalid_request_error", 400, "invalid_request_error", "invalid_request", reqErr.message}
+	}
+	var overflowErr *claude.ContextOverflowError
+	if errors.As(err, &overflowErr) {
+		return apiError{400, "invalid_request_error", 400, "invalid_request_error", "context_length_exceeded", overflowErr.Error()}
+	}
+	var openErr *breaker.OpenError
+	if errors.As(err, &openErr) {
+		return apiError{529, "overloaded_error", 503, "server_error", "overloaded", openErr.Error()}
+	}
+
+	puterErr, ok := puter.AsError(err)
+	if !ok {
+		return apiError{500, "api_error", 500, "api_error", "internal_error", err.Error()}
+	}
+
+	msg := puterErr.Message
+	switch puterErr.Code {
+	case puter.CodeAuthFailed:
+		return apiError{401, "authentication_error", 401, "authentication_error", "invalid_api_key", msg}
+	case puter.CodeRateLimited:
+		return apiError{429, "rate_limit_error", 429, "rate_limit_error", "rate_limit_exceeded", msg}
+	case puter.CodeInsufficientFunds:
+		return apiError{429, "rate_limit_error", 429, "insufficient_quota", "insufficient_quota", msg}
+	case puter.CodeOverloaded:
+		return apiError{529, "overloaded_error", 503, "server_error", "overloaded", msg}
+	case puter.CodeModelNotFound:
+		return apiError{404, "not_found_error", 404, "invalid_request_error", "model_not_found", msg}
+	case puter.CodeInvalidRequest:
+		return apiError{400, "invalid_request_error", 400, "invalid_request_error", "invalid_request", msg}
+	default:
+		return apiError{502, "api_error", 502, "api_error", "upstream_error", msg}
+	}
+}
+
+// writeClaudeError 以 Anthropic 错误格式响应
+func writeClaudeError(c *gin.Context, err error) {
+	e := toAPIError(err)
+	c.JSON(e.ClaudeStatus, gin.H{
+		"type":  "error",
+		"error": gin.H{"type": e.ClaudeType, "message": e.Message},
+	})
+}
+
+// writeOpenAIError 以 OpenAI 错误格式响应
+func writeOpenAIError(c *gin.Context, err error) {
+	e := toAPIError(err)
+	c.JSON(e.OpenAIStatus, openAIErrorBody(e))
+}
+
+// openAIErrorBody OpenAI 错误响应体，流式响应中也作为数据块发送
+func openAIErrorBody(e apiError) gin.H {
+	return gin.H{
+		"error": gin.H{
+			"message": e.Message,
+			"type":    e.OpenAIType,
+			"code":    e.OpenAICode,
+		},
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"puter2api/internal/puter"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// upstreamModelHeader 响应头：实际处理请求的 Puter 模型（别名解析和降级之后）
+const upstreamModelHeader = "X-Upstream-Model"
+
+// FallbackChains 模型降级链：键为上游模型，值为依次尝试的备选模型
+type FallbackChains map[string][]string
+
+// FallbackChainsFromEnv 从环境变量读取降级链，格式非法的项忽略
+//
+//	MODEL_FALLBACKS  如 claude-opus-4-5=claude-sonnet-4-5,gpt-5;gemini-2.5-pro=gemini-2.5-flash
+func FallbackChainsFromEnv() FallbackChains {
+	return parseFallbackChains(os.Getenv("MODEL_FALLBACKS"))
+}
+
+// parseFallbackChains 解析 model=fallback1,fallback2;model2=... 格式的降级链
+func parseFallbackChains(raw string) FallbackChains {
+	chains := FallbackChains{}
+	for _, entry := range strings.Split(raw, ";") {
+		model, list, ok := strings.Cut(entry, "=")
+		model = strings.TrimSpace(model)
+		if !ok || model == "" {
+			continue
+		}
+		for _, fb := range strings.Split(list, ",") {
+			if fb = strings.TrimSpace(fb); fb != "" && fb != model {
+				chains[model] = append(chains[model], fb)
+			}
+		}
+	}
+	return chains
+}
+
+// shouldFallback 判断失败后是否值得换模型：模型不存在或已下线，以及与 Token 无关的可重试错误
+// （过载、网络故障等）。Token 失效、限流、余额不足和请求错误换模型也无济于事
+func shouldFallback(err error) bool {
+	if puter.IsCancelled(err) {
+		return false
+	}
+	puterErr, ok := puter.AsError(err)
+	if !ok {
+		// 网络错误可以降级；没有 Token、读取 Token 失败和请求错误不降级
+		var reqErr *requestError
+		return !errors.As(err, &reqErr) && !errors.Is(err, errNoToken) && !errors.Is(err, errTokenStore)
+	}
+	if puterErr.Code == puter.CodeModelNotFound {
+		return true
+	}
+	return puterErr.Retryable && !puterErr.TokenFault
+}
+
+// prepareFunc 为指定上游模型构建请求；不同模型的驱动能力不同，消息转换需要按模型重新进行
+type prepareFunc func(model string) (puter.ChatRequest, error)
+
+// openChat 用首选模型的请求 req 打开上游流，失败且可降级时依次尝试降级链中的模型，返回实际使用的请求
+// 备选模型的 prepare 错误（如不支持图片）跳过该模型
+func (h *Handler) openChat(ctx context.Context, api string, req puter.ChatRequest, prepare prepareFunc) (*puter.Stream, puter.ChatRequest, error) {
+	model := req.Model
+	stream, err := h.openStream(ctx, api, req)
+	if err == nil || !shouldFallback(err) {
+		return stream, req, err
+	}
+
+	firstErr := err
+	for _, fb := range h.fallbacks[model] {
+		fb = h.aliases.Resolve(fb)
+		log.Warn().Str("api", api).Str("model", model).Str("fallback", fb).Err(err).Msg("模型不可用，尝试降级")
+
+		fbReq, prepErr := prepare(fb)
+		if prepErr != nil {
+			log.Warn().Str("api", api).Str("fallback", fb).Err(prepErr).Msg("跳过降级模型")
+			continue
+		}
+		stream, err = h.openStream(ctx, api, fbReq)
+		if err == nil {
+			log.Info().Str("api", api).Str("model", model).Str("model_used", fb).Msg("已降级")
+			return stream, fbReq, nil
+		}
+		if !shouldFallback(err) {
+			return nil, fbReq, err
+		}
+	}
+	// 所有备选都失败时返回首选模型的错误，便于客户端判断原因
+	return nil, req, firstErr
+}
+
+// setUpstreamModel 在响应头中标明实际使用的模型，必须在写响应体之前调用
+func setUpstreamModel(c *gin.Context, model string) {
+	c.Header(upstreamModelHeader, model)
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"puter2api/internal/cache"
+	"puter2api/internal/puter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CoalescingFromEnv 是否合并同时进行的相同请求，默认开启；只合并确定性的请求（见 cache.Cacheable），
+// 采样请求各自调用上游
+//
+//	REQUEST_COALESCING  设为 false 关闭
+func CoalescingFromEnv() bool {
+	if v, err := strconv.ParseBool(os.Getenv("REQUEST_COALESCING")); err == nil {
+		return v
+	}
+	return true
+}
+
+// flight 一次进行中的上游调用，相同请求共享它的响应
+type flight struct {
+	key    string
+	ready  chan struct{}     // 上游打开或失败后关闭
+	req    puter.ChatRequest // 实际使用的请求（可能已降级），ready 关闭后可读
+	err    error
+	buf    *puter.Broadcast
+	cancel context.CancelFunc
+	refs   int // 仍在等待或读取的请求数，由 flightGroup.mu 保护
+}
+
+// flightGroup 合并同时进行的相同请求：只有第一个请求调用上游，其余请求读取同一份响应
+type flightGroup struct {
+	enabled bool
+	mu      sync.Mutex
+	calls   map[string]*flight
+}
+
+func newFlightGroup(enabled bool) *flightGroup {
+	return &flightGroup{enabled: enabled, calls: make(map[string]*flight)}
+}
+
+// release 一个请求不再读取；所有请求都离开时取消上游调用
+func (g *flightGroup) release(f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f.refs--
+	if f.refs == 0 {
+		g.forgetLocked(f)
+		f.cancel()
+	}
+}
+
+// forget 不再让新请求加入
+func (g *flightGroup) forget(f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.forgetLocked(f)
+}
+
+func (g *flightGroup) forgetLocked(f *flight) {
+	if g.calls[f.key] == f {
+		delete(g.calls, f.key)
+	}
+}
+
+// flightReader 共享响应的读者，关闭时释放对 flight 的引用
+type flightReader struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *flightReader) Close() error {
+	r.once.Do(r.release)
+	return r.ReadCloser.Close()
+}
+
+// openShared 打开上游流；已有相同请求（key 相同）进行中时直接共享它的响应
+// 上游调用与发起它的客户端解绑，所有共享的请求都断开后才取消；cacheable 为 true 时完整响应写入缓存
+// 返回的流从共享响应中回放，第三个返回值表示是否共享了其他请求发起的调用
+// 只有确定性的请求才合并；既不合并也不缓存时直接返回上游的实时流，不经过共享缓冲
+func (h *Handler) openShared(ctx context.Context, api, key string, req puter.ChatRequest, prepare prepareFunc, cacheable bool) (*puter.Stream, puter.ChatRequest, bool, error) {
+	g := h.flights
+	coalesce := g.enabled && cache.Cacheable(req)
+	if !coalesce && !cacheable {
+		stream, chatReq, err := h.openChat(ctx, api, req, prepare)
+		return stream, chatReq, false, err
+	}
+	g.mu.Lock()
+	f, shared := g.calls[key]
+	if !shared {
+		upstreamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{key: key, ready: make(chan struct{}), buf: puter.NewBroadcast(), cancel: cancel}
+		if coalesce {
+			g.calls[key] = f
+		}
+		go h.runFlight(upstreamCtx, api, f, req, prepare, cacheable)
+	}
+	f.refs++
+	g.mu.Unlock()
+
+	if shared {
+		log.Info().Str("api", api).Str("model", req.Model).Msg("合并相同请求")
+	}
+
+	select {
+	case <-f.ready:
+	case <-ctx.Done():
+		g.release(f)
+		return nil, req, shared, ctx.Err()
+	}
+	if f.err != nil {
+		g.release(f)
+		return nil, f.req, shared, f.err
+	}
+	chatReq := f.req
+	if shared && chatReq.Model != req.Model {
+		// 降级模型的请求由发起调用的请求转换；按本请求重新转换一次，压缩状态由每个请求各自记录
+		if own, err := prepare(chatReq.Model); err == nil {
+			chatReq = own
+		}
+	}
+	body := &flightReader{ReadCloser: f.buf.NewReader(ctx), release: func() { g.release(f) }}
+	return puter.NewReplayStream(body), chatReq, shared, nil
+}
+
+// runFlight 调用上游（含重试和降级）并把响应读完写入 f.buf
+func (h *Handler) runFlight(ctx context.Context, api string, f *flight, req puter.ChatRequest, prepare prepareFunc, cacheable bool) {
+	defer f.cancel()
+
+	stream, chatReq, err := h.openChat(ctx, api, req, prepare)
+	f.req, f.err = chatReq, err
+	if err != nil {
+		h.flights.forget(f)
+		close(f.ready)
+		return
+	}
+	defer stream.Close()
+	stream.Tee(f.buf)
+	close(f.ready)
+
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	h.flights.forget(f)
+	if err != io.EOF {
+		f.buf.CloseWithError(err)
+		return
+	}
+	f.buf.CloseWithError(nil)
+
+	// 降级到其他模型的响应不写入首选模型的缓存
+	if cacheable && chatReq.Model == req.Model {
+		data, _ := f.buf.Bytes()
+		h.cache.Set(f.key, data)
+	}
+}
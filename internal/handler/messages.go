@@ -6,28 +6,50 @@ import (
 	"io"
 	"time"
 
+	"puter2api/internal/breaker"
+	"puter2api/internal/cache"
+	"puter2api/internal/catalog"
 	"puter2api/internal/claude"
 	"puter2api/internal/puter"
 	"puter2api/internal/storage"
+	"puter2api/internal/tokenizer"
 	"puter2api/internal/types"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
 
+// defaultClaudeModel 请求未指定模型时使用的模型
+const defaultClaudeModel = "claude-opus-4-5-20251001"
+
 // Handler HTTP 处理器
 type Handler struct {
 	puterClient *puter.Client
 	store       *storage.Storage
-	modelList   []string
+	catalog     *catalog.Catalog
+	aliases     *catalog.Aliases
+	retry       RetryPolicy
+	fallbacks   FallbackChains
+	compactor   *compactor
+	cache       *cache.Cache
+	flights     *flightGroup
+	breakers    *breaker.Breakers
 }
 
 // NewHandler 创建处理器
-func NewHandler(store *storage.Storage, modelList []string) *Handler {
+// responses 为响应缓存，nil 表示关闭；breakers 为驱动熔断器，nil 表示不熔断
+func NewHandler(store *storage.Storage, client *puter.Client, models *catalog.Catalog, aliases *catalog.Aliases, responses *cache.Cache, breakers *breaker.Breakers) *Handler {
 	return &Handler{
-		puterClient: puter.NewClient(),
+		puterClient: client,
 		store:       store,
-		modelList:   modelList,
+		catalog:     models,
+		aliases:     aliases,
+		retry:       RetryPolicyFromEnv(),
+		fallbacks:   FallbackChainsFromEnv(),
+		compactor:   newCompactor(CompactionFromEnv()),
+		cache:       responses,
+		flights:     newFlightGroup(CoalescingFromEnv()),
+		breakers:    breakers,
 	}
 }
 
@@ -61,98 +83,227 @@ func (h *Handler) HandleMessages(c *gin.Context) {
 		Int("last_msg_len", lastMsgLen).
 		Msg("收到请求")
 
-	// 从数据库获取可用的 Token
-	tokenRecord, err := h.store.GetActiveToken()
+	model := req.Model
+	if model == "" {
+		model = defaultClaudeModel
+	}
+	// 别名解析为实际的 Puter 模型，响应中仍返回客户端请求的模型 ID
+	upstreamModel := h.resolveAlias("Claude", model)
+
+	params := claudeSamplingParams(req)
+	comp := h.newCompaction(c.Request.Context(), "Claude")
+	prepare := h.claudePrepare(req, params, true, comp)
+	chatReq, err := prepare(upstreamModel)
 	if err != nil {
-		log.Error().Str("api", "Claude").Err(err).Msg("获取 Token 失败")
-		c.JSON(500, gin.H{
-			"type":  "error",
-			"error": gin.H{"type": "api_error", "message": "failed to get token"},
-		})
+		log.Error().Str("api", "Claude").Str("model", upstreamModel).Err(err).Msg("构建请求失败")
+		writeClaudeError(c, err)
 		return
 	}
-	if tokenRecord == nil {
-		c.JSON(401, gin.H{
-			"type":  "error",
-			"error": gin.H{"type": "authentication_error", "message": "no active token available, please add a token first"},
-		})
+
+	// 调用 Puter API，命中响应缓存时直接回放
+	stream, chatReq, ticket, err := h.openCached(c, "Claude", chatReq, prepare)
+	if err != nil {
+		if puter.IsCancelled(err) {
+			abortCancelled(c, "Claude")
+			return
+		}
+		log.Error().Str("api", "Claude").Str("model", upstreamModel).Err(err).Msg("调用 Puter API 失败")
+		writeClaudeError(c, err)
 		return
 	}
+	defer stream.Close()
+	setUpstreamModel(c, chatReq.Model)
+	setCompacted(c, comp.compacted(chatReq.Model))
 
-	token := tokenRecord.Token
-	log.Debug().Str("api", "Claude").Str("token", tokenRecord.Name).Int64("id", tokenRecord.ID).Msg("使用 Token")
-
-	// 更新 Token 使用时间
-	h.store.UpdateTokenUsed(tokenRecord.ID)
-
-	// 构建 system prompt 和转换消息
-	systemPrompt := claude.BuildSystemPrompt(req.System, req.Tools)
-	messages := claude.ConvertMessages(req.Messages, systemPrompt)
+	tracker := newUsageTracker(chatReq)
+	tracker.cacheHit = ticket.hit
+	limits := newOutputLimits(chatReq.Model, params)
 
-	// 调用 Puter API
-	model := req.Model
-	if model == "" {
-		model = "claude-opus-4-5-20251001"
+	// 流式请求边收边发；非流式请求读完后返回完整 Message
+	respond := h.streamSSEResponse
+	if !req.Stream {
+		respond = h.sendMessageResponse
 	}
-	responseText, err := h.puterClient.CallWithModel(messages, token, model)
+	responseLen, usage, err := respond(c, model, stream, tracker, limits)
 	if err != nil {
-		log.Error().Str("api", "Claude").Err(err).Msg("调用 Puter API 失败")
-		c.JSON(500, gin.H{
-			"type":  "error",
-			"error": gin.H{"type": "api_error", "message": err.Error()},
-		})
+		if puter.IsCancelled(err) {
+			if req.Stream {
+				logCancelled("Claude", responseLen)
+			} else {
+				abortCancelled(c, "Claude")
+			}
+		}
 		return
 	}
 
-	// 解析工具调用
-	toolCalls, remainingText := claude.ParseToolCalls(responseText)
-
-	// 发送 SSE 响应
-	h.sendSSEResponse(c, model, remainingText, toolCalls, len(responseText))
-
 	// 记录完成日志
 	elapsed := time.Since(startTime).Seconds()
 	log.Info().
 		Str("api", "Claude").
+		Str("model_used", chatReq.Model).
+		Bool("cache_hit", ticket.hit).
+		Bool("shared", ticket.shared).
+		Int("compacted", comp.compacted(chatReq.Model)).
 		Str("耗时", fmt.Sprintf("%.2fs", elapsed)).
-		Int("响应长度", len(responseText)).
+		Int("响应长度", responseLen).
+		Int("input_tokens", usage.InputTokens).
+		Int("cache_read_input_tokens", usage.CacheReadInputTokens).
+		Int("output_tokens", usage.OutputTokens).
 		Msg("请求完成")
 }
 
-func (h *Handler) sendSSEResponse(c *gin.Context, model string, text string, toolCalls []types.ParsedToolCall, totalLen int) {
-	msgID := fmt.Sprintf("msg_%d", time.Now().UnixNano())
+// claudePrepare 返回 Claude 请求的 prepareFunc：构建 system prompt 并转换消息，驱动支持原生工具调用时
+// 不再在 prompt 中模拟；降级到其他模型时按该模型的驱动能力和上下文窗口重新转换。truncate 为 false 时不截断，
+// comp 非 nil 时超出的历史先尝试压缩为摘要
+func (h *Handler) claudePrepare(req types.ClaudeRequest, params types.SamplingParams, truncate bool, comp *compaction) prepareFunc {
+	hasTools := len(req.Tools) > 0
+	return func(model string) (puter.ChatRequest, error) {
+		nativeTools := hasTools && puter.ResolveDriver(model).Caps.Tools
+		promptTools := req.Tools
+		var tools []types.OpenAITool
+		if nativeTools {
+			promptTools = nil
+			tools = claude.NativeTools(req.Tools)
+		}
+		opts := claude.ConvertOptions{NativeTools: nativeTools, Tokenizer: tokenizer.ForModel(model), ContextWindow: -1}
+		if truncate {
+			opts = h.contextOptions(model, params.MaxTokens, nativeTools, tools, comp)
+		}
+		systemPrompt := claude.BuildSystemPrompt(req.System, promptTools)
+		messages, err := claude.ConvertMessagesWith(req.Messages, systemPrompt, opts)
+		if err != nil {
+			return puter.ChatRequest{}, err
+		}
+		if err := checkImageSupport(model, messages); err != nil {
+			return puter.ChatRequest{}, err
+		}
+		return puter.ChatRequest{Model: model, Messages: messages, Params: params, Tools: tools}, nil
+	}
+}
+
+// streamSSEResponse 将上游文本块实时转发为 SSE 事件，返回响应长度和最终用量
+func (h *Handler) streamSSEResponse(c *gin.Context, model string, stream *puter.Stream, tracker *usageTracker, limits *outputLimits) (int, types.Usage, error) {
 	sse := claude.NewSSEWriter(c)
+	sse.SendMessageStartUsage(newMessageID(), model, tracker.startUsage())
 
-	// 1. message_start
-	sse.SendMessageStart(msgID, model)
+	stopReason, totalLen, err := relayClaudeBlocks(stream, limits, tracker, claude.NewBlockEmitter(sse))
+	if err != nil {
+		if puter.IsCancelled(err) {
+			return totalLen, types.Usage{}, err
+		}
+		log.Error().Str("api", "Claude").Err(err).Msg("读取 Puter 响应失败")
+		e := toAPIError(err)
+		sse.SendError(e.ClaudeType, e.Message)
+		return totalLen, types.Usage{}, err
+	}
 
-	blockIndex := 0
+	usage, inputFromUpstream := tracker.final(stream)
+	// message_start 中的输入用量是估算值，上游报告了真实值时在 message_delta 中更正
+	deltaUsage := types.Usage{OutputTokens: usage.OutputTokens, CacheReadInputTokens: usage.CacheReadInputTokens}
+	if inputFromUpstream {
+		deltaUsage.InputTokens = usage.InputTokens
+	}
+	// 达到 max_tokens 或命中 stop sequence 时优先报告
+	if reason, seq := limits.stopReason(usage); reason == claude.StopReasonStopSequence {
+		sse.SendStopSequenceDelta(seq, deltaUsage)
+	} else {
+		if reason != "" {
+			stopReason = reason
+		}
+		sse.SendMessageDelta(stopReason, deltaUsage)
+	}
+	sse.SendMessageStop()
+	return totalLen, usage, nil
+}
 
-	// 2. 发送文本块 (即使为空也要发送，否则 Claude Code 会报错)
-	if text != "" || len(toolCalls) == 0 {
-		sse.SendTextBlockStart(blockIndex)
-		if text != "" {
-			sse.SendTextDelta(blockIndex, text)
+// sendMessageResponse 读完上游响应后返回完整的 Message JSON（stream=false）
+func (h *Handler) sendMessageResponse(c *gin.Context, model string, stream *puter.Stream, tracker *usageTracker, limits *outputLimits) (int, types.Usage, error) {
+	builder := claude.NewMessageBuilder()
+	stopReason, totalLen, err := relayClaudeBlocks(stream, limits, tracker, claude.NewBlockEmitter(builder))
+	if err != nil {
+		if puter.IsCancelled(err) {
+			return totalLen, types.Usage{}, err
 		}
-		sse.SendBlockStop(blockIndex)
-		blockIndex++
+		log.Error().Str("api", "Claude").Err(err).Msg("读取 Puter 响应失败")
+		writeClaudeError(c, err)
+		return totalLen, types.Usage{}, err
+	}
+
+	usage, _ := tracker.final(stream)
+	reason, seq := limits.stopReason(usage)
+	if reason != "" {
+		stopReason = reason
 	}
+	c.JSON(200, builder.Message(newMessageID(), model, stopReason, seq, usage))
+	return totalLen, usage, nil
+}
 
-	// 3. 发送工具调用块
-	for _, call := range toolCalls {
-		sse.SendToolUseBlockStart(blockIndex, call.ID, call.Name)
-		sse.SendInputJSONDelta(blockIndex, string(call.Input))
-		sse.SendBlockStop(blockIndex)
-		blockIndex++
+// relayClaudeBlocks 将上游响应转换为 content block 事件，工具调用由增量解析器识别；返回 stop_reason
+// 流式与非流式响应共用，只是事件的接收方不同
+func relayClaudeBlocks(stream *puter.Stream, limits *outputLimits, tracker *usageTracker, emitter *claude.BlockEmitter) (string, int, error) {
+	parser := claude.NewToolCallParser()
+	nativeCalls := 0
+	totalLen, err := relayStream(stream, limits, tracker, relaySink{
+		text: func(text string) {
+			emitter.Emit(parser.Feed(text))
+		},
+		reasoning: func(thinking, signature string) {
+			emitter.Emit(parser.Finish())
+			emitter.EmitThinking(thinking, signature)
+		},
+		tool: func(chunk types.PuterStreamChunk) {
+			// 原生工具调用前先冲刷暂存的文本，保持顺序
+			emitter.Emit(parser.Finish())
+			emitter.Emit(claude.NativeToolEvents(chunk, nativeCalls))
+			nativeCalls++
+		},
+	})
+	if err != nil {
+		return "", totalLen, err
 	}
+	emitter.Emit(parser.Finish())
+	return emitter.Finish(), totalLen, nil
+}
 
-	// 4. 确定 stop_reason
-	stopReason := "end_turn"
-	if len(toolCalls) > 0 {
-		stopReason = "tool_use"
+// newMessageID 生成 Message ID
+func newMessageID() string {
+	return fmt.Sprintf("msg_%d", time.Now().UnixNano())
+}
+
+// resolveAlias 解析模型别名，命中时记录日志
+func (h *Handler) resolveAlias(api, model string) string {
+	target := h.aliases.Resolve(model)
+	if target != model {
+		log.Info().Str("api", api).Str("model", model).Str("target", target).Msg("模型别名")
 	}
+	return target
+}
 
-	// 5. message_delta & message_stop
-	sse.SendMessageDelta(stopReason, totalLen)
-	sse.SendMessageStop()
+// checkImageSupport 消息含图片而模型不支持图片输入时返回请求错误
+func checkImageSupport(model string, messages []types.PuterMessage) error {
+	for _, m := range messages {
+		if m.HasImages() {
+			if !puter.ResolveDriver(model).Caps.Vision {
+				return newRequestError("model %s does not support image input", model)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// abortCancelled 客户端在响应开始前断开：记录 client_cancelled 并以 499 结束
+// 取消不是 Token 的问题，不影响 Token 状态
+func abortCancelled(c *gin.Context, api string) {
+	logCancelled(api, 0)
+	c.AbortWithStatus(499)
+}
+
+// logCancelled 记录客户端取消请求
+func logCancelled(api string, received int) {
+	log.Warn().
+		Str("api", api).
+		Str("reason", "client_cancelled").
+		Int("已接收", received).
+		Msg("客户端取消请求")
 }
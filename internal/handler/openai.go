@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"puter2api/internal/claude"
+	"puter2api/internal/puter"
+	"puter2api/internal/storage"
 	"puter2api/internal/types"
 
 	"github.com/gin-gonic/gin"
@@ -50,80 +52,135 @@ func (h *Handler) HandleOpenAIChat(c *gin.Context) {
 		Int("last_msg_len", lastMsgLen).
 		Msg("收到请求")
 
-	// 从数据库获取可用的 Token
-	tokenRecord, err := h.store.GetActiveToken()
+	params, err := openAISamplingParams(req)
 	if err != nil {
-		log.Error().Str("api", "OpenAI").Err(err).Msg("获取 Token 失败")
-		c.JSON(500, gin.H{
-			"error": gin.H{
-				"message": "failed to get token",
-				"type":    "api_error",
-				"code":    "internal_error",
-			},
-		})
-		return
-	}
-	if tokenRecord == nil {
-		c.JSON(401, gin.H{
-			"error": gin.H{
-				"message": "no active token available, please add a token first",
-				"type":    "authentication_error",
-				"code":    "invalid_api_key",
-			},
-		})
+		writeOpenAIError(c, err)
 		return
 	}
 
-	token := tokenRecord.Token
-	log.Debug().Str("api", "OpenAI").Str("token", tokenRecord.Name).Int64("id", tokenRecord.ID).Msg("使用 Token")
+	// 别名解析为实际的 Puter 模型，响应中仍返回客户端请求的模型 ID
+	upstreamModel := h.resolveAlias("OpenAI", req.Model)
 
-	// 更新 Token 使用时间
-	h.store.UpdateTokenUsed(tokenRecord.ID)
+	// 转换 OpenAI 消息为 Puter 消息；驱动支持原生工具调用时不再在 prompt 中模拟
+	// 降级到其他模型时按该模型的驱动能力重新转换
+	comp := h.newCompaction(c.Request.Context(), "OpenAI")
+	prepare := func(model string) (puter.ChatRequest, error) {
+		nativeTools := hasTools && puter.ResolveDriver(model).Caps.Tools
+		systemPrompt, messages := h.convertOpenAIMessages(req, nativeTools)
+		var tools []types.OpenAITool
+		if nativeTools {
+			tools = req.Tools
+		}
+		puterMessages, err := claude.ConvertMessagesWith(messages, systemPrompt, h.contextOptions(model, params.MaxTokens, nativeTools, tools, comp))
+		if err != nil {
+			return puter.ChatRequest{}, err
+		}
+		if err := checkImageSupport(model, puterMessages); err != nil {
+			return puter.ChatRequest{}, err
+		}
+		return puter.ChatRequest{Model: model, Messages: puterMessages, Params: params, Tools: tools}, nil
+	}
 
-	// 转换 OpenAI 消息为 Puter 消息
-	systemPrompt, messages := h.convertOpenAIMessages(req)
-	puterMessages := claude.ConvertMessages(messages, systemPrompt)
+	chatReq, err := prepare(upstreamModel)
+	if err != nil {
+		log.Error().Str("api", "OpenAI").Str("model", upstreamModel).Err(err).Msg("构建请求失败")
+		writeOpenAIError(c, err)
+		return
+	}
 
-	// 调用 Puter API
-	responseText, err := h.puterClient.CallWithModel(puterMessages, token, req.Model)
+	// 调用 Puter API，命中响应缓存时直接回放
+	stream, chatReq, ticket, err := h.openCached(c, "OpenAI", chatReq, prepare)
 	if err != nil {
-		log.Error().Str("api", "OpenAI").Err(err).Msg("调用 Puter API 失败")
-		c.JSON(500, gin.H{
-			"error": gin.H{
-				"message": err.Error(),
-				"type":    "api_error",
-				"code":    "internal_error",
-			},
-		})
+		if puter.IsCancelled(err) {
+			abortCancelled(c, "OpenAI")
+			return
+		}
+		log.Error().Str("api", "OpenAI").Str("model", upstreamModel).Err(err).Msg("调用 Puter API 失败")
+		writeOpenAIError(c, err)
 		return
 	}
+	defer stream.Close()
+	setUpstreamModel(c, chatReq.Model)
+	setCompacted(c, comp.compacted(chatReq.Model))
 
-	// 解析工具调用
-	toolCalls, remainingText := claude.ParseToolCalls(responseText)
+	tracker := newUsageTracker(chatReq)
+	tracker.cacheHit = ticket.hit
+	limits := newOutputLimits(chatReq.Model, params)
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
 
-	// 发送响应
+	var responseLen int
+	var usage types.Usage
 	if req.Stream {
-		h.sendOpenAIStreamResponse(c, req.Model, remainingText, toolCalls)
+		// 流式请求边收边发，工具调用由增量解析器识别
+		responseLen, usage, err = h.streamOpenAIResponse(c, req.Model, stream, tracker, limits, includeUsage)
+		if err != nil {
+			if puter.IsCancelled(err) {
+				logCancelled("OpenAI", responseLen)
+			}
+			return
+		}
 	} else {
-		h.sendOpenAINonStreamResponse(c, req.Model, remainingText, toolCalls)
+		var sb strings.Builder
+		var nativeCalls []types.ParsedToolCall
+		var reasoning strings.Builder
+		responseLen, err = relayStream(stream, limits, tracker, relaySink{
+			text: func(text string) {
+				sb.WriteString(text)
+			},
+			reasoning: func(thinking, _ string) {
+				reasoning.WriteString(thinking)
+			},
+			tool: func(chunk types.PuterStreamChunk) {
+				id := chunk.ID
+				if id == "" {
+					id = fmt.Sprintf("toolu_%d_%d", time.Now().UnixNano(), len(nativeCalls))
+				}
+				nativeCalls = append(nativeCalls, types.ParsedToolCall{Name: chunk.Name, ID: id, Input: claude.NativeToolInput(chunk.Input)})
+			},
+		})
+		if err != nil {
+			if puter.IsCancelled(err) {
+				abortCancelled(c, "OpenAI")
+				return
+			}
+			log.Error().Str("api", "OpenAI").Err(err).Msg("读取 Puter 响应失败")
+			writeOpenAIError(c, err)
+			return
+		}
+
+		usage, _ = tracker.final(stream)
+
+		// 解析工具调用
+		toolCalls, remainingText := claude.ParseToolCalls(sb.String())
+		toolCalls = append(toolCalls, nativeCalls...)
+		reason, _ := limits.stopReason(usage)
+		h.sendOpenAINonStreamResponse(c, req.Model, remainingText, reasoning.String(), toolCalls, openAIFinishReason(reason, len(toolCalls)), usage)
 	}
 
 	// 记录完成日志
 	elapsed := time.Since(startTime).Seconds()
 	log.Info().
 		Str("api", "OpenAI").
+		Str("model_used", chatReq.Model).
+		Bool("cache_hit", ticket.hit).
+		Bool("shared", ticket.shared).
+		Int("compacted", comp.compacted(chatReq.Model)).
 		Str("耗时", fmt.Sprintf("%.2fs", elapsed)).
-		Int("响应长度", len(responseText)).
+		Int("响应长度", responseLen).
+		Int("input_tokens", usage.InputTokens).
+		Int("cache_read_input_tokens", usage.CacheReadInputTokens).
+		Int("output_tokens", usage.OutputTokens).
 		Msg("请求完成")
 }
 
 // convertOpenAIMessages 转换 OpenAI 消息格式为内部格式
-func (h *Handler) convertOpenAIMessages(req types.OpenAIRequest) (string, []types.ClaudeMessage) {
+// nativeTools 为 true 时工具定义不写入 system prompt，工具调用和结果转换为 tool_use / tool_result 块
+func (h *Handler) convertOpenAIMessages(req types.OpenAIRequest, nativeTools bool) (string, []types.ClaudeMessage) {
 	var systemPrompt string
 	var messages []types.ClaudeMessage
 
 	// 处理工具定义，添加到 system prompt
-	if len(req.Tools) > 0 {
+	if len(req.Tools) > 0 && !nativeTools {
 		toolPrompt := "\n\n# Tools\n\nYou have access to the following tools. When you need to use a tool, output it in this EXACT format:\n\n<tool_call>\n{\"name\": \"tool_name\", \"input\": {\"param\": \"value\"}}\n</tool_call>\n\nAvailable tools:\n\n"
 		for _, tool := range req.Tools {
 			toolPrompt += fmt.Sprintf("## %s\n", tool.Function.Name)
@@ -155,6 +212,11 @@ func (h *Handler) convertOpenAIMessages(req types.OpenAIRequest) (string, []type
 			Role: m.Role,
 		}
 
+		if nativeTools && (m.Role == "tool" || len(m.ToolCalls) > 0) {
+			messages = appendNativeToolMessage(messages, m)
+			continue
+		}
+
 		// 处理 tool 角色的消息
 		if m.Role == "tool" {
 			claudeMsg.Role = "user"
@@ -177,8 +239,8 @@ func (h *Handler) convertOpenAIMessages(req types.OpenAIRequest) (string, []type
 			}
 			claudeMsg.Content, _ = json.Marshal(content)
 		} else {
-			// 普通消息
-			claudeMsg.Content = m.Content
+			// 普通消息，内容片段数组中的图片转换为 Claude image 块
+			claudeMsg.Content = convertOpenAIContent(m.Content)
 		}
 
 		messages = append(messages, claudeMsg)
@@ -187,8 +249,87 @@ func (h *Handler) convertOpenAIMessages(req types.OpenAIRequest) (string, []type
 	return systemPrompt, messages
 }
 
-// sendOpenAIStreamResponse 发送 OpenAI 格式的流式响应
-func (h *Handler) sendOpenAIStreamResponse(c *gin.Context, model string, text string, toolCalls []types.ParsedToolCall) {
+// appendNativeToolMessage 将 assistant 的 tool_calls 转换为 tool_use 块，tool 消息转换为 tool_result 块；
+// 连续的 tool 消息合并到同一条 user 消息中
+func appendNativeToolMessage(messages []types.ClaudeMessage, m types.OpenAIMessage) []types.ClaudeMessage {
+	var content string
+	if err := json.Unmarshal(m.Content, &content); err != nil && len(m.Content) > 0 && string(m.Content) != "null" {
+		content = string(m.Content)
+	}
+
+	if m.Role == "tool" {
+		block := types.ContentBlock{Type: "tool_result", ToolUseID: m.ToolCallID}
+		block.Content, _ = json.Marshal(content)
+		if n := len(messages); n > 0 && messages[n-1].Role == "user" {
+			var blocks []types.ContentBlock
+			if err := json.Unmarshal(messages[n-1].Content, &blocks); err == nil && len(blocks) > 0 && blocks[0].Type == "tool_result" {
+				messages[n-1].Content, _ = json.Marshal(append(blocks, block))
+				return messages
+			}
+		}
+		raw, _ := json.Marshal([]types.ContentBlock{block})
+		return append(messages, types.ClaudeMessage{Role: "user", Content: raw})
+	}
+
+	var blocks []types.ContentBlock
+	if content != "" {
+		blocks = append(blocks, types.ContentBlock{Type: "text", Text: content})
+	}
+	for _, tc := range m.ToolCalls {
+		input := json.RawMessage(tc.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		blocks = append(blocks, types.ContentBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input})
+	}
+	raw, _ := json.Marshal(blocks)
+	return append(messages, types.ClaudeMessage{Role: m.Role, Content: raw})
+}
+
+// openAIContentPart OpenAI 消息内容片段
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// convertOpenAIContent 将 OpenAI 内容片段数组转换为 Claude 内容块，字符串内容原样返回
+func convertOpenAIContent(raw json.RawMessage) json.RawMessage {
+	var parts []openAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return raw
+	}
+	blocks := make([]types.ContentBlock, 0, len(parts))
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			blocks = append(blocks, types.ContentBlock{Type: "text", Text: p.Text})
+		case "image_url":
+			if p.ImageURL == nil || p.ImageURL.URL == "" {
+				continue
+			}
+			blocks = append(blocks, types.ContentBlock{Type: "image", Source: imageSourceFromURL(p.ImageURL.URL)})
+		}
+	}
+	out, _ := json.Marshal(blocks)
+	return out
+}
+
+// imageSourceFromURL data URL 转为 base64 来源，其他地址作为 url 来源
+func imageSourceFromURL(url string) *types.ImageSource {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		if meta, data, ok := strings.Cut(rest, ","); ok && strings.HasSuffix(meta, ";base64") {
+			return &types.ImageSource{Type: "base64", MediaType: strings.TrimSuffix(meta, ";base64"), Data: data}
+		}
+	}
+	return &types.ImageSource{Type: "url", URL: url}
+}
+
+// streamOpenAIResponse 将上游文本块实时转发为 chat.completion.chunk（含 tool_calls 增量），返回响应长度和最终用量
+// includeUsage 对应 stream_options.include_usage，为 true 时在 [DONE] 前追加一个仅含 usage 的块
+func (h *Handler) streamOpenAIResponse(c *gin.Context, model string, stream *puter.Stream, tracker *usageTracker, limits *outputLimits, includeUsage bool) (int, types.Usage, error) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
@@ -197,144 +338,118 @@ func (h *Handler) sendOpenAIStreamResponse(c *gin.Context, model string, text st
 	msgID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
 	created := time.Now().Unix()
 
-	// 发送角色信息
-	firstChunk := types.OpenAIResponse{
-		ID:      msgID,
-		Object:  "chat.completion.chunk",
-		Created: created,
-		Model:   model,
-		Choices: []types.OpenAIChoice{
-			{
-				Index: 0,
-				Delta: &types.OpenAIResponseMsg{
-					Role: "assistant",
-				},
-				FinishReason: nil,
-				Logprobs:     nil,
-			},
-		},
-	}
-	h.writeSSEChunk(c, firstChunk)
-
-	// 发送文本内容（一次性发送）
-	if text != "" {
-		chunk := types.OpenAIResponse{
+	newChunk := func(delta *types.OpenAIResponseMsg, finishReason *string) types.OpenAIResponse {
+		return types.OpenAIResponse{
 			ID:      msgID,
 			Object:  "chat.completion.chunk",
 			Created: created,
 			Model:   model,
 			Choices: []types.OpenAIChoice{
 				{
-					Index: 0,
-					Delta: &types.OpenAIResponseMsg{
-						Content: &text,
-					},
-					FinishReason: nil,
+					Index:        0,
+					Delta:        delta,
+					FinishReason: finishReason,
 					Logprobs:     nil,
 				},
 			},
 		}
-		h.writeSSEChunk(c, chunk)
-	}
-
-	// 发送工具调用
-	if len(toolCalls) > 0 {
-		for i, tc := range toolCalls {
-			idx := i
-			// 发送工具调用开始
-			toolCallChunk := types.OpenAIResponse{
-				ID:      msgID,
-				Object:  "chat.completion.chunk",
-				Created: created,
-				Model:   model,
-				Choices: []types.OpenAIChoice{
-					{
-						Index: 0,
-						Delta: &types.OpenAIResponseMsg{
-							ToolCalls: []types.OpenAIToolCall{
-								{
-									Index: &idx,
-									ID:    tc.ID,
-									Type:  "function",
-									Function: types.OpenAIToolCallFunction{
-										Name:      tc.Name,
-										Arguments: "",
-									},
-								},
+	}
+
+	// 发送角色信息
+	h.writeSSEChunk(c, newChunk(&types.OpenAIResponseMsg{Role: "assistant"}, nil))
+
+	parser := claude.NewToolCallParser()
+	toolIndex := 0
+	nativeCalls := 0
+	emit := func(events []claude.ToolStreamEvent) {
+		for _, ev := range events {
+			switch ev.Type {
+			case claude.EventText:
+				text := ev.Text
+				h.writeSSEChunk(c, newChunk(&types.OpenAIResponseMsg{Content: &text}, nil))
+			case claude.EventToolStart:
+				idx := toolIndex
+				h.writeSSEChunk(c, newChunk(&types.OpenAIResponseMsg{
+					ToolCalls: []types.OpenAIToolCall{
+						{
+							Index: &idx,
+							ID:    ev.ID,
+							Type:  "function",
+							Function: types.OpenAIToolCallFunction{
+								Name:      ev.Name,
+								Arguments: "",
 							},
 						},
-						FinishReason: nil,
-						Logprobs:     nil,
 					},
-				},
-			}
-			h.writeSSEChunk(c, toolCallChunk)
-
-			// 一次性发送参数
-			argsStr := string(tc.Input)
-			argChunk := types.OpenAIResponse{
-				ID:      msgID,
-				Object:  "chat.completion.chunk",
-				Created: created,
-				Model:   model,
-				Choices: []types.OpenAIChoice{
-					{
-						Index: 0,
-						Delta: &types.OpenAIResponseMsg{
-							ToolCalls: []types.OpenAIToolCall{
-								{
-									Index: &idx,
-									Function: types.OpenAIToolCallFunction{
-										Arguments: argsStr,
-									},
-								},
+				}, nil))
+			case claude.EventToolInput:
+				idx := toolIndex
+				h.writeSSEChunk(c, newChunk(&types.OpenAIResponseMsg{
+					ToolCalls: []types.OpenAIToolCall{
+						{
+							Index: &idx,
+							Function: types.OpenAIToolCallFunction{
+								Arguments: ev.PartialJSON,
 							},
 						},
-						FinishReason: nil,
-						Logprobs:     nil,
 					},
-				},
+				}, nil))
+			case claude.EventToolEnd:
+				toolIndex++
 			}
-			h.writeSSEChunk(c, argChunk)
 		}
 	}
 
-	// 发送结束标记
-	finishReason := "stop"
-	if len(toolCalls) > 0 {
-		finishReason = "tool_calls"
-	}
-	finalChunk := types.OpenAIResponse{
-		ID:      msgID,
-		Object:  "chat.completion.chunk",
-		Created: created,
-		Model:   model,
-		Choices: []types.OpenAIChoice{
-			{
-				Index:        0,
-				Delta:        &types.OpenAIResponseMsg{},
-				FinishReason: &finishReason,
-				Logprobs:     nil,
-			},
+	totalLen, err := relayStream(stream, limits, tracker, relaySink{
+		text: func(text string) {
+			emit(parser.Feed(text))
+		},
+		reasoning: func(thinking, _ string) {
+			if thinking != "" {
+				h.writeSSEChunk(c, newChunk(&types.OpenAIResponseMsg{ReasoningContent: thinking}, nil))
+			}
+		},
+		tool: func(chunk types.PuterStreamChunk) {
+			// 原生工具调用前先冲刷暂存的文本，保持顺序
+			emit(parser.Finish())
+			emit(claude.NativeToolEvents(chunk, nativeCalls))
+			nativeCalls++
 		},
+	})
+	if err != nil {
+		if puter.IsCancelled(err) {
+			return totalLen, types.Usage{}, err
+		}
+		log.Error().Str("api", "OpenAI").Err(err).Msg("读取 Puter 响应失败")
+		h.writeSSEChunk(c, openAIErrorBody(toAPIError(err)))
+		return totalLen, types.Usage{}, err
+	}
+	emit(parser.Finish())
+
+	// 发送结束标记
+	usage, _ := tracker.final(stream)
+	reason, _ := limits.stopReason(usage)
+	finishReason := openAIFinishReason(reason, parser.ToolCallCount()+nativeCalls)
+	h.writeSSEChunk(c, newChunk(&types.OpenAIResponseMsg{}, &finishReason))
+
+	if includeUsage {
+		usageChunk := newChunk(nil, nil)
+		usageChunk.Choices = []types.OpenAIChoice{}
+		usageChunk.Usage = toOpenAIUsage(usage)
+		h.writeSSEChunk(c, usageChunk)
 	}
-	h.writeSSEChunk(c, finalChunk)
 
 	// 发送 [DONE]
 	c.Writer.Write([]byte("data: [DONE]\n\n"))
 	c.Writer.Flush()
+	return totalLen, usage, nil
 }
 
 // sendOpenAINonStreamResponse 发送 OpenAI 格式的非流式响应
-func (h *Handler) sendOpenAINonStreamResponse(c *gin.Context, model string, text string, toolCalls []types.ParsedToolCall) {
+func (h *Handler) sendOpenAINonStreamResponse(c *gin.Context, model string, text string, reasoning string, toolCalls []types.ParsedToolCall, finishReason string, usage types.Usage) {
 	msgID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
 	created := time.Now().Unix()
 
-	finishReason := "stop"
-	if len(toolCalls) > 0 {
-		finishReason = "tool_calls"
-	}
-
 	var openaiToolCalls []types.OpenAIToolCall
 	for _, tc := range toolCalls {
 		openaiToolCalls = append(openaiToolCalls, types.OpenAIToolCall{
@@ -361,19 +476,16 @@ func (h *Handler) sendOpenAINonStreamResponse(c *gin.Context, model string, text
 			{
 				Index: 0,
 				Message: &types.OpenAIResponseMsg{
-					Role:      "assistant",
-					Content:   contentPtr,
-					ToolCalls: openaiToolCalls,
+					Role:             "assistant",
+					Content:          contentPtr,
+					ReasoningContent: reasoning,
+					ToolCalls:        openaiToolCalls,
 				},
 				FinishReason: &finishReason,
 				Logprobs:     nil,
 			},
 		},
-		Usage: &types.OpenAIUsage{
-			PromptTokens:     0,
-			CompletionTokens: len(text),
-			TotalTokens:      len(text),
-		},
+		Usage: toOpenAIUsage(usage),
 	}
 
 	c.JSON(200, resp)
@@ -388,47 +500,37 @@ func (h *Handler) writeSSEChunk(c *gin.Context, data any) {
 	c.Writer.Flush()
 }
 
-// getModelProvider 根据模型 ID 判断提供商
-func getModelProvider(id string) string {
-	if strings.HasPrefix(id, "openrouter:") {
-		return "openrouter"
-	}
-	if strings.HasPrefix(id, "togetherai:") {
-		return "togetherai"
-	}
-	if strings.HasPrefix(id, "claude-") {
-		return "anthropic"
-	}
-	if strings.HasPrefix(id, "gpt-") || strings.HasPrefix(id, "o1") || strings.HasPrefix(id, "o3") || strings.HasPrefix(id, "o4") {
-		return "openai"
-	}
-	if strings.HasPrefix(id, "gemini-") {
-		return "google"
-	}
-	if strings.HasPrefix(id, "grok-") {
-		return "xai"
-	}
-	if strings.HasPrefix(id, "deepseek-") {
-		return "deepseek"
-	}
-	if strings.HasPrefix(id, "mistral-") || strings.HasPrefix(id, "ministral-") || strings.HasPrefix(id, "open-mistral-") || strings.HasPrefix(id, "pixtral-") || strings.HasPrefix(id, "codestral-") || strings.HasPrefix(id, "devstral-") || strings.HasPrefix(id, "magistral-") {
-		return "mistral"
-	}
-	return "other"
-}
-
 // HandleModels 处理 /v1/models 请求
 func (h *Handler) HandleModels(c *gin.Context) {
-	modelList := h.modelList
+	modelList := h.catalog.Models()
+	aliases := h.aliases.List()
 
-	models := make([]map[string]any, 0, len(modelList))
-	for _, id := range modelList {
-		models = append(models, map[string]any{
+	// 别名与目录中的模型同名时只列出一次，并标注其目标
+	targets := make(map[string]string, len(aliases))
+	for _, a := range aliases {
+		targets[a.Alias] = a.Target
+	}
+	models := make([]map[string]any, 0, len(modelList)+len(aliases))
+	appendModel := func(id string) {
+		m := map[string]any{
 			"id":       id,
 			"object":   "model",
 			"created":  1700000000,
-			"owned_by": getModelProvider(id),
-		})
+			"owned_by": puter.ResolveDriver(h.aliases.Resolve(id)).Provider,
+		}
+		if target, ok := targets[id]; ok {
+			m["alias_of"] = target
+			delete(targets, id)
+		}
+		models = append(models, m)
+	}
+	for _, id := range modelList {
+		appendModel(id)
+	}
+	for _, a := range aliases {
+		if _, ok := targets[a.Alias]; ok {
+			appendModel(a.Alias)
+		}
 	}
 
 	c.JSON(200, gin.H{
@@ -463,20 +565,28 @@ func (h *Handler) HandleImageGeneration(c *gin.Context) {
 	}
 
 	log.Info().Str("api", "ImageGen").Str("model", req.Model).Str("prompt", req.Prompt).Msg("收到请求")
-
-	// 获取 Token
-	tokenRecord, err := h.store.GetActiveToken()
-	if err != nil || tokenRecord == nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": "no active token", "type": "api_error"}})
-		return
-	}
-	h.store.UpdateTokenUsed(tokenRecord.ID)
-
-	// 调用 Puter 图片生成
-	respBytes, err := h.puterClient.CallImageGeneration(req.Prompt, req.Model, tokenRecord.Token)
+	upstreamModel := h.resolveAlias("ImageGen", req.Model)
+
+	// 调用 Puter 图片生成（失败时自动换 Token 重试）
+	// 驱动熔断中时直接失败，不调用上游
+	breakerKey := h.breakers.Key(puter.ResolveImageDriver(upstreamModel).Driver, upstreamModel)
+	var respBytes []byte
+	_, err := h.withFailover(c.Request.Context(), "ImageGen", func(t *storage.Token) error {
+		if err := h.breakers.Allow(breakerKey); err != nil {
+			return err
+		}
+		var err error
+		respBytes, err = h.puterClient.CallImageGeneration(c.Request.Context(), req.Prompt, upstreamModel, credentialFor(t))
+		h.reportBreaker(breakerKey, err)
+		return err
+	})
 	if err != nil {
+		if puter.IsCancelled(err) {
+			abortCancelled(c, "ImageGen")
+			return
+		}
 		log.Error().Str("api", "ImageGen").Err(err).Msg("图片生成失败")
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error(), "type": "api_error"}})
+		writeOpenAIError(c, err)
 		return
 	}
 
@@ -546,20 +656,28 @@ func (h *Handler) HandleVideoGeneration(c *gin.Context) {
 	}
 
 	log.Info().Str("api", "VideoGen").Str("model", req.Model).Str("prompt", req.Prompt).Msg("收到请求")
-
-	// 获取 Token
-	tokenRecord, err := h.store.GetActiveToken()
-	if err != nil || tokenRecord == nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": "no active token", "type": "api_error"}})
-		return
-	}
-	h.store.UpdateTokenUsed(tokenRecord.ID)
-
-	// 调用 Puter 视频生成
-	respBytes, err := h.puterClient.CallVideoGeneration(req.Prompt, req.Model, tokenRecord.Token, req.Width, req.Height, req.FPS)
+	upstreamModel := h.resolveAlias("VideoGen", req.Model)
+
+	// 调用 Puter 视频生成（失败时自动换 Token 重试）
+	// 驱动熔断中时直接失败，不调用上游
+	breakerKey := h.breakers.Key(puter.ResolveVideoDriver(upstreamModel).Driver, upstreamModel)
+	var respBytes []byte
+	_, err := h.withFailover(c.Request.Context(), "VideoGen", func(t *storage.Token) error {
+		if err := h.breakers.Allow(breakerKey); err != nil {
+			return err
+		}
+		var err error
+		respBytes, err = h.puterClient.CallVideoGeneration(c.Request.Context(), req.Prompt, upstreamModel, credentialFor(t), req.Width, req.Height, req.FPS)
+		h.reportBreaker(breakerKey, err)
+		return err
+	})
 	if err != nil {
+		if puter.IsCancelled(err) {
+			abortCancelled(c, "VideoGen")
+			return
+		}
 		log.Error().Str("api", "VideoGen").Err(err).Msg("视频生成失败")
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error(), "type": "api_error"}})
+		writeOpenAIError(c, err)
 		return
 	}
 
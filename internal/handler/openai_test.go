@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"puter2api/internal/claude"
+	"puter2api/internal/types"
+)
+
+func TestConvertOpenAIMessages_TruncationKeepsToolPairs(t *testing.T) {
+	text := func(s string) json.RawMessage {
+		raw, _ := json.Marshal(s)
+		return raw
+	}
+	req := types.OpenAIRequest{
+		Messages: []types.OpenAIMessage{
+			{Role: "user", Content: text("start")},
+			{Role: "assistant", Content: text(strings.Repeat("thinking ", 2000)), ToolCalls: []types.OpenAIToolCall{
+				{ID: "call_1", Type: "function", Function: types.OpenAIToolCallFunction{Name: "search", Arguments: `{"q":"x"}`}},
+			}},
+			{Role: "tool", ToolCallID: "call_1", Content: text("result")},
+			{Role: "assistant", Content: text("done")},
+			{Role: "user", Content: text("next")},
+		},
+	}
+
+	_, messages := (&Handler{}).convertOpenAIMessages(req, false)
+	result, err := claude.ConvertMessagesWith(messages, "", claude.ConvertOptions{ContextWindow: 300})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var hasCall, hasResult bool
+	for _, m := range result {
+		hasCall = hasCall || strings.Contains(m.Content, "<tool_call>")
+		hasResult = hasResult || strings.Contains(m.Content, "<tool_result")
+	}
+	if hasResult && !hasCall {
+		t.Errorf("tool_result kept without its tool_call: %+v", result)
+	}
+	if last := result[len(result)-1]; last.Content != "next" {
+		t.Errorf("expected newest message to be kept, got %q", last.Content)
+	}
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+
+	"puter2api/internal/puter"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRoutes 获取当前生效的模型路由表
+func (h *Handler) ListRoutes(c *gin.Context) {
+	c.JSON(http.StatusOK, puter.Routes())
+}
+
+// ResolveRoute 预览模型 ID 的路由结果：GET /api/routes/resolve?model=xxx
+func (h *Handler) ResolveRoute(c *gin.Context) {
+	model := c.Query("model")
+	if model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+	info := puter.ResolveDriver(model)
+	c.JSON(http.StatusOK, gin.H{
+		"model":        model,
+		"route":        info.Route,
+		"interface":    info.Interface,
+		"driver":       info.Driver,
+		"method":       info.Method,
+		"provider":     info.Provider,
+		"puter_model":  info.Model,
+		"capabilities": info.Caps,
+	})
+}
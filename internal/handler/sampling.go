@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+
+	"puter2api/internal/claude"
+	"puter2api/internal/puter"
+	"puter2api/internal/tokenizer"
+	"puter2api/internal/types"
+)
+
+// claudeSamplingParams 提取 Claude 请求中的采样参数
+func claudeSamplingParams(req types.ClaudeRequest) types.SamplingParams {
+	return types.SamplingParams{
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+		Stop:        req.StopSequences,
+		Thinking:    req.Thinking,
+	}
+}
+
+// openAISamplingParams 提取 OpenAI 请求中的采样参数
+func openAISamplingParams(req types.OpenAIRequest) (types.SamplingParams, error) {
+	stop, err := parseStop(req.Stop)
+	if err != nil {
+		return types.SamplingParams{}, err
+	}
+	maxTokens := req.MaxCompletionTokens
+	if maxTokens == 0 {
+		maxTokens = req.MaxTokens
+	}
+	return types.SamplingParams{
+		MaxTokens:        maxTokens,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		Stop:             stop,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		ReasoningEffort:  req.ReasoningEffort,
+	}, nil
+}
+
+// parseStop 解析 OpenAI 的 stop 字段（字符串或字符串数组）
+func parseStop(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, newRequestError("stop must be a string or an array of strings")
+	}
+	return list, nil
+}
+
+// outputLimits 一次请求的输出限制：驱动不支持的参数在本地执行
+type outputLimits struct {
+	*claude.OutputLimiter
+	maxTokens        int  // 请求的 max_tokens
+	upstreamEnforced bool // max_tokens 已透传给上游
+}
+
+// newOutputLimits 根据模型对应驱动的能力决定哪些限制需要在本地执行
+func newOutputLimits(model string, p types.SamplingParams) *outputLimits {
+	caps := puter.ResolveDriver(model).Caps
+	var stops []string
+	if !caps.Stop {
+		stops = p.Stop
+	}
+	localMax := 0
+	if !caps.MaxTokens {
+		localMax = p.MaxTokens
+	}
+	return &outputLimits{
+		OutputLimiter:    claude.NewOutputLimiter(stops, localMax, tokenizer.ForModel(model)),
+		maxTokens:        p.MaxTokens,
+		upstreamEnforced: caps.MaxTokens,
+	}
+}
+
+// stopReason 返回限制导致的 stop_reason（max_tokens / stop_sequence）及命中的 stop sequence；
+// 正常结束时返回空。max_tokens 由上游执行时，按输出用量是否达到上限判断
+func (l *outputLimits) stopReason(usage types.Usage) (string, string) {
+	if reason, seq := l.StopReason(); reason != "" {
+		return reason, seq
+	}
+	if l.upstreamEnforced && l.maxTokens > 0 && usage.OutputTokens >= l.maxTokens {
+		return claude.StopReasonMaxTokens, ""
+	}
+	return "", ""
+}
+
+// openAIFinishReason 将停止原因转换为 OpenAI finish_reason
+func openAIFinishReason(stopReason string, toolCalls int) string {
+	switch {
+	case stopReason == claude.StopReasonMaxTokens:
+		return "length"
+	case stopReason == claude.StopReasonStopSequence:
+		return "stop"
+	case toolCalls > 0:
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
+
+// relaySink relayStream 的输出回调，未设置的回调忽略对应内容
+type relaySink struct {
+	text      func(text string)
+	reasoning func(thinking, signature string)
+	tool      func(chunk types.PuterStreamChunk)
+}
+
+// relayStream 读取上游内容：文本经本地限制后交给 sink.text，推理内容和原生工具调用块分别交给
+// sink.reasoning、sink.tool，返回已接收的上游文本长度。限制触发后立即停止读取，调用方关闭流即可中断上游请求
+func relayStream(stream *puter.Stream, limits *outputLimits, tracker *usageTracker, sink relaySink) (int, error) {
+	received := 0
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return received, err
+		}
+		switch {
+		case chunk.IsToolUse():
+			tracker.addOutput(chunk.Name + string(chunk.Input))
+			if sink.tool != nil {
+				sink.tool(chunk)
+			}
+			continue
+		case chunk.IsReasoning():
+			thinking := chunk.ReasoningText()
+			tracker.addOutput(thinking)
+			if sink.reasoning != nil {
+				sink.reasoning(thinking, chunk.Signature)
+			}
+			continue
+		}
+		received += len(chunk.Text)
+		text, done := limits.Feed(chunk.Text)
+		if text != "" {
+			tracker.addOutput(text)
+			sink.text(text)
+		}
+		if done {
+			return received, nil
+		}
+	}
+	if text := limits.Finish(); text != "" {
+		tracker.addOutput(text)
+		sink.text(text)
+	}
+	return received, nil
+}
@@ -1,28 +1,34 @@
 package handler
 
 import (
-	"bytes"
-	"encoding/json"
-	"io"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"puter2api/internal/parser"
+	"puter2api/internal/puter"
 	"puter2api/internal/storage"
 	"puter2api/internal/types"
 
 	"github.com/gin-gonic/gin"
 )
 
+// errInvalidBaseURL 上游地址格式不正确
+var errInvalidBaseURL = errors.New("base_url must be an http(s) URL")
+
 // TokenHandler Token 管理处理器
 type TokenHandler struct {
 	storage *storage.Storage
+	tester  *PuterTestClient
 }
 
 // NewTokenHandler 创建 Token 处理器
-func NewTokenHandler(s *storage.Storage) *TokenHandler {
-	return &TokenHandler{storage: s}
+func NewTokenHandler(s *storage.Storage, client *puter.Client) *TokenHandler {
+	return &TokenHandler{storage: s, tester: NewPuterTestClient(client)}
 }
 
 // ListTokens 获取所有 Token
@@ -35,13 +41,17 @@ func (h *TokenHandler) ListTokens(c *gin.Context) {
 
 	// 隐藏完整 token，只显示前后部分
 	type TokenResponse struct {
-		ID        int64  `json:"id"`
-		Name      string `json:"name"`
-		Token     string `json:"token"` // 脱敏后的 token
-		IsActive  bool   `json:"is_active"`
-		IsValid   bool   `json:"is_valid"`
-		LastUsed  string `json:"last_used,omitempty"`
-		CreatedAt string `json:"created_at"`
+		ID            int64  `json:"id"`
+		Name          string `json:"name"`
+		Token         string `json:"token"` // 脱敏后的 token
+		IsActive      bool   `json:"is_active"`
+		IsValid       bool   `json:"is_valid"`
+		LastUsed      string `json:"last_used,omitempty"`
+		CreatedAt     string `json:"created_at"`
+		CooldownUntil string `json:"cooldown_until,omitempty"` // 仅在冷却中时返回
+		BaseURL       string `json:"base_url,omitempty"`
+		Proxy         string `json:"proxy,omitempty"` // 隐藏代理密码
+		Profile       string `json:"profile,omitempty"`
 	}
 
 	var resp []TokenResponse
@@ -54,10 +64,16 @@ func (h *TokenHandler) ListTokens(c *gin.Context) {
 			IsActive:  t.IsActive,
 			IsValid:   t.IsValid,
 			CreatedAt: t.CreatedAt.Format("2006-01-02 15:04:05"),
+			BaseURL:   t.BaseURL,
+			Proxy:     redactProxy(t.Proxy),
+			Profile:   t.Profile,
 		}
 		if t.LastUsed != nil {
 			tr.LastUsed = t.LastUsed.Format("2006-01-02 15:04:05")
 		}
+		if t.CooldownUntil != nil && t.CooldownUntil.After(time.Now()) {
+			tr.CooldownUntil = t.CooldownUntil.Format("2006-01-02 15:04:05")
+		}
 		resp = append(resp, tr)
 	}
 
@@ -67,14 +83,29 @@ func (h *TokenHandler) ListTokens(c *gin.Context) {
 // AddToken 添加新 Token
 func (h *TokenHandler) AddToken(c *gin.Context) {
 	var req struct {
-		Name  string `json:"name"`
-		Input string `json:"input"` // curl 命令或直接的 token
+		Name    string `json:"name"`
+		Input   string `json:"input"`    // curl 命令或直接的 token
+		BaseURL string `json:"base_url"` // 可选，Token 专用的上游地址
+		Proxy   string `json:"proxy"`    // 可选，Token 专用的出站代理
+		Profile string `json:"profile"`  // 可选，Token 固定使用的请求头指纹
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
+	if err := validateBaseURL(req.BaseURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateProxy(req.Proxy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.validateProfile(req.Profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// 解析 token
 	token, err := parser.ParseToken(req.Input)
@@ -89,6 +120,13 @@ func (h *TokenHandler) AddToken(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save token: " + err.Error()})
 		return
 	}
+	if req.BaseURL != "" || req.Proxy != "" || req.Profile != "" {
+		settings := storage.TokenSettings{BaseURL: req.BaseURL, Proxy: req.Proxy, Profile: req.Profile}
+		if err := h.storage.UpdateTokenSettings(t.ID, settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save token settings: " + err.Error()})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "token added successfully",
@@ -115,8 +153,8 @@ func (h *TokenHandler) DeleteToken(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "token deleted"})
 }
 
-// UpdateTokenName 更新 Token 名称
-func (h *TokenHandler) UpdateTokenName(c *gin.Context) {
+// UpdateToken 更新 Token 名称和连接设置
+func (h *TokenHandler) UpdateToken(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
@@ -125,12 +163,33 @@ func (h *TokenHandler) UpdateTokenName(c *gin.Context) {
 	}
 
 	var req struct {
-		Name string `json:"name"`
+		Name    string  `json:"name"`
+		BaseURL *string `json:"base_url"` // 未提供时保持不变，空字符串表示使用默认地址
+		Proxy   *string `json:"proxy"`    // 未提供时保持不变，空字符串表示直连
+		Profile *string `json:"profile"`  // 未提供时保持不变，空字符串表示按配置分配
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
+	if req.BaseURL != nil {
+		if err := validateBaseURL(*req.BaseURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Proxy != nil {
+		if err := validateProxy(*req.Proxy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Profile != nil {
+		if err := h.validateProfile(*req.Profile); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// 获取现有 token
 	t, err := h.storage.GetToken(id)
@@ -149,6 +208,23 @@ func (h *TokenHandler) UpdateTokenName(c *gin.Context) {
 		return
 	}
 
+	if req.BaseURL != nil || req.Proxy != nil || req.Profile != nil {
+		settings := t.TokenSettings
+		if req.BaseURL != nil {
+			settings.BaseURL = *req.BaseURL
+		}
+		if req.Proxy != nil {
+			settings.Proxy = keepProxy(t, *req.Proxy)
+		}
+		if req.Profile != nil {
+			settings.Profile = *req.Profile
+		}
+		if err := h.storage.UpdateTokenSettings(id, settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "token updated"})
 }
 
@@ -198,7 +274,12 @@ func (h *TokenHandler) TestToken(c *gin.Context) {
 	}
 
 	// 测试 token
-	isValid, testResult := testPuterToken(t.Token)
+	isValid, testResult, err := h.testPuterToken(c.Request.Context(), t)
+	if err != nil {
+		// 客户端已断开，不更新有效性
+		abortCancelled(c, "Token")
+		return
+	}
 
 	// 更新有效性
 	h.storage.UpdateTokenValid(id, isValid)
@@ -209,6 +290,61 @@ func (h *TokenHandler) TestToken(c *gin.Context) {
 	})
 }
 
+// TestProxy 测试代理能否连通上游地址，用于保存前在管理页面验证设置
+// 提供 token_id 且代理为列表中返回的脱敏地址时，使用该 Token 已保存的代理
+func (h *TokenHandler) TestProxy(c *gin.Context) {
+	var req struct {
+		TokenID int64  `json:"token_id"`
+		Proxy   string `json:"proxy"`
+		BaseURL string `json:"base_url"`
+		Profile string `json:"profile"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := validateBaseURL(req.BaseURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateProxy(req.Proxy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TokenID != 0 {
+		t, err := h.storage.GetToken(req.TokenID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if t != nil {
+			req.Proxy = keepProxy(t, req.Proxy)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), proxyTestTimeout)
+	defer cancel()
+	elapsed, err := h.tester.client.TestProxy(ctx, puter.Credential{BaseURL: req.BaseURL, Proxy: req.Proxy, Profile: req.Profile})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"ok": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ok":         true,
+		"message":    "proxy is reachable",
+		"latency_ms": elapsed.Milliseconds(),
+	})
+}
+
+// ListProfiles 获取可用的请求头指纹
+func (h *TokenHandler) ListProfiles(c *gin.Context) {
+	profiles := h.tester.client.Profiles()
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles.Names(),
+		"rotate":   profiles.Rotate(),
+	})
+}
+
 // TestAllTokens 测试所有 Token
 func (h *TokenHandler) TestAllTokens(c *gin.Context) {
 	tokens, err := h.storage.GetAllTokens()
@@ -226,7 +362,11 @@ func (h *TokenHandler) TestAllTokens(c *gin.Context) {
 
 	var results []Result
 	for _, t := range tokens {
-		isValid, msg := testPuterToken(t.Token)
+		isValid, msg, err := h.testPuterToken(c.Request.Context(), &t)
+		if err != nil {
+			abortCancelled(c, "Token")
+			return
+		}
 		h.storage.UpdateTokenValid(t.ID, isValid)
 		results = append(results, Result{
 			ID:      t.ID,
@@ -239,26 +379,26 @@ func (h *TokenHandler) TestAllTokens(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
-// testPuterToken 测试 Puter token 是否有效
-func testPuterToken(token string) (bool, string) {
-	// 创建一个简单的测试请求
-	client := NewPuterTestClient()
-
+// testPuterToken 测试 Puter token 是否有效，仅在请求被取消时返回 error
+func (h *TokenHandler) testPuterToken(ctx context.Context, t *storage.Token) (bool, string, error) {
 	// 发送一个简单的测试消息
 	messages := []types.PuterMessage{
 		{Role: "user", Content: "Hi"},
 	}
 
-	resp, err := client.TestToken(messages, token)
+	resp, err := h.tester.TestToken(ctx, messages, credentialFor(t))
 	if err != nil {
-		return false, "Error: " + err.Error()
+		if puter.IsCancelled(err) {
+			return false, "", err
+		}
+		return false, "Error: " + err.Error(), nil
 	}
 
 	if resp != "" {
-		return true, "Token is valid"
+		return true, "Token is valid", nil
 	}
 
-	return false, "No response received"
+	return false, "No response received", nil
 }
 
 // maskToken 脱敏 token，只显示前10和后10个字符
@@ -269,54 +409,82 @@ func maskToken(token string) string {
 	return token[:10] + "..." + token[len(token)-10:]
 }
 
-// PuterTestClient 用于测试 token 的简单客户端
-type PuterTestClient struct {
-	httpClient *http.Client
+// validateBaseURL 校验上游地址，空字符串表示使用默认地址
+func validateBaseURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errInvalidBaseURL
+	}
+	return nil
 }
 
-// NewPuterTestClient 创建测试客户端
-func NewPuterTestClient() *PuterTestClient {
-	return &PuterTestClient{
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+// validateProfile 校验请求头指纹名称，空字符串表示按配置分配
+func (h *TokenHandler) validateProfile(name string) error {
+	if name == "" || h.tester.client.Profiles().Has(name) {
+		return nil
 	}
+	return fmt.Errorf("unknown header profile %q", name)
 }
 
-// TestToken 测试 token 是否有效
-func (c *PuterTestClient) TestToken(messages []types.PuterMessage, authToken string) (string, error) {
-	puterReq := types.PuterRequest{
-		Interface: "puter-chat-completion",
-		Driver:    "claude",
-		TestMode:  false,
-		Method:    "complete",
-		Args: types.PuterArgs{
-			Messages: messages,
-			Model:    "claude-sonnet-4-5-20250514",
-			Stream:   false,
-		},
-		AuthToken: authToken,
+// validateProxy 校验代理地址，空字符串表示直连
+func validateProxy(raw string) error {
+	if raw == "" {
+		return nil
 	}
+	_, err := puter.ParseProxy(raw)
+	return err
+}
 
-	body, _ := json.Marshal(puterReq)
-
-	req, err := http.NewRequest("POST", "https://api.puter.com/drivers/call", bytes.NewReader(body))
+// redactProxy 隐藏代理地址中的密码
+func redactProxy(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
 	if err != nil {
-		return "", err
+		return raw
 	}
+	return u.Redacted()
+}
 
-	req.Header.Set("content-type", "text/plain;actually=json")
-	req.Header.Set("origin", "https://docs.puter.com")
-	req.Header.Set("referer", "https://docs.puter.com/")
+// keepProxy 管理页面回传脱敏地址时保留已保存的代理
+func keepProxy(t *storage.Token, proxy string) string {
+	if proxy != "" && proxy == redactProxy(t.Proxy) {
+		return t.Proxy
+	}
+	return proxy
+}
 
-	resp, err := c.httpClient.Do(req)
+// PuterTestClient 用于测试 token 的简单客户端，与正式请求共用上游地址和连接
+type PuterTestClient struct {
+	client *puter.Client
+}
+
+// NewPuterTestClient 创建测试客户端
+func NewPuterTestClient(client *puter.Client) *PuterTestClient {
+	return &PuterTestClient{client: client}
+}
+
+// 测试请求使用的模型和超时
+const (
+	testModel        = "claude-sonnet-4-5-20250514"
+	testTimeout      = 30 * time.Second
+	proxyTestTimeout = 15 * time.Second
+)
+
+// TestToken 测试 token 是否有效，返回模型回复
+func (c *PuterTestClient) TestToken(ctx context.Context, messages []types.PuterMessage, cred puter.Credential) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, testTimeout)
+	defer cancel()
+
+	stream, err := c.client.StreamChat(ctx, puter.ChatRequest{Model: testModel, Messages: messages}, cred)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return "", nil
-	}
+	defer stream.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
-	return string(respBody), nil
+	return stream.ReadAll()
 }
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"puter2api/internal/breaker"
+	"puter2api/internal/puter"
+	"puter2api/internal/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// errNoToken 没有可用的 Token
+	errNoToken = errors.New("no active token available, please add a token first")
+	// errTokenStore 读取 Token 失败
+	errTokenStore = errors.New("failed to get token")
+)
+
+// RetryPolicy 上游失败时的重试与 Token 切换策略
+type RetryPolicy struct {
+	MaxRetries        int           // 最多重试次数（不含首次请求）
+	Backoff           time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	RateLimitCooldown time.Duration // 429 后 Token 的冷却时长
+	FundsCooldown     time.Duration // 余额不足后 Token 的冷却时长
+}
+
+// DefaultRetryPolicy 默认重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	cooldowns := storage.DefaultTokenCooldowns()
+	return RetryPolicy{
+		MaxRetries:        2,
+		Backoff:           500 * time.Millisecond,
+		RateLimitCooldown: cooldowns.RateLimit,
+		FundsCooldown:     cooldowns.Funds,
+	}
+}
+
+// Cooldowns 返回策略中的 Token 冷却时长
+func (p RetryPolicy) Cooldowns() storage.TokenCooldowns {
+	return storage.TokenCooldowns{RateLimit: p.RateLimitCooldown, Funds: p.FundsCooldown}
+}
+
+// RetryPolicyFromEnv 从环境变量读取重试策略，未设置或非法的项使用默认值
+//
+//	RETRY_MAX                  最多重试次数
+//	RETRY_BACKOFF              首次重试等待，如 500ms
+//	TOKEN_COOLDOWN_RATE_LIMIT  429 冷却时长，如 1m
+//	TOKEN_COOLDOWN_FUNDS       余额不足冷却时长，如 1h
+func RetryPolicyFromEnv() RetryPolicy {
+	p := DefaultRetryPolicy()
+	if v, err := strconv.Atoi(os.Getenv("RETRY_MAX")); err == nil && v >= 0 {
+		p.MaxRetries = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("RETRY_BACKOFF")); err == nil && v >= 0 {
+		p.Backoff = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("TOKEN_COOLDOWN_RATE_LIMIT")); err == nil && v > 0 {
+		p.RateLimitCooldown = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("TOKEN_COOLDOWN_FUNDS")); err == nil && v > 0 {
+		p.FundsCooldown = v
+	}
+	return p
+}
+
+// failureKind 上游失败类型
+type failureKind int
+
+const (
+	failureFatal             failureKind = iota // 请求本身有问题，不重试
+	failureTransient                            // 上游或网络临时故障，可重试
+	failureTokenInvalid                         // Token 失效
+	failureRateLimited                          // Token 被限流
+	failureInsufficientFunds                    // Token 余额不足
+)
+
+func (k failureKind) String() string {
+	switch k {
+	case failureTransient:
+		return "transient"
+	case failureTokenInvalid:
+		return "token_invalid"
+	case failureRateLimited:
+		return "rate_limited"
+	case failureInsufficientFunds:
+		return "insufficient_funds"
+	default:
+		return "fatal"
+	}
+}
+
+// classifyFailure 判断上游错误的类型
+func classifyFailure(err error) failureKind {
+	var openErr *breaker.OpenError
+	if errors.As(err, &openErr) {
+		// 驱动熔断中，换 Token 也无济于事
+		return failureFatal
+	}
+	puterErr, ok := puter.AsError(err)
+	if !ok {
+		// 网络错误、读取中断等
+		return failureTransient
+	}
+	if !puterErr.Retryable {
+		return failureFatal
+	}
+	if !puterErr.TokenFault {
+		return failureTransient
+	}
+
+	switch puterErr.Code {
+	case puter.CodeAuthFailed:
+		return failureTokenInvalid
+	case puter.CodeInsufficientFunds:
+		return failureInsufficientFunds
+	default:
+		return failureRateLimited
+	}
+}
+
+// withFailover 取一个可用 Token 执行 fn；失败时按错误类型标记 Token，并换 Token 退避重试
+//
+// fn 必须在向客户端写入任何内容之前返回（流式调用应在 Stream.Peek 之后返回），
+// 一旦开始写响应就不能再重试。
+func (h *Handler) withFailover(ctx context.Context, api string, fn func(token *storage.Token) error) (*storage.Token, error) {
+	var tried []int64
+	var lastErr error
+	lastKind := failureFatal
+
+	for attempt := 0; attempt <= h.retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(h.retry.Backoff << (attempt - 1)):
+			}
+		}
+
+		tokenRecord, err := h.store.GetActiveToken(tried...)
+		if err == nil && tokenRecord == nil && lastKind == failureTransient {
+			// 上游临时故障与 Token 无关，没有其他 Token 时允许复用
+			tokenRecord, err = h.store.GetActiveToken()
+		}
+		if err != nil {
+			log.Error().Str("api", api).Err(err).Msg("获取 Token 失败")
+			return nil, fmt.Errorf("%w: %v", errTokenStore, err)
+		}
+		if tokenRecord == nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, errNoToken
+		}
+
+		tried = append(tried, tokenRecord.ID)
+		log.Debug().Str("api", api).Str("token", tokenRecord.Name).Int64("id", tokenRecord.ID).Int("attempt", attempt+1).Msg("使用 Token")
+
+		// 更新 Token 使用时间
+		h.store.UpdateTokenUsed(tokenRecord.ID)
+
+		err = fn(tokenRecord)
+		if err == nil {
+			return tokenRecord, nil
+		}
+		if puter.IsCancelled(err) {
+			return nil, err
+		}
+
+		lastErr = err
+		lastKind = classifyFailure(err)
+		h.markToken(api, tokenRecord, lastKind)
+		if lastKind == failureFatal {
+			return nil, err
+		}
+
+		log.Warn().
+			Str("api", api).
+			Str("token", tokenRecord.Name).
+			Int("attempt", attempt+1).
+			Str("reason", lastKind.String()).
+			Err(err).
+			Msg("上游调用失败")
+	}
+	return nil, lastErr
+}
+
+// markToken 根据失败类型更新 Token 状态
+func (h *Handler) markToken(api string, t *storage.Token, kind failureKind) {
+	var fault storage.TokenFault
+	switch kind {
+	case failureTokenInvalid:
+		fault = storage.TokenInvalid
+	case failureRateLimited:
+		fault = storage.TokenRateLimited
+	case failureInsufficientFunds:
+		fault = storage.TokenOutOfFunds
+	default:
+		return
+	}
+	if err := h.store.MarkTokenFault(t.ID, fault, h.retry.Cooldowns()); err != nil {
+		log.Error().Str("api", api).Str("token", t.Name).Err(err).Msg("更新 Token 状态失败")
+		return
+	}
+	log.Warn().Str("api", api).Str("token", t.Name).Str("reason", kind.String()).Msg("标记 Token")
+}
+
+// credentialFor 根据 Token 记录构造上游调用凭据
+func credentialFor(t *storage.Token) puter.Credential {
+	return puter.Credential{Token: t.Token, BaseURL: t.BaseURL, Proxy: t.Proxy, Profile: t.Profile}
+}
+
+// openStream 带重试地打开上游流，返回时已预读首个块，确认上游正常响应
+// 驱动熔断中时直接返回 *breaker.OpenError，不调用上游
+func (h *Handler) openStream(ctx context.Context, api string, req puter.ChatRequest) (*puter.Stream, error) {
+	key := h.breakers.Key(puter.ResolveDriver(req.Model).Driver, req.Model)
+	var stream *puter.Stream
+	_, err := h.withFailover(ctx, api, func(t *storage.Token) error {
+		if err := h.breakers.Allow(key); err != nil {
+			return err
+		}
+		s, err := h.puterClient.StreamChat(ctx, req, credentialFor(t))
+		if err == nil {
+			if err = s.Peek(); err != nil {
+				s.Close()
+			}
+		}
+		h.reportBreaker(key, err)
+		if err != nil {
+			return err
+		}
+		stream = s
+		return nil
+	})
+	return stream, err
+}
+
+// reportBreaker 向熔断器报告一次上游调用的结果；只有与 Token 无关的上游故障计入失败
+func (h *Handler) reportBreaker(key string, err error) {
+	switch {
+	case err == nil:
+		h.breakers.Success(key)
+	case !puter.IsCancelled(err) && classifyFailure(err) == failureTransient:
+		h.breakers.Failure(key, err)
+	default:
+		h.breakers.Release(key)
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"strings"
+
+	"puter2api/internal/puter"
+	"puter2api/internal/tokenizer"
+	"puter2api/internal/types"
+)
+
+// usageTracker 统计一次请求的用量：优先使用上游报告的值，否则用模型对应的分词器估算
+// 命中响应缓存时输入用量记为 cache_read_input_tokens
+type usageTracker struct {
+	enc           tokenizer.Encoder
+	inputEstimate int
+	cacheHit      bool
+	output        strings.Builder
+}
+
+// newUsageTracker 根据发送给上游的消息和工具定义估算输入用量
+func newUsageTracker(req puter.ChatRequest) *usageTracker {
+	enc := tokenizer.ForModel(req.Model)
+	return &usageTracker{enc: enc, inputEstimate: tokenizer.CountInput(enc, req.Messages, req.Tools)}
+}
+
+// startUsage 返回流开始时报告的输入用量估算
+func (u *usageTracker) startUsage() types.Usage {
+	if u.cacheHit {
+		return types.Usage{CacheReadInputTokens: u.inputEstimate}
+	}
+	return types.Usage{InputTokens: u.inputEstimate}
+}
+
+// addOutput 记录一段输出文本
+func (u *usageTracker) addOutput(text string) {
+	u.output.WriteString(text)
+}
+
+// final 返回最终用量；第二个返回值表示输入用量是否来自上游
+func (u *usageTracker) final(stream *puter.Stream) (types.Usage, bool) {
+	usage := types.Usage{
+		InputTokens:  u.inputEstimate,
+		OutputTokens: u.enc.Count(u.output.String()),
+	}
+	fromUpstream := false
+	if upstream, ok := stream.Usage(); ok {
+		if upstream.OutputTokens > 0 {
+			usage.OutputTokens = upstream.OutputTokens
+		}
+		if upstream.InputTokens > 0 {
+			usage.InputTokens = upstream.InputTokens
+			fromUpstream = true
+		}
+	}
+	if u.cacheHit {
+		usage.CacheReadInputTokens, usage.InputTokens = usage.InputTokens, 0
+	}
+	return usage, fromUpstream
+}
+
+// toOpenAIUsage 转换为 OpenAI usage
+func toOpenAIUsage(u types.Usage) *types.OpenAIUsage {
+	prompt := u.InputTokens + u.CacheReadInputTokens
+	usage := &types.OpenAIUsage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+	}
+	if u.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &types.OpenAIPromptTokensDetails{CachedTokens: u.CacheReadInputTokens}
+	}
+	return usage
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"puter2api/internal/puter"
+	"puter2api/internal/types"
+)
+
+func TestUsageTracker_CacheHit(t *testing.T) {
+	req := puter.ChatRequest{Model: "gpt-5", Messages: []types.PuterMessage{{Role: "user", Content: "hello world"}}}
+	tracker := newUsageTracker(req)
+	tracker.cacheHit = true
+	tracker.addOutput("cached answer")
+
+	usage, _ := tracker.final(puter.NewReplayStream(io.NopCloser(strings.NewReader(""))))
+	if usage.InputTokens != 0 || usage.CacheReadInputTokens != tracker.inputEstimate || usage.OutputTokens == 0 {
+		t.Errorf("cache hit should be reported as cache reads: %+v", usage)
+	}
+
+	openai := toOpenAIUsage(usage)
+	if openai.PromptTokens != tracker.inputEstimate || openai.PromptTokensDetails == nil || openai.PromptTokensDetails.CachedTokens != tracker.inputEstimate {
+		t.Errorf("unexpected OpenAI usage: %+v", openai)
+	}
+}
@@ -0,0 +1,95 @@
+package puter
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Broadcast 一路上游响应的原始内容，供多个读者同时读取
+// 写入端为 Stream.Tee，读者随时加入都从头读起，写入未结束时阻塞等待新内容
+type Broadcast struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+	err    error
+}
+
+// NewBroadcast 创建 Broadcast
+func NewBroadcast() *Broadcast {
+	b := &Broadcast{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Write 追加内容并唤醒等待中的读者
+func (b *Broadcast) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// CloseWithError 结束写入，err 为 nil 表示上游正常结束；读者读完已有内容后收到 io.EOF 或 err
+func (b *Broadcast) CloseWithError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed, b.err = true, err
+		b.cond.Broadcast()
+	}
+}
+
+// Bytes 返回全部内容；上游未正常结束时第二个返回值为 false
+func (b *Broadcast) Bytes() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data, b.closed && b.err == nil
+}
+
+// NewReader 创建从头读取的读者，ctx 取消时等待中的 Read 返回 ctx.Err()
+func (b *Broadcast) NewReader(ctx context.Context) io.ReadCloser {
+	r := &broadcastReader{b: b, ctx: ctx}
+	r.stop = context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	return r
+}
+
+// broadcastReader Broadcast 的读者
+type broadcastReader struct {
+	b    *Broadcast
+	ctx  context.Context
+	off  int
+	stop func() bool
+}
+
+func (r *broadcastReader) Read(p []byte) (int, error) {
+	b := r.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for r.off >= len(b.data) && !b.closed && r.ctx.Err() == nil {
+		b.cond.Wait()
+	}
+	if r.off < len(b.data) {
+		n := copy(p, b.data[r.off:])
+		r.off += n
+		return n, nil
+	}
+	if b.closed {
+		if b.err != nil {
+			return 0, b.err
+		}
+		return 0, io.EOF
+	}
+	return 0, r.ctx.Err()
+}
+
+func (r *broadcastReader) Close() error {
+	r.stop()
+	return nil
+}
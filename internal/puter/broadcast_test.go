@@ -0,0 +1,86 @@
+package puter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBroadcast(t *testing.T) {
+	b := NewBroadcast()
+	b.Write([]byte("hello "))
+
+	results := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		r := b.NewReader(context.Background())
+		go func() {
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err != nil {
+				results <- "error: " + err.Error()
+				return
+			}
+			results <- string(data)
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := b.Bytes(); ok {
+		t.Error("Bytes should not be complete before close")
+	}
+	b.Write([]byte("world"))
+	b.CloseWithError(nil)
+
+	for i := 0; i < 2; i++ {
+		if got := <-results; got != "hello world" {
+			t.Errorf("reader %d = %q", i, got)
+		}
+	}
+	// 结束后加入的读者也能读到完整内容
+	data, err := io.ReadAll(b.NewReader(context.Background()))
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("late reader = %q, %v", data, err)
+	}
+	if data, ok := b.Bytes(); !ok || string(data) != "hello world" {
+		t.Errorf("Bytes = %q, %v", data, ok)
+	}
+}
+
+func TestBroadcast_Error(t *testing.T) {
+	b := NewBroadcast()
+	upstreamErr := errors.New("connection reset")
+	b.Write([]byte("partial"))
+	b.CloseWithError(upstreamErr)
+
+	data, err := io.ReadAll(b.NewReader(context.Background()))
+	if string(data) != "partial" || !errors.Is(err, upstreamErr) {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if _, ok := b.Bytes(); ok {
+		t.Error("Bytes should not be complete after error")
+	}
+}
+
+func TestBroadcast_ReaderCancel(t *testing.T) {
+	b := NewBroadcast()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := b.NewReader(ctx)
+	defer r.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 8))
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !IsCancelled(err) {
+			t.Errorf("expected cancellation, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after cancel")
+	}
+}
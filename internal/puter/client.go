@@ -1,10 +1,9 @@
 package puter
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -14,14 +13,11 @@ import (
 	"puter2api/internal/types"
 )
 
-const (
-	apiURL = "https://api.puter.com/drivers/call"
-)
-
 // Client Puter API 客户端
 type Client struct {
-	httpClient *http.Client
-	userAgent  string
+	transports *transportCache
+	profiles   *Profiles
+	endpoint   Endpoint
 }
 
 // DriverInfo 驱动信息
@@ -30,230 +26,255 @@ type DriverInfo struct {
 	Driver    string
 	Model     string // 实际传给 Puter 的模型名
 	Method    string
+	Provider  string // 模型提供商，用于 /v1/models 的 owned_by
+	Route     string // 命中的路由规则名称
+	Caps      Capabilities
+}
+
+// Capabilities 驱动接受的可选参数，不支持的参数由调用方在本地处理
+type Capabilities struct {
+	MaxTokens   bool `json:"max_tokens"`
+	Temperature bool `json:"temperature"`
+	TopP        bool `json:"top_p"`
+	TopK        bool `json:"top_k"`
+	Stop        bool `json:"stop"`
+	Penalties   bool `json:"penalties"` // presence_penalty / frequency_penalty
+	Vision      bool `json:"vision"`    // 接受图片输入
+	Tools       bool `json:"tools"`     // 原生工具调用，不支持时在 system prompt 中模拟
+	Thinking    bool `json:"thinking"`  // 接受 thinking 预算（Claude 风格）
+	Reasoning   bool `json:"reasoning"` // 接受 reasoning_effort（OpenAI 风格）
+}
+
+// driverCapabilities 各对话驱动透传给上游的参数
+var driverCapabilities = map[string]Capabilities{
+	"claude":            {MaxTokens: true, Temperature: true, TopP: true, TopK: true, Tools: true, Thinking: true},
+	"openai-completion": {MaxTokens: true, Temperature: true, TopP: true, Penalties: true, Tools: true, Reasoning: true},
+	"gemini":            {MaxTokens: true, Temperature: true, TopP: true, Tools: true, Thinking: true},
+	"xai":               {MaxTokens: true, Temperature: true, TopP: true, Reasoning: true},
+	"deepseek":          {MaxTokens: true, Temperature: true, TopP: true, Penalties: true},
+	"mistral":           {MaxTokens: true, Temperature: true, TopP: true},
+	"openrouter":        {MaxTokens: true, Temperature: true, TopP: true, Penalties: true},
+	"together-ai":       {MaxTokens: true, Temperature: true, TopP: true, Penalties: true},
 }
 
-// NewClient 创建新的客户端
-func NewClient() *Client {
+// ChatRequest 对话请求
+type ChatRequest struct {
+	Model    string
+	Messages []types.PuterMessage
+	Params   types.SamplingParams
+	Tools    []types.OpenAITool // 原生工具定义，驱动不支持时忽略
+}
+
+// NewClient 创建新的客户端，endpoint 为默认上游地址，profiles 为空时使用默认请求头指纹
+func NewClient(endpoint Endpoint, profiles *Profiles) *Client {
+	if profiles == nil {
+		profiles, _ = NewProfiles(nil, false)
+	}
 	return &Client{
-		httpClient: &http.Client{
-			Timeout: 5 * time.Minute,
-			Transport: &http.Transport{
-				DisableKeepAlives:   false,
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     90 * time.Second,
-			},
-		},
-		userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36",
+		transports: newTransportCache(),
+		profiles:   profiles,
+		endpoint:   endpoint,
 	}
 }
 
-// ResolveDriver 根据模型 ID 确定 Puter 的 interface/driver/model
+// Profiles 返回客户端使用的请求头指纹集合
+func (c *Client) Profiles() *Profiles {
+	return c.profiles
+}
+
+// Endpoint 返回 Token 实际使用的上游地址
+func (c *Client) Endpoint(cred Credential) Endpoint {
+	return c.endpoint.WithBaseURL(cred.BaseURL)
+}
+
+// ResolveDriver 根据当前路由表确定模型的 Puter interface/driver/model
 func ResolveDriver(modelID string) DriverInfo {
-	// openrouter: 前缀
-	if strings.HasPrefix(modelID, "openrouter:") {
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "openrouter",
-			Model:     strings.TrimPrefix(modelID, "openrouter:"),
-			Method:    "complete",
-		}
-	}
-	// togetherai: 前缀
-	if strings.HasPrefix(modelID, "togetherai:") {
-		innerModel := strings.TrimPrefix(modelID, "togetherai:")
-		if isImageModel(innerModel) {
-			return DriverInfo{
-				Interface: "puter-image-generation",
-				Driver:    "together-ai-image-generation",
-				Model:     innerModel,
-				Method:    "generate",
-			}
-		}
-		if isVideoModel(innerModel) {
-			return DriverInfo{
-				Interface: "puter-video-generation",
-				Driver:    "together",
-				Model:     innerModel,
-				Method:    "generate",
-			}
-		}
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "together-ai",
-			Model:     innerModel,
-			Method:    "complete",
-		}
-	}
-	// Claude 系列
-	if strings.HasPrefix(modelID, "claude-") {
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "claude",
-			Model:     modelID,
-			Method:    "complete",
-		}
+	route, model := Routes().Resolve(modelID)
+	info := DriverInfo{
+		Interface: route.Interface,
+		Driver:    route.Driver,
+		Model:     model,
+		Method:    route.Method,
+		Provider:  route.Provider,
+		Route:     route.Name,
 	}
-	// OpenAI GPT / o 系列
-	if strings.HasPrefix(modelID, "gpt-") || strings.HasPrefix(modelID, "o1") || strings.HasPrefix(modelID, "o3") || strings.HasPrefix(modelID, "o4") {
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "openai-completion",
-			Model:     modelID,
-			Method:    "complete",
-		}
-	}
-	// Gemini 系列
-	if strings.HasPrefix(modelID, "gemini-") {
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "gemini",
-			Model:     modelID,
-			Method:    "complete",
-		}
-	}
-	// Grok 系列
-	if strings.HasPrefix(modelID, "grok-") {
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "xai",
-			Model:     modelID,
-			Method:    "complete",
-		}
-	}
-	// DeepSeek 系列
-	if strings.HasPrefix(modelID, "deepseek-") {
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "deepseek",
-			Model:     modelID,
-			Method:    "complete",
-		}
-	}
-	// Mistral 系列 (包括 codestral, devstral, pixtral, magistral, ministral)
-	if strings.HasPrefix(modelID, "mistral-") || strings.HasPrefix(modelID, "ministral-") ||
-		strings.HasPrefix(modelID, "open-mistral-") || strings.HasPrefix(modelID, "pixtral-") ||
-		strings.HasPrefix(modelID, "codestral-") || strings.HasPrefix(modelID, "devstral-") ||
-		strings.HasPrefix(modelID, "magistral-") {
-		return DriverInfo{
-			Interface: "puter-chat-completion",
-			Driver:    "mistral",
-			Model:     modelID,
-			Method:    "complete",
-		}
-	}
-	// 默认走 openai-completion
-	return DriverInfo{
-		Interface: "puter-chat-completion",
-		Driver:    "openai-completion",
-		Model:     modelID,
-		Method:    "complete",
+	info.Caps = driverCapabilities[info.Driver]
+	info.Caps.Vision = supportsVision(info.Driver, info.Model)
+	return info
+}
+
+// ResolveImageDriver 确定图片生成模型的驱动，路由表未指向图片生成接口时强制使用 openai-image-generation
+func ResolveImageDriver(modelID string) DriverInfo {
+	driver := ResolveDriver(modelID)
+	if driver.Interface != "puter-image-generation" {
+		driver.Interface = "puter-image-generation"
+		driver.Driver = "openai-image-generation"
+		driver.Method = "generate"
 	}
+	return driver
 }
 
-// isImageModel 判断是否是图片生成模型
-func isImageModel(model string) bool {
-	lower := strings.ToLower(model)
-	imageKeywords := []string{"flux", "stable-diffusion", "dall-e", "imagen", "hidream", "ideogram",
-		"seedream", "juggernaut", "dreamshaper", "flash-image", "gemini-3-pro-image"}
-	for _, kw := range imageKeywords {
-		if strings.Contains(lower, kw) {
-			return true
-		}
+// ResolveVideoDriver 确定视频生成模型的驱动，路由表未指向视频生成接口时强制使用 together
+func ResolveVideoDriver(modelID string) DriverInfo {
+	driver := ResolveDriver(modelID)
+	if driver.Interface != "puter-video-generation" {
+		driver.Interface = "puter-video-generation"
+		driver.Driver = "together"
+		driver.Method = "generate"
 	}
-	return false
+	return driver
 }
 
-// isVideoModel 判断是否是视频生成模型
-func isVideoModel(model string) bool {
+// supportsVision 判断模型是否接受图片输入
+// openrouter / together-ai 下的模型能力各异，交给上游校验
+func supportsVision(driver, model string) bool {
 	lower := strings.ToLower(model)
-	videoKeywords := []string{"sora", "veo", "kling", "seedance", "wan2", "hailuo", "vidu", "pixverse"}
-	for _, kw := range videoKeywords {
-		if strings.Contains(lower, kw) {
-			return true
+	switch driver {
+	case "claude", "gemini", "openrouter", "together-ai":
+		return true
+	case "openai-completion":
+		if strings.HasSuffix(lower, "-mini") && (strings.HasPrefix(lower, "o1") || strings.HasPrefix(lower, "o3")) {
+			return false
 		}
+		for _, prefix := range []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"} {
+			if strings.HasPrefix(lower, prefix) {
+				return true
+			}
+		}
+		return false
+	case "xai":
+		return strings.Contains(lower, "vision") || strings.HasPrefix(lower, "grok-4")
+	case "mistral":
+		return strings.HasPrefix(lower, "pixtral-") || strings.HasPrefix(lower, "mistral-medium") || strings.HasPrefix(lower, "mistral-small")
+	default:
+		return false
 	}
-	return false
-}
-
-// Call 调用 Puter API 并返回完整响应文本
-func (c *Client) Call(messages []types.PuterMessage, authToken string) (string, error) {
-	return c.CallWithModel(messages, authToken, "claude-opus-4-5-20251001")
 }
 
-// CallWithModel 调用 Puter API 并返回完整响应文本（指定模型）
-func (c *Client) CallWithModel(messages []types.PuterMessage, authToken string, model string) (string, error) {
-	driver := ResolveDriver(model)
+// StreamChat 调用 Puter API 并返回流式读取器，调用方负责 Close
+// 只透传驱动支持的采样参数；ctx 取消（客户端断开）时上游请求会随之中断
+func (c *Client) StreamChat(ctx context.Context, req ChatRequest, cred Credential) (*Stream, error) {
+	driver := ResolveDriver(req.Model)
+	messages := req.Messages
 
 	puterReq := types.PuterRequest{
 		Interface: driver.Interface,
 		Driver:    driver.Driver,
 		TestMode:  false,
 		Method:    driver.Method,
-		Args: types.PuterArgs{
-			Messages: messages,
-			Model:    driver.Model,
-			Stream:   true,
-		},
-		AuthToken: authToken,
+		Args:      buildChatArgs(driver, messages, req),
+		AuthToken: cred.Token,
 	}
 
 	body, _ := json.Marshal(puterReq)
 	startTime := time.Now()
 	log.Printf("[Puter] 开始请求, model=%s, driver=%s, interface=%s, messages=%d", driver.Model, driver.Driver, driver.Interface, len(messages))
 
-	httpReq, err := http.NewRequest("POST", apiURL, bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint(cred).DriversURL(), bytes.NewReader(body))
 	if err != nil {
 		log.Printf("[Puter] 创建请求失败: %v", err)
-		return "", err
+		return nil, err
 	}
 
-	c.setHeaders(httpReq)
+	c.setHeaders(httpReq, cred)
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq, cred)
 	if err != nil {
-		log.Printf("[Puter] 请求失败: %v", err)
-		return "", err
+		if IsCancelled(err) {
+			log.Printf("[Puter] 请求已取消 (client_cancelled)")
+		} else {
+			log.Printf("[Puter] 请求失败: %v", err)
+		}
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	// 检查 HTTP 状态码
 	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		log.Printf("[Puter] API 错误: status=%d, body=%s", resp.StatusCode, string(bodyBytes))
-		return "", fmt.Errorf("puter API error: status=%d, body=%s", resp.StatusCode, string(bodyBytes))
+		return nil, newHTTPError(resp.StatusCode, bodyBytes)
 	}
 
-	// 收集完整响应
-	var fullText strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-		var chunk types.PuterStreamChunk
-		if err := json.Unmarshal([]byte(line), &chunk); err == nil && chunk.Text != "" {
-			fullText.WriteString(chunk.Text)
-		}
+	return newStream(resp.Body, startTime), nil
+}
+
+// buildChatArgs 构造对话参数，丢弃驱动不支持的采样参数和工具定义
+func buildChatArgs(driver DriverInfo, messages []types.PuterMessage, req ChatRequest) types.PuterArgs {
+	args := types.PuterArgs{
+		Messages: messages,
+		Model:    driver.Model,
+		Stream:   true,
+	}
+	caps := driver.Caps
+	if caps.Tools {
+		args.Tools = req.Tools
+	}
+	p := req.Params
+	if caps.MaxTokens {
+		args.MaxTokens = p.MaxTokens
+	}
+	if caps.Temperature {
+		args.Temperature = p.Temperature
+	}
+	if caps.TopP {
+		args.TopP = p.TopP
+	}
+	if caps.TopK {
+		args.TopK = p.TopK
 	}
+	if caps.Stop {
+		args.Stop = p.Stop
+	}
+	if caps.Penalties {
+		args.PresencePenalty = p.PresencePenalty
+		args.FrequencyPenalty = p.FrequencyPenalty
+	}
+	if caps.Thinking {
+		args.Thinking = thinkingConfig(p)
+	}
+	if caps.Reasoning {
+		args.ReasoningEffort = reasoningEffort(p)
+	}
+	return args
+}
 
-	responseText := fullText.String()
-	elapsed := time.Since(startTime)
-	log.Printf("[Puter] 请求完成, 耗时: %v, 响应: %d 字符", elapsed, len(responseText))
+// thinking 预算与 reasoning_effort 的对应关系
+var effortBudgets = map[string]int{
+	"low":    2048,
+	"medium": 8192,
+	"high":   24576,
+}
 
-	return responseText, nil
+// thinkingConfig 返回传给 Claude 风格驱动的 thinking 配置，只设置了 reasoning_effort 时按档位换算预算
+func thinkingConfig(p types.SamplingParams) *types.ThinkingConfig {
+	if p.Thinking != nil {
+		return p.Thinking
+	}
+	if budget, ok := effortBudgets[p.ReasoningEffort]; ok {
+		return &types.ThinkingConfig{Type: "enabled", BudgetTokens: budget}
+	}
+	return nil
 }
 
-// CallImageGeneration 调用 Puter 图片生成 API
-func (c *Client) CallImageGeneration(prompt string, model string, authToken string) ([]byte, error) {
-	driver := ResolveDriver(model)
-	// 强制图片生成接口
-	if driver.Interface != "puter-image-generation" {
-		driver.Interface = "puter-image-generation"
-		driver.Driver = "openai-image-generation"
-		driver.Method = "generate"
+// reasoningEffort 返回传给 OpenAI 风格驱动的 reasoning_effort，只设置了 thinking 时按预算换算档位
+func reasoningEffort(p types.SamplingParams) string {
+	if p.ReasoningEffort != "" || !p.Thinking.Enabled() {
+		return p.ReasoningEffort
 	}
+	switch budget := p.Thinking.BudgetTokens; {
+	case budget < effortBudgets["medium"]:
+		return "low"
+	case budget < effortBudgets["high"]:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// CallImageGeneration 调用 Puter 图片生成 API
+func (c *Client) CallImageGeneration(ctx context.Context, prompt string, model string, cred Credential) ([]byte, error) {
+	driver := ResolveImageDriver(model)
 
 	reqBody := map[string]any{
 		"interface": driver.Interface,
@@ -264,20 +285,20 @@ func (c *Client) CallImageGeneration(prompt string, model string, authToken stri
 			"prompt": prompt,
 			"model":  driver.Model,
 		},
-		"auth_token": authToken,
+		"auth_token": cred.Token,
 	}
 
 	body, _ := json.Marshal(reqBody)
 	startTime := time.Now()
 	log.Printf("[Puter] 图片生成请求, model=%s, driver=%s", driver.Model, driver.Driver)
 
-	httpReq, err := http.NewRequest("POST", apiURL, bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint(cred).DriversURL(), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	c.setHeaders(httpReq)
+	c.setHeaders(httpReq, cred)
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq, cred)
 	if err != nil {
 		return nil, err
 	}
@@ -287,7 +308,13 @@ func (c *Client) CallImageGeneration(prompt string, model string, authToken stri
 
 	if resp.StatusCode != 200 {
 		log.Printf("[Puter] 图片生成错误: status=%d, body=%s", resp.StatusCode, string(respBytes))
-		return nil, fmt.Errorf("puter image API error: status=%d, body=%s", resp.StatusCode, string(respBytes))
+		return nil, newHTTPError(resp.StatusCode, respBytes)
+	}
+
+	// 200 响应中也可能是错误 JSON
+	if puterErr, ok := parseStreamError(respBytes); ok {
+		log.Printf("[Puter] 图片生成错误: code=%s, message=%s", puterErr.Code, puterErr.Message)
+		return nil, puterErr
 	}
 
 	elapsed := time.Since(startTime)
@@ -297,14 +324,8 @@ func (c *Client) CallImageGeneration(prompt string, model string, authToken stri
 }
 
 // CallVideoGeneration 调用 Puter 视频生成 API
-func (c *Client) CallVideoGeneration(prompt string, model string, authToken string, width, height, fps int) ([]byte, error) {
-	driver := ResolveDriver(model)
-	// 强制视频生成接口
-	if driver.Interface != "puter-video-generation" {
-		driver.Interface = "puter-video-generation"
-		driver.Driver = "together"
-		driver.Method = "generate"
-	}
+func (c *Client) CallVideoGeneration(ctx context.Context, prompt string, model string, cred Credential, width, height, fps int) ([]byte, error) {
+	driver := ResolveVideoDriver(model)
 
 	args := map[string]any{
 		"prompt": prompt,
@@ -326,20 +347,20 @@ func (c *Client) CallVideoGeneration(prompt string, model string, authToken stri
 		"test_mode":  false,
 		"method":     driver.Method,
 		"args":       args,
-		"auth_token": authToken,
+		"auth_token": cred.Token,
 	}
 
 	body, _ := json.Marshal(reqBody)
 	startTime := time.Now()
 	log.Printf("[Puter] 视频生成请求, model=%s, driver=%s", driver.Model, driver.Driver)
 
-	httpReq, err := http.NewRequest("POST", apiURL, bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint(cred).DriversURL(), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	c.setHeaders(httpReq)
+	c.setHeaders(httpReq, cred)
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq, cred)
 	if err != nil {
 		return nil, err
 	}
@@ -349,7 +370,13 @@ func (c *Client) CallVideoGeneration(prompt string, model string, authToken stri
 
 	if resp.StatusCode != 200 {
 		log.Printf("[Puter] 视频生成错误: status=%d, body=%s", resp.StatusCode, string(respBytes))
-		return nil, fmt.Errorf("puter video API error: status=%d, body=%s", resp.StatusCode, string(respBytes))
+		return nil, newHTTPError(resp.StatusCode, respBytes)
+	}
+
+	// 200 响应中也可能是错误 JSON
+	if puterErr, ok := parseStreamError(respBytes); ok {
+		log.Printf("[Puter] 视频生成错误: code=%s, message=%s", puterErr.Code, puterErr.Message)
+		return nil, puterErr
 	}
 
 	elapsed := time.Since(startTime)
@@ -358,27 +385,17 @@ func (c *Client) CallVideoGeneration(prompt string, model string, authToken stri
 	return respBytes, nil
 }
 
-func (c *Client) setHeaders(req *http.Request) {
+// setHeaders 设置浏览器请求头，UA、sec-ch-ua 等指纹按 Token 使用的指纹设置
+func (c *Client) setHeaders(req *http.Request, cred Credential) {
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("Content-Type", "text/plain;actually=json")
 	req.Header.Set("DNT", "1")
-	req.Header.Set("Origin", "https://docs.puter.com")
 	req.Header.Set("Pragma", "no-cache")
-	req.Header.Set("Referer", "https://docs.puter.com/")
 	req.Header.Set("Sec-Fetch-Dest", "empty")
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Site", "same-site")
-	req.Header.Set("User-Agent", c.userAgent)
-	req.Header.Set("sec-ch-ua", `"Chromium";v="142", "Google Chrome";v="142", "Not_A Brand";v="99"`)
-	req.Header.Set("sec-ch-ua-mobile", "?0")
-	req.Header.Set("sec-ch-ua-platform", `"macOS"`)
-	req.Header.Set("sec-ch-ua-full-version-list", `"Chromium";v="142.0.7355.4", "Google Chrome";v="142.0.7355.4", "Not_A Brand";v="99.0.0.0"`)
-	req.Header.Set("sec-ch-ua-arch", `"arm"`)
-	req.Header.Set("sec-ch-ua-bitness", `"64"`)
-	req.Header.Set("sec-ch-ua-model", `""`)
-	req.Header.Set("sec-ch-ua-platform-version", `"15.0.0"`)
+	c.profiles.For(cred).apply(req.Header)
 }
@@ -0,0 +1,127 @@
+package puter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"puter2api/internal/types"
+)
+
+func TestBuildChatArgs_DropsUnsupportedParams(t *testing.T) {
+	temp, penalty := 0.2, 0.5
+	params := types.SamplingParams{
+		MaxTokens:       256,
+		Temperature:     &temp,
+		Stop:            []string{"END"},
+		PresencePenalty: &penalty,
+	}
+
+	args := buildChatArgs(ResolveDriver("claude-sonnet-4-5"), nil, ChatRequest{Params: params})
+	if args.MaxTokens != 256 || args.Temperature == nil || *args.Temperature != 0.2 {
+		t.Errorf("supported params should be forwarded: %+v", args)
+	}
+	if args.PresencePenalty != nil || args.Stop != nil {
+		t.Errorf("unsupported params should be dropped: %+v", args)
+	}
+
+	args = buildChatArgs(ResolveDriver("gpt-4o"), nil, ChatRequest{Params: params})
+	if args.PresencePenalty == nil {
+		t.Errorf("openai-completion should receive penalties")
+	}
+}
+
+func TestResolveDriver_Vision(t *testing.T) {
+	tests := map[string]bool{
+		"claude-sonnet-4-5": true,
+		"gpt-4o":            true,
+		"o3-mini":           false,
+		"o4-mini":           true,
+		"deepseek-chat":     false,
+		"pixtral-large":     true,
+		"mistral-large":     false,
+	}
+	for model, want := range tests {
+		if got := ResolveDriver(model).Caps.Vision; got != want {
+			t.Errorf("%s: vision=%v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestResolveGenerationDrivers(t *testing.T) {
+	if d := ResolveImageDriver("dall-e-3"); d.Interface != "puter-image-generation" || d.Driver != "openai-image-generation" {
+		t.Errorf("image driver = %+v", d)
+	}
+	if d := ResolveVideoDriver("togetherai:minimax/hailuo-02"); d.Interface != "puter-video-generation" || d.Driver != "together" {
+		t.Errorf("video driver = %+v", d)
+	}
+}
+
+func TestBuildChatArgs_Reasoning(t *testing.T) {
+	effort := ChatRequest{Params: types.SamplingParams{ReasoningEffort: "high"}}
+	args := buildChatArgs(ResolveDriver("claude-sonnet-4-5"), nil, effort)
+	if !args.Thinking.Enabled() || args.Thinking.BudgetTokens != 24576 || args.ReasoningEffort != "" {
+		t.Errorf("claude should receive a thinking budget: %+v", args)
+	}
+
+	thinking := ChatRequest{Params: types.SamplingParams{Thinking: &types.ThinkingConfig{Type: "enabled", BudgetTokens: 10000}}}
+	args = buildChatArgs(ResolveDriver("o3"), nil, thinking)
+	if args.ReasoningEffort != "medium" || args.Thinking != nil {
+		t.Errorf("openai should receive reasoning_effort: %+v", args)
+	}
+
+	args = buildChatArgs(ResolveDriver("deepseek-reasoner"), nil, thinking)
+	if args.ReasoningEffort != "" || args.Thinking != nil {
+		t.Errorf("unsupported driver should not receive reasoning params: %+v", args)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	e := Endpoint{BaseURL: "https://mirror.example.com/"}
+	if e.DriversURL() != "https://mirror.example.com/drivers/call" {
+		t.Errorf("unexpected drivers url: %s", e.DriversURL())
+	}
+	if e.ModelsEndpoint() != "https://mirror.example.com/puterai/chat/models" {
+		t.Errorf("unexpected models url: %s", e.ModelsEndpoint())
+	}
+
+	e = Endpoint{BaseURL: DefaultBaseURL, ModelsURL: "https://models.example.com/list"}
+	if e.ModelsEndpoint() != "https://models.example.com/list" {
+		t.Errorf("explicit models url should win: %s", e.ModelsEndpoint())
+	}
+	if got := e.WithBaseURL("http://localhost:4100").ModelsEndpoint(); got != "http://localhost:4100/puterai/chat/models" {
+		t.Errorf("token base url should override models url: %s", got)
+	}
+	if e.WithBaseURL("") != e {
+		t.Errorf("empty override should keep the default endpoint")
+	}
+}
+
+func TestStreamChat_UsesTokenBaseURL(t *testing.T) {
+	var gotPath, gotToken string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		var req types.PuterRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotToken = req.AuthToken
+		w.Write([]byte(`{"type":"text","text":"hello"}` + "\n"))
+	}))
+	defer srv.Close()
+
+	c := NewClient(Endpoint{BaseURL: "http://127.0.0.1:1"}, nil)
+	stream, err := c.StreamChat(context.Background(), ChatRequest{Model: "claude-sonnet-4-5"}, Credential{Token: "tok", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Close()
+
+	text, err := stream.ReadAll()
+	if err != nil || text != "hello" {
+		t.Errorf("unexpected response: %q, %v", text, err)
+	}
+	if gotPath != "/drivers/call" || gotToken != "tok" {
+		t.Errorf("unexpected request: path=%s token=%s", gotPath, gotToken)
+	}
+}
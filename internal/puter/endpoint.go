@@ -0,0 +1,82 @@
+package puter
+
+import (
+	"os"
+	"strings"
+)
+
+// DefaultBaseURL Puter 官方 API 地址
+const DefaultBaseURL = "https://api.puter.com"
+
+// Endpoint 上游地址，可指向自建 Puter、镜像或本地 mock
+type Endpoint struct {
+	BaseURL        string // 如 https://api.puter.com
+	ModelsURL      string // 模型列表地址，为空时为 BaseURL + /puterai/chat/models
+	ImageModelsURL string // 图片模型列表地址，为空时为 BaseURL + /puterai/image/models
+	VideoModelsURL string // 视频模型列表地址，为空时为 BaseURL + /puterai/video/models
+}
+
+// EndpointFromEnv 从环境变量读取上游地址，未设置时使用官方地址
+//
+//	PUTER_BASE_URL          上游 API 地址
+//	PUTER_MODELS_URL        模型列表地址
+//	PUTER_IMAGE_MODELS_URL  图片模型列表地址
+//	PUTER_VIDEO_MODELS_URL  视频模型列表地址
+func EndpointFromEnv() Endpoint {
+	e := Endpoint{
+		BaseURL:        os.Getenv("PUTER_BASE_URL"),
+		ModelsURL:      os.Getenv("PUTER_MODELS_URL"),
+		ImageModelsURL: os.Getenv("PUTER_IMAGE_MODELS_URL"),
+		VideoModelsURL: os.Getenv("PUTER_VIDEO_MODELS_URL"),
+	}
+	if e.BaseURL == "" {
+		e.BaseURL = DefaultBaseURL
+	}
+	return e
+}
+
+// DriversURL 驱动调用地址
+func (e Endpoint) DriversURL() string {
+	return strings.TrimRight(e.BaseURL, "/") + "/drivers/call"
+}
+
+// ModelsEndpoint 模型列表地址
+func (e Endpoint) ModelsEndpoint() string {
+	if e.ModelsURL != "" {
+		return e.ModelsURL
+	}
+	return strings.TrimRight(e.BaseURL, "/") + "/puterai/chat/models"
+}
+
+// ImageModelsEndpoint 图片模型列表地址
+func (e Endpoint) ImageModelsEndpoint() string {
+	if e.ImageModelsURL != "" {
+		return e.ImageModelsURL
+	}
+	return strings.TrimRight(e.BaseURL, "/") + "/puterai/image/models"
+}
+
+// VideoModelsEndpoint 视频模型列表地址
+func (e Endpoint) VideoModelsEndpoint() string {
+	if e.VideoModelsURL != "" {
+		return e.VideoModelsURL
+	}
+	return strings.TrimRight(e.BaseURL, "/") + "/puterai/video/models"
+}
+
+// WithBaseURL 用 Token 级别的地址覆盖默认地址，baseURL 为空时原样返回
+// 覆盖后各模型列表地址随之从新地址推导
+func (e Endpoint) WithBaseURL(baseURL string) Endpoint {
+	if baseURL == "" {
+		return e
+	}
+	return Endpoint{BaseURL: baseURL}
+}
+
+// Credential 调用上游使用的 Token 及其连接设置
+type Credential struct {
+	Token   string
+	BaseURL string // Token 级别的上游地址，为空时使用客户端默认地址
+	Proxy   string // Token 级别的出站代理（http/https/socks5/socks5h），为空时直连
+	Profile string // Token 指定的请求头指纹名称，为空时按配置分配
+}
@@ -0,0 +1,198 @@
+package puter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 归一化后的错误码
+const (
+	CodeAuthFailed        = "auth_failed"        // Token 失效或无权限
+	CodeRateLimited       = "rate_limited"       // Token 被限流
+	CodeInsufficientFunds = "insufficient_funds" // Token 余额不足
+	CodeOverloaded        = "overloaded"         // 上游过载或暂不可用
+	CodeModelNotFound     = "model_not_found"    // 模型不存在或已下线
+	CodeInvalidRequest    = "invalid_request"    // 请求参数有误
+	CodeUpstream          = "upstream_error"     // 其他上游错误
+)
+
+// Error Puter 上游错误，来自 HTTP 错误响应或 NDJSON 流中的错误块
+type Error struct {
+	Code         string // 归一化错误码，见 Code* 常量
+	UpstreamCode string // Puter 返回的原始错误码
+	Message      string // 错误描述
+	StatusCode   int    // HTTP 状态码；流内错误为推断值
+	Retryable    bool   // 换 Token 或稍后重试可能成功
+	TokenFault   bool   // 由 Token 本身引起（失效、限流、余额不足）
+	Body         string // 原始响应内容
+}
+
+// Error 实现 error 接口
+func (e *Error) Error() string {
+	return fmt.Sprintf("puter API error: status=%d, code=%s, message=%s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsCancelled 判断错误是否由客户端取消请求引起
+func IsCancelled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// AsError 从错误链中取出 *Error
+func AsError(err error) (*Error, bool) {
+	var puterErr *Error
+	if errors.As(err, &puterErr) {
+		return puterErr, true
+	}
+	return nil, false
+}
+
+// errorPayload Puter 错误响应的常见形态
+//
+//	{"success": false, "error": {"code": "...", "message": "...", "status": 402}}
+//	{"type": "error", "code": "...", "message": "..."}
+//	{"error": "..."}
+type errorPayload struct {
+	Type    string          `json:"type"`
+	Success *bool           `json:"success"`
+	Error   json.RawMessage `json:"error"`
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Status  int             `json:"status"`
+}
+
+// errorDetail error 字段为对象时的内容
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+// newHTTPError 根据非 200 响应构造错误
+func newHTTPError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode, Body: string(body)}
+	var p errorPayload
+	if err := json.Unmarshal(body, &p); err == nil {
+		e.fillFrom(p)
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	if e.Message == "" {
+		e.Message = fmt.Sprintf("upstream returned status %d", statusCode)
+	}
+	e.classify()
+	return e
+}
+
+// parseStreamError 判断 NDJSON 行是否为错误块
+func parseStreamError(line []byte) (*Error, bool) {
+	var p errorPayload
+	if err := json.Unmarshal(line, &p); err != nil {
+		return nil, false
+	}
+	hasError := len(p.Error) > 0 && string(p.Error) != "null"
+	if p.Type != "error" && !hasError && (p.Success == nil || *p.Success) {
+		return nil, false
+	}
+
+	e := &Error{Body: string(line)}
+	e.fillFrom(p)
+	if e.Message == "" {
+		e.Message = "upstream stream error"
+	}
+	e.classify()
+	return e, true
+}
+
+// fillFrom 从错误响应中提取错误码、描述和状态码
+func (e *Error) fillFrom(p errorPayload) {
+	e.UpstreamCode = p.Code
+	e.Message = p.Message
+	if p.Status != 0 && e.StatusCode == 0 {
+		e.StatusCode = p.Status
+	}
+
+	if len(p.Error) == 0 {
+		return
+	}
+	var msg string
+	if err := json.Unmarshal(p.Error, &msg); err == nil {
+		if e.Message == "" {
+			e.Message = msg
+		}
+		return
+	}
+	var d errorDetail
+	if err := json.Unmarshal(p.Error, &d); err == nil {
+		if d.Code != "" {
+			e.UpstreamCode = d.Code
+		}
+		if d.Message != "" {
+			e.Message = d.Message
+		}
+		if d.Status != 0 && e.StatusCode == 0 {
+			e.StatusCode = d.Status
+		}
+	}
+}
+
+// classify 根据状态码和上游错误码确定归一化错误码及重试属性
+func (e *Error) classify() {
+	code := strings.ToLower(e.UpstreamCode)
+	msg := strings.ToLower(e.Message)
+
+	switch {
+	case code == "insufficient_funds" || e.StatusCode == 402 || strings.Contains(msg, "insufficient fund"):
+		e.Code = CodeInsufficientFunds
+	case e.StatusCode == 401 || e.StatusCode == 403 ||
+		strings.Contains(code, "auth") || strings.Contains(code, "forbidden") || strings.Contains(code, "permission"):
+		e.Code = CodeAuthFailed
+	case e.StatusCode == 429 || strings.Contains(code, "rate_limit") || strings.Contains(code, "too_many"):
+		e.Code = CodeRateLimited
+	case e.StatusCode == 404 || strings.Contains(code, "model_not_found") || strings.Contains(code, "no_such_model") ||
+		(strings.Contains(msg, "model") && (strings.Contains(msg, "not found") || strings.Contains(msg, "not available"))):
+		e.Code = CodeModelNotFound
+	case e.StatusCode >= 500 || strings.Contains(code, "overload") || strings.Contains(code, "unavailable") ||
+		strings.Contains(msg, "overloaded"):
+		e.Code = CodeOverloaded
+	case e.StatusCode >= 400:
+		e.Code = CodeInvalidRequest
+	default:
+		e.Code = CodeUpstream
+	}
+
+	switch e.Code {
+	case CodeAuthFailed, CodeRateLimited, CodeInsufficientFunds:
+		e.TokenFault = true
+		e.Retryable = true
+	case CodeOverloaded, CodeUpstream:
+		e.Retryable = true
+	}
+
+	if e.StatusCode == 0 {
+		e.StatusCode = defaultStatus(e.Code)
+	}
+}
+
+// defaultStatus 流内错误没有 HTTP 状态码时按错误码推断
+func defaultStatus(code string) int {
+	switch code {
+	case CodeAuthFailed:
+		return 401
+	case CodeRateLimited:
+		return 429
+	case CodeInsufficientFunds:
+		return 402
+	case CodeModelNotFound:
+		return 404
+	case CodeInvalidRequest:
+		return 400
+	case CodeOverloaded:
+		return 503
+	default:
+		return 502
+	}
+}
@@ -0,0 +1,80 @@
+package puter
+
+import "testing"
+
+func TestNewHTTPError_InsufficientFunds(t *testing.T) {
+	body := []byte(`{"success":false,"error":{"code":"insufficient_funds","message":"Available funding is insufficient for this request.","status":402}}`)
+
+	e := newHTTPError(400, body)
+
+	if e.Code != CodeInsufficientFunds {
+		t.Errorf("expected code %s, got %s", CodeInsufficientFunds, e.Code)
+	}
+	if !e.TokenFault || !e.Retryable {
+		t.Errorf("expected token fault and retryable")
+	}
+	if e.Message != "Available funding is insufficient for this request." {
+		t.Errorf("unexpected message %q", e.Message)
+	}
+	if e.StatusCode != 400 {
+		t.Errorf("expected HTTP status to be kept, got %d", e.StatusCode)
+	}
+}
+
+func TestNewHTTPError_StatusCodes(t *testing.T) {
+	tests := []struct {
+		status     int
+		code       string
+		retryable  bool
+		tokenFault bool
+	}{
+		{401, CodeAuthFailed, true, true},
+		{429, CodeRateLimited, true, true},
+		{503, CodeOverloaded, true, false},
+		{404, CodeModelNotFound, false, false},
+		{400, CodeInvalidRequest, false, false},
+	}
+
+	for _, tt := range tests {
+		e := newHTTPError(tt.status, []byte("plain text error"))
+		if e.Code != tt.code || e.Retryable != tt.retryable || e.TokenFault != tt.tokenFault {
+			t.Errorf("status %d: got code=%s retryable=%v tokenFault=%v", tt.status, e.Code, e.Retryable, e.TokenFault)
+		}
+		if e.Message != "plain text error" {
+			t.Errorf("status %d: expected raw body as message, got %q", tt.status, e.Message)
+		}
+	}
+}
+
+func TestParseStreamError(t *testing.T) {
+	tests := []struct {
+		line string
+		code string
+	}{
+		{`{"type":"error","code":"rate_limited","message":"slow down"}`, CodeRateLimited},
+		{`{"success":false,"error":{"code":"model_not_found","message":"no such model"}}`, CodeModelNotFound},
+		{`{"error":"The model is overloaded"}`, CodeOverloaded},
+	}
+
+	for _, tt := range tests {
+		e, ok := parseStreamError([]byte(tt.line))
+		if !ok {
+			t.Fatalf("expected %s to be an error chunk", tt.line)
+		}
+		if e.Code != tt.code {
+			t.Errorf("%s: expected code %s, got %s", tt.line, tt.code, e.Code)
+		}
+		if e.StatusCode == 0 {
+			t.Errorf("%s: expected inferred status code", tt.line)
+		}
+	}
+}
+
+func TestParseStreamError_TextChunk(t *testing.T) {
+	if _, ok := parseStreamError([]byte(`{"type":"text","text":"hello"}`)); ok {
+		t.Errorf("text chunk should not be treated as error")
+	}
+	if _, ok := parseStreamError([]byte(`{"success":true,"result":{}}`)); ok {
+		t.Errorf("successful response should not be treated as error")
+	}
+}
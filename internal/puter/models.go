@@ -0,0 +1,121 @@
+package puter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// 模型列表的种类
+const (
+	ModelKindChat  = "chat"
+	ModelKindImage = "image"
+	ModelKindVideo = "video"
+)
+
+// modelsURL 返回指定种类的模型列表地址
+func (e Endpoint) modelsURL(kind string) string {
+	switch kind {
+	case ModelKindImage:
+		return e.ImageModelsEndpoint()
+	case ModelKindVideo:
+		return e.VideoModelsEndpoint()
+	default:
+		return e.ModelsEndpoint()
+	}
+}
+
+// ModelInfo 上游模型及其元数据，上游未提供的限制为 0
+type ModelInfo struct {
+	ID              string
+	ContextWindow   int // 上下文窗口（token）
+	MaxOutputTokens int // 单次最多输出的 token 数
+}
+
+// ListModels 获取上游的模型列表
+func (c *Client) ListModels(ctx context.Context, kind string, cred Credential) ([]ModelInfo, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.Endpoint(cred).modelsURL(kind), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(httpReq, cred)
+	httpReq.Header.Set("Authorization", "Bearer "+cred.Token)
+
+	resp, err := c.do(httpReq, cred)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp.StatusCode, body)
+	}
+	models, err := parseModelList(body)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s model list: %w", kind, err)
+	}
+	return models, nil
+}
+
+// parseModelList 解析模型列表，兼容以下格式：
+//
+//	{"models": ["gpt-4o", ...]}
+//	{"models": [{"id": "gpt-4o", "context": 128000, "max_tokens": 16384}, ...]} 或 {"data": [...]}
+//	["gpt-4o", ...]
+func parseModelList(body []byte) ([]ModelInfo, error) {
+	var wrapped struct {
+		Models []json.RawMessage `json:"models"`
+		Data   []json.RawMessage `json:"data"`
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		if err := json.Unmarshal(body, &wrapped); err != nil {
+			return nil, err
+		}
+		items = wrapped.Models
+		if items == nil {
+			items = wrapped.Data
+		}
+	}
+
+	models := make([]ModelInfo, 0, len(items))
+	for _, item := range items {
+		var m ModelInfo
+		if err := json.Unmarshal(item, &m.ID); err != nil {
+			var obj struct {
+				ID              string `json:"id"`
+				Model           string `json:"model"`
+				Name            string `json:"name"`
+				Context         int    `json:"context"`
+				ContextWindow   int    `json:"context_window"`
+				ContextLength   int    `json:"context_length"`
+				MaxTokens       int    `json:"max_tokens"`
+				MaxOutputTokens int    `json:"max_output_tokens"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return nil, err
+			}
+			m = ModelInfo{
+				ID:              firstNonEmpty(obj.ID, obj.Model, obj.Name),
+				ContextWindow:   max(obj.Context, obj.ContextWindow, obj.ContextLength),
+				MaxOutputTokens: max(obj.MaxTokens, obj.MaxOutputTokens),
+			}
+		}
+		if m.ID != "" {
+			models = append(models, m)
+		}
+	}
+	return models, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package puter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseModelList(t *testing.T) {
+	tests := map[string][]string{
+		`{"models":["gpt-4o","claude-sonnet-4-5"]}`:       {"gpt-4o", "claude-sonnet-4-5"},
+		`{"models":[{"id":"gpt-4o"},{"name":"flux"}]}`:    {"gpt-4o", "flux"},
+		`{"data":[{"id":"sora-2"}]}`:                      {"sora-2"},
+		`["gemini-2.5-pro",{"model":"grok-4"},{"id":""}]`: {"gemini-2.5-pro", "grok-4"},
+	}
+	for body, want := range tests {
+		models, err := parseModelList([]byte(body))
+		if err != nil {
+			t.Errorf("parseModelList(%s) unexpected error: %v", body, err)
+			continue
+		}
+		got := make([]string, len(models))
+		for i, m := range models {
+			got[i] = m.ID
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseModelList(%s) = %v, want %v", body, got, want)
+		}
+	}
+	if _, err := parseModelList([]byte(`not json`)); err == nil {
+		t.Error("expected error for invalid body")
+	}
+}
+
+func TestParseModelList_Limits(t *testing.T) {
+	body := `{"models":[{"id":"claude-sonnet-4-5","context":200000,"max_tokens":64000},{"id":"gemini-2.5-pro","context_length":1048576},"gpt-4o"]}`
+	got, err := parseModelList([]byte(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []ModelInfo{
+		{ID: "claude-sonnet-4-5", ContextWindow: 200000, MaxOutputTokens: 64000},
+		{ID: "gemini-2.5-pro", ContextWindow: 1048576},
+		{ID: "gpt-4o"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseModelList = %+v, want %+v", got, want)
+	}
+}
+
+func TestListModels_UsesModelsEndpoint(t *testing.T) {
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		w.Write([]byte(`{"models":["gpt-4o"]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(Endpoint{BaseURL: srv.URL}, nil)
+	models, err := c.ListModels(context.Background(), ModelKindVideo, Credential{Token: "tok"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(models) != 1 || gotPath != "/puterai/video/models" || gotAuth != "Bearer tok" {
+		t.Errorf("models=%v path=%s auth=%s", models, gotPath, gotAuth)
+	}
+}
@@ -0,0 +1,151 @@
+package puter
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+)
+
+// HeaderProfile 一组浏览器请求头指纹，sec-ch-ua 系列为空时不发送（如 Firefox、Safari）
+type HeaderProfile struct {
+	Name                   string `json:"name"`
+	UserAgent              string `json:"user_agent"`
+	AcceptLanguage         string `json:"accept_language"`
+	Origin                 string `json:"origin"`
+	Referer                string `json:"referer"`
+	SecChUA                string `json:"sec_ch_ua"`
+	SecChUAMobile          string `json:"sec_ch_ua_mobile"`
+	SecChUAPlatform        string `json:"sec_ch_ua_platform"`
+	SecChUAPlatformVersion string `json:"sec_ch_ua_platform_version"`
+	SecChUAFullVersionList string `json:"sec_ch_ua_full_version_list"`
+	SecChUAArch            string `json:"sec_ch_ua_arch"`
+	SecChUABitness         string `json:"sec_ch_ua_bitness"`
+	SecChUAModel           string `json:"sec_ch_ua_model"`
+}
+
+// DefaultProfile 未配置时使用的 macOS Chrome 142 指纹
+var DefaultProfile = HeaderProfile{
+	Name:                   "chrome-macos",
+	UserAgent:              "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36",
+	AcceptLanguage:         "zh-CN,zh;q=0.9,en;q=0.8",
+	Origin:                 "https://docs.puter.com",
+	Referer:                "https://docs.puter.com/",
+	SecChUA:                `"Chromium";v="142", "Google Chrome";v="142", "Not_A Brand";v="99"`,
+	SecChUAMobile:          "?0",
+	SecChUAPlatform:        `"macOS"`,
+	SecChUAPlatformVersion: `"15.0.0"`,
+	SecChUAFullVersionList: `"Chromium";v="142.0.7355.4", "Google Chrome";v="142.0.7355.4", "Not_A Brand";v="99.0.0.0"`,
+	SecChUAArch:            `"arm"`,
+	SecChUABitness:         `"64"`,
+	SecChUAModel:           `""`,
+}
+
+// apply 写入指纹相关请求头
+func (p HeaderProfile) apply(h http.Header) {
+	h.Set("User-Agent", p.UserAgent)
+	setIfNotEmpty(h, "Accept-Language", p.AcceptLanguage)
+	setIfNotEmpty(h, "Origin", p.Origin)
+	setIfNotEmpty(h, "Referer", p.Referer)
+	setIfNotEmpty(h, "sec-ch-ua", p.SecChUA)
+	setIfNotEmpty(h, "sec-ch-ua-mobile", p.SecChUAMobile)
+	setIfNotEmpty(h, "sec-ch-ua-platform", p.SecChUAPlatform)
+	setIfNotEmpty(h, "sec-ch-ua-platform-version", p.SecChUAPlatformVersion)
+	setIfNotEmpty(h, "sec-ch-ua-full-version-list", p.SecChUAFullVersionList)
+	setIfNotEmpty(h, "sec-ch-ua-arch", p.SecChUAArch)
+	setIfNotEmpty(h, "sec-ch-ua-bitness", p.SecChUABitness)
+	setIfNotEmpty(h, "sec-ch-ua-model", p.SecChUAModel)
+}
+
+func setIfNotEmpty(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
+
+// Profiles 可用的请求头指纹集合
+type Profiles struct {
+	list   []HeaderProfile
+	byName map[string]int
+	rotate bool // 未指定指纹的 Token 按 Token 固定分配到不同指纹，否则统一使用第一个
+}
+
+// NewProfiles 创建指纹集合，list 为空时只包含 DefaultProfile
+func NewProfiles(list []HeaderProfile, rotate bool) (*Profiles, error) {
+	if len(list) == 0 {
+		list = []HeaderProfile{DefaultProfile}
+	}
+	p := &Profiles{list: list, byName: make(map[string]int, len(list)), rotate: rotate}
+	for i, profile := range list {
+		if profile.Name == "" || profile.UserAgent == "" {
+			return nil, fmt.Errorf("header profile #%d: name and user_agent are required", i+1)
+		}
+		if _, ok := p.byName[profile.Name]; ok {
+			return nil, fmt.Errorf("duplicate header profile %q", profile.Name)
+		}
+		p.byName[profile.Name] = i
+	}
+	return p, nil
+}
+
+// LoadProfiles 从 JSON 文件加载指纹配置
+//
+//	{"rotate": true, "profiles": [{"name": "chrome-win", "user_agent": "...", ...}]}
+func LoadProfiles(path string) (*Profiles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Rotate   bool            `json:"rotate"`
+		Profiles []HeaderProfile `json:"profiles"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return NewProfiles(file.Profiles, file.Rotate)
+}
+
+// ProfilesFromEnv 从 PUTER_HEADER_PROFILES 指定的文件加载指纹，未设置时使用默认指纹
+func ProfilesFromEnv() (*Profiles, error) {
+	path := os.Getenv("PUTER_HEADER_PROFILES")
+	if path == "" {
+		return NewProfiles(nil, false)
+	}
+	return LoadProfiles(path)
+}
+
+// Names 返回所有指纹名称
+func (p *Profiles) Names() []string {
+	names := make([]string, len(p.list))
+	for i, profile := range p.list {
+		names[i] = profile.Name
+	}
+	return names
+}
+
+// Has 是否存在指定名称的指纹
+func (p *Profiles) Has(name string) bool {
+	_, ok := p.byName[name]
+	return ok
+}
+
+// Rotate 是否为未指定指纹的 Token 轮换分配
+func (p *Profiles) Rotate() bool {
+	return p.rotate
+}
+
+// For 返回凭据使用的指纹：优先使用 Token 指定的指纹；开启轮换时按 Token 哈希固定分配，
+// 同一 Token 始终使用同一指纹，避免同一账号的请求头来回变化
+func (p *Profiles) For(cred Credential) HeaderProfile {
+	if i, ok := p.byName[cred.Profile]; ok {
+		return p.list[i]
+	}
+	if !p.rotate || cred.Token == "" {
+		return p.list[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(cred.Token))
+	return p.list[h.Sum32()%uint32(len(p.list))]
+}
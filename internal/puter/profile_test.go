@@ -0,0 +1,92 @@
+package puter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles_For(t *testing.T) {
+	win := HeaderProfile{Name: "chrome-win", UserAgent: "win"}
+	ff := HeaderProfile{Name: "firefox", UserAgent: "ff"}
+
+	fixed, err := NewProfiles([]HeaderProfile{win, ff}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := fixed.For(Credential{Token: "a"}).Name; got != "chrome-win" {
+		t.Errorf("without rotation expected first profile, got %s", got)
+	}
+	if got := fixed.For(Credential{Token: "a", Profile: "firefox"}).Name; got != "firefox" {
+		t.Errorf("assigned profile not used, got %s", got)
+	}
+
+	rotating, _ := NewProfiles([]HeaderProfile{win, ff}, true)
+	seen := map[string]bool{}
+	for _, tok := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		first := rotating.For(Credential{Token: tok}).Name
+		if again := rotating.For(Credential{Token: tok}).Name; again != first {
+			t.Errorf("token %s switched profile: %s -> %s", tok, first, again)
+		}
+		seen[first] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("rotation should spread tokens across profiles, got %v", seen)
+	}
+}
+
+func TestNewProfiles_Validation(t *testing.T) {
+	if _, err := NewProfiles([]HeaderProfile{{Name: "x"}}, false); err == nil {
+		t.Error("expected error for missing user_agent")
+	}
+	dup := HeaderProfile{Name: "x", UserAgent: "ua"}
+	if _, err := NewProfiles([]HeaderProfile{dup, dup}, false); err == nil {
+		t.Error("expected error for duplicate name")
+	}
+	p, _ := NewProfiles(nil, false)
+	if names := p.Names(); len(names) != 1 || names[0] != DefaultProfile.Name {
+		t.Errorf("empty config should fall back to default, got %v", names)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(path, []byte(`{"rotate":true,"profiles":[{"name":"safari","user_agent":"Safari/17","accept_language":"en-US"}]}`), 0o644)
+
+	p, err := LoadProfiles(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !p.Rotate() || !p.Has("safari") {
+		t.Errorf("unexpected profiles: %v rotate=%v", p.Names(), p.Rotate())
+	}
+}
+
+func TestStreamChat_SendsProfileHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"type":"text","text":"ok"}` + "\n"))
+	}))
+	defer srv.Close()
+
+	safari := HeaderProfile{Name: "safari", UserAgent: "Safari/17", AcceptLanguage: "en-US", Origin: "https://puter.com"}
+	profiles, _ := NewProfiles([]HeaderProfile{DefaultProfile, safari}, false)
+	c := NewClient(Endpoint{BaseURL: srv.URL}, profiles)
+	stream, err := c.StreamChat(context.Background(), ChatRequest{Model: "claude-sonnet-4-5"}, Credential{Token: "tok", Profile: "safari"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stream.ReadAll()
+	stream.Close()
+
+	if got.Get("User-Agent") != "Safari/17" || got.Get("Accept-Language") != "en-US" || got.Get("Origin") != "https://puter.com" {
+		t.Errorf("profile headers not applied: %v", got)
+	}
+	if got.Get("sec-ch-ua") != "" {
+		t.Errorf("empty sec-ch-ua should not be sent, got %q", got.Get("sec-ch-ua"))
+	}
+}
@@ -0,0 +1,103 @@
+package puter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 上游请求的总超时（含流式读取）
+const requestTimeout = 5 * time.Minute
+
+// ErrInvalidProxy 代理地址格式不正确
+var ErrInvalidProxy = errors.New("proxy must be an http://, https://, socks5:// or socks5h:// URL")
+
+// ParseProxy 解析代理地址，支持 HTTP CONNECT（http/https）和 SOCKS5（socks5/socks5h）
+func ParseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, ErrInvalidProxy
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, ErrInvalidProxy
+}
+
+// transportCache 按代理地址缓存 http.Client，使用同一代理的 Token 共享连接池
+type transportCache struct {
+	mu      sync.Mutex
+	clients map[string]*http.Client // 键为代理地址，空字符串表示直连
+}
+
+func newTransportCache() *transportCache {
+	return &transportCache{clients: make(map[string]*http.Client)}
+}
+
+// get 返回指定代理对应的 http.Client，不存在时创建
+func (tc *transportCache) get(proxy string) (*http.Client, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if client, ok := tc.clients[proxy]; ok {
+		return client, nil
+	}
+	transport := &http.Transport{
+		DisableKeepAlives:   false,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if proxy != "" {
+		u, err := ParseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	client := &http.Client{Timeout: requestTimeout, Transport: transport}
+	tc.clients[proxy] = client
+	return client, nil
+}
+
+// httpClient 返回 Token 对应的 http.Client
+func (c *Client) httpClient(cred Credential) (*http.Client, error) {
+	return c.transports.get(cred.Proxy)
+}
+
+// do 通过 Token 的代理发送请求
+func (c *Client) do(req *http.Request, cred Credential) (*http.Response, error) {
+	client, err := c.httpClient(cred)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// TestProxy 通过代理访问上游地址，返回耗时；收到任意 HTTP 响应即视为代理可用
+func (c *Client) TestProxy(ctx context.Context, cred Credential) (time.Duration, error) {
+	client, err := c.httpClient(cred)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.Endpoint(cred).BaseURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	c.setHeaders(req, cred)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("proxy request failed: %w", err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	return time.Since(start), nil
+}
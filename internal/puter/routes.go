@@ -0,0 +1,197 @@
+package puter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Route 一条模型路由规则：Prefix 与 Contains 同时满足时命中，两者都为空时匹配任意模型
+type Route struct {
+	Name        string   `json:"name"`
+	Prefix      []string `json:"prefix,omitempty"`       // 模型 ID 前缀，任一匹配即可
+	Contains    []string `json:"contains,omitempty"`     // 模型 ID（小写）包含任一关键词即可
+	StripPrefix bool     `json:"strip_prefix,omitempty"` // 传给 Puter 时去掉命中的前缀
+	Interface   string   `json:"interface"`
+	Driver      string   `json:"driver"`
+	Method      string   `json:"method"`
+	Provider    string   `json:"provider"` // /v1/models 中的 owned_by
+}
+
+// match 判断模型是否命中规则，返回传给 Puter 的模型名
+func (r Route) match(modelID string) (string, bool) {
+	model := modelID
+	if len(r.Prefix) > 0 {
+		matched := false
+		for _, p := range r.Prefix {
+			if strings.HasPrefix(modelID, p) {
+				matched = true
+				if r.StripPrefix {
+					model = strings.TrimPrefix(modelID, p)
+				}
+				break
+			}
+		}
+		if !matched {
+			return "", false
+		}
+	}
+	if len(r.Contains) > 0 {
+		lower := strings.ToLower(model)
+		matched := false
+		for _, kw := range r.Contains {
+			if strings.Contains(lower, strings.ToLower(kw)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "", false
+		}
+	}
+	return model, true
+}
+
+// RouteTable 模型路由表，按顺序匹配，第一条命中的规则生效
+type RouteTable struct {
+	Routes []Route `json:"routes"`
+	Source string  `json:"source"` // 来源文件，内置路由表为空
+}
+
+// Validate 校验每条规则都指定了 interface/driver/method
+func (t *RouteTable) Validate() error {
+	if len(t.Routes) == 0 {
+		return fmt.Errorf("routing table is empty")
+	}
+	for i, r := range t.Routes {
+		if r.Interface == "" || r.Driver == "" || r.Method == "" {
+			return fmt.Errorf("route #%d (%s): interface, driver and method are required", i+1, r.Name)
+		}
+	}
+	return nil
+}
+
+// Resolve 返回模型命中的规则及传给 Puter 的模型名，均未命中时使用 fallbackRoute
+func (t *RouteTable) Resolve(modelID string) (Route, string) {
+	for _, r := range t.Routes {
+		if model, ok := r.match(modelID); ok {
+			return r, model
+		}
+	}
+	return fallbackRoute, modelID
+}
+
+// fallbackRoute 路由表中没有兜底规则时使用
+var fallbackRoute = Route{Name: "default", Interface: "puter-chat-completion", Driver: "openai-completion", Method: "complete", Provider: "other"}
+
+// 图片/视频生成模型的关键词
+var (
+	imageKeywords = []string{"flux", "stable-diffusion", "dall-e", "imagen", "hidream", "ideogram",
+		"seedream", "juggernaut", "dreamshaper", "flash-image", "gemini-3-pro-image"}
+	videoKeywords = []string{"sora", "veo", "kling", "seedance", "wan2", "hailuo", "vidu", "pixverse"}
+)
+
+// DefaultRoutes 内置路由表，未配置 PUTER_ROUTES 时使用
+var DefaultRoutes = RouteTable{Routes: []Route{
+	{Name: "openrouter", Prefix: []string{"openrouter:"}, StripPrefix: true, Interface: "puter-chat-completion", Driver: "openrouter", Method: "complete", Provider: "openrouter"},
+	{Name: "togetherai-image", Prefix: []string{"togetherai:"}, Contains: imageKeywords, StripPrefix: true, Interface: "puter-image-generation", Driver: "together-ai-image-generation", Method: "generate", Provider: "togetherai"},
+	{Name: "togetherai-video", Prefix: []string{"togetherai:"}, Contains: videoKeywords, StripPrefix: true, Interface: "puter-video-generation", Driver: "together", Method: "generate", Provider: "togetherai"},
+	{Name: "togetherai", Prefix: []string{"togetherai:"}, StripPrefix: true, Interface: "puter-chat-completion", Driver: "together-ai", Method: "complete", Provider: "togetherai"},
+	{Name: "claude", Prefix: []string{"claude-"}, Interface: "puter-chat-completion", Driver: "claude", Method: "complete", Provider: "anthropic"},
+	{Name: "openai", Prefix: []string{"gpt-", "o1", "o3", "o4"}, Interface: "puter-chat-completion", Driver: "openai-completion", Method: "complete", Provider: "openai"},
+	{Name: "gemini", Prefix: []string{"gemini-"}, Interface: "puter-chat-completion", Driver: "gemini", Method: "complete", Provider: "google"},
+	{Name: "xai", Prefix: []string{"grok-"}, Interface: "puter-chat-completion", Driver: "xai", Method: "complete", Provider: "xai"},
+	{Name: "deepseek", Prefix: []string{"deepseek-"}, Interface: "puter-chat-completion", Driver: "deepseek", Method: "complete", Provider: "deepseek"},
+	{Name: "mistral", Prefix: []string{"mistral-", "ministral-", "open-mistral-", "pixtral-", "codestral-", "devstral-", "magistral-"}, Interface: "puter-chat-completion", Driver: "mistral", Method: "complete", Provider: "mistral"},
+	fallbackRoute,
+}}
+
+// currentRoutes 当前生效的路由表，热加载时整体替换
+var currentRoutes atomic.Pointer[RouteTable]
+
+func init() {
+	currentRoutes.Store(&DefaultRoutes)
+}
+
+// Routes 返回当前生效的路由表
+func Routes() *RouteTable {
+	return currentRoutes.Load()
+}
+
+// SetRoutes 替换当前路由表
+func SetRoutes(t *RouteTable) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	currentRoutes.Store(t)
+	return nil
+}
+
+// LoadRoutes 从 JSON 文件加载路由表
+//
+//	{"routes": [{"name": "claude", "prefix": ["claude-"], "interface": "puter-chat-completion",
+//	             "driver": "claude", "method": "complete", "provider": "anthropic"}]}
+func LoadRoutes(path string) (*RouteTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t RouteTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	t.Source = path
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// RoutesFromEnv 从 PUTER_ROUTES 指定的文件加载路由表并设为当前路由表，返回文件路径；
+// 未设置时使用内置路由表，返回空字符串
+func RoutesFromEnv() (string, error) {
+	path := os.Getenv("PUTER_ROUTES")
+	if path == "" {
+		return "", nil
+	}
+	t, err := LoadRoutes(path)
+	if err != nil {
+		return "", err
+	}
+	currentRoutes.Store(t)
+	return path, nil
+}
+
+// WatchRoutes 定期检查路由文件，修改后重新加载；加载失败时保留当前路由表
+func WatchRoutes(ctx context.Context, path string, interval time.Duration) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+		t, err := LoadRoutes(path)
+		if err != nil {
+			log.Printf("[Puter] 路由表重新加载失败，继续使用旧路由表: %v", err)
+			continue
+		}
+		currentRoutes.Store(t)
+		log.Printf("[Puter] 路由表已重新加载, rules=%d", len(t.Routes))
+	}
+}
@@ -0,0 +1,81 @@
+package puter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolveDriver_DefaultRoutes(t *testing.T) {
+	tests := []struct {
+		model, iface, driver, puterModel, provider string
+	}{
+		{"claude-sonnet-4-5", "puter-chat-completion", "claude", "claude-sonnet-4-5", "anthropic"},
+		{"gpt-4o", "puter-chat-completion", "openai-completion", "gpt-4o", "openai"},
+		{"o3-mini", "puter-chat-completion", "openai-completion", "o3-mini", "openai"},
+		{"gemini-2.5-pro", "puter-chat-completion", "gemini", "gemini-2.5-pro", "google"},
+		{"grok-4", "puter-chat-completion", "xai", "grok-4", "xai"},
+		{"codestral-latest", "puter-chat-completion", "mistral", "codestral-latest", "mistral"},
+		{"openrouter:anthropic/claude-3.5", "puter-chat-completion", "openrouter", "anthropic/claude-3.5", "openrouter"},
+		{"togetherai:black-forest-labs/FLUX.1-schnell", "puter-image-generation", "together-ai-image-generation", "black-forest-labs/FLUX.1-schnell", "togetherai"},
+		{"togetherai:google/veo-3.0", "puter-video-generation", "together", "google/veo-3.0", "togetherai"},
+		{"togetherai:meta-llama/Llama-3-70b", "puter-chat-completion", "together-ai", "meta-llama/Llama-3-70b", "togetherai"},
+		{"some-unknown-model", "puter-chat-completion", "openai-completion", "some-unknown-model", "other"},
+	}
+	for _, tt := range tests {
+		info := ResolveDriver(tt.model)
+		if info.Interface != tt.iface || info.Driver != tt.driver || info.Model != tt.puterModel || info.Provider != tt.provider {
+			t.Errorf("ResolveDriver(%q) = %+v", tt.model, info)
+		}
+	}
+}
+
+func TestRouteTable_Validate(t *testing.T) {
+	if err := (&RouteTable{}).Validate(); err == nil {
+		t.Error("expected error for empty table")
+	}
+	bad := &RouteTable{Routes: []Route{{Name: "x", Prefix: []string{"x-"}, Driver: "x"}}}
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error for route without interface/method")
+	}
+}
+
+func TestWatchRoutes_HotReload(t *testing.T) {
+	defer SetRoutes(&DefaultRoutes)
+
+	path := filepath.Join(t.TempDir(), "routes.json")
+	write := func(driver string, mod time.Time) {
+		os.WriteFile(path, []byte(`{"routes":[{"name":"custom","prefix":["foo-"],"interface":"puter-chat-completion","driver":"`+driver+`","method":"complete","provider":"foo"}]}`), 0o644)
+		os.Chtimes(path, mod, mod)
+	}
+	start := time.Now().Add(-time.Minute)
+	write("first", start)
+	table, err := LoadRoutes(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	SetRoutes(table)
+	if got := ResolveDriver("foo-1").Driver; got != "first" {
+		t.Fatalf("driver = %s, want first", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchRoutes(ctx, path, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	write("second", start.Add(time.Second))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for ResolveDriver("foo-1").Driver != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("routing table was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 未命中任何规则时使用兜底路由
+	if got := ResolveDriver("bar").Driver; got != "openai-completion" {
+		t.Errorf("fallback driver = %s", got)
+	}
+}
@@ -0,0 +1,170 @@
+package puter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"puter2api/internal/types"
+)
+
+// 单行 NDJSON 的最大长度（大段代码输出可能超过 bufio 默认的 64KB）
+const maxLineSize = 10 * 1024 * 1024
+
+// Stream Puter 流式响应读取器，逐块返回上游 NDJSON 中的内容
+type Stream struct {
+	body      io.ReadCloser
+	scanner   *bufio.Scanner
+	startTime time.Time
+	textLen   int
+	done      bool
+
+	usage    types.Usage
+	hasUsage bool
+
+	peeked  bool // 是否有预读的块
+	peekRes types.PuterStreamChunk
+	peekErr error
+
+	tee     io.Writer    // 原始 NDJSON 行的副本输出，用于共享响应和响应缓存
+	pending bytes.Buffer // 设置 tee 之前 Peek 读取的原始行
+	peeking bool
+	replay  bool // 是否为回放（缓存或共享响应）
+}
+
+func newStream(body io.ReadCloser, startTime time.Time) *Stream {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &Stream{
+		body:      body,
+		scanner:   scanner,
+		startTime: startTime,
+	}
+}
+
+// NewReplayStream 用 Tee 输出的原始内容构造流，回放时与上游流的行为一致
+func NewReplayStream(body io.ReadCloser) *Stream {
+	s := newStream(body, time.Now())
+	s.replay = true
+	return s
+}
+
+// Tee 将之后读取的原始 NDJSON 行同时写入 w；Peek 已读取的行会先写入
+// 须在 Peek 之后、Recv 之前调用，保证 w 中是完整的响应
+func (s *Stream) Tee(w io.Writer) {
+	w.Write(s.pending.Bytes())
+	s.pending.Reset()
+	s.tee = w
+}
+
+// Peek 预读第一个块但不消费
+// 用于在向客户端写入任何内容之前暴露上游错误，以便换 Token 重试
+func (s *Stream) Peek() error {
+	if !s.peeked {
+		s.peeking = true
+		s.peekRes, s.peekErr = s.recv()
+		s.peeking = false
+		s.peeked = true
+	}
+	if s.peekErr == io.EOF {
+		return nil
+	}
+	return s.peekErr
+}
+
+// Recv 读取下一个文本块、推理块或原生工具调用块，流结束时返回 io.EOF
+func (s *Stream) Recv() (types.PuterStreamChunk, error) {
+	if s.peeked {
+		s.peeked = false
+		return s.peekRes, s.peekErr
+	}
+	return s.recv()
+}
+
+func (s *Stream) recv() (types.PuterStreamChunk, error) {
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			continue
+		}
+		switch {
+		case s.tee != nil:
+			io.WriteString(s.tee, line+"\n")
+		case s.peeking:
+			s.pending.WriteString(line + "\n")
+		}
+		// 流中的错误块：上游已返回 200，但生成过程中失败
+		if puterErr, ok := parseStreamError([]byte(line)); ok {
+			log.Printf("[Puter] 流内错误: code=%s, message=%s", puterErr.Code, puterErr.Message)
+			return types.PuterStreamChunk{}, puterErr
+		}
+		// 用量块
+		if u, ok := parseUsage([]byte(line)); ok {
+			s.usage = u
+			s.hasUsage = true
+		}
+		var chunk types.PuterStreamChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			continue
+		}
+		if chunk.IsToolUse() || chunk.IsReasoning() {
+			return chunk, nil
+		}
+		if chunk.Text == "" {
+			continue
+		}
+		s.textLen += len(chunk.Text)
+		return chunk, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		if IsCancelled(err) {
+			log.Printf("[Puter] 请求已取消 (client_cancelled), 已接收: %d 字符", s.textLen)
+		} else {
+			log.Printf("[Puter] 读取流失败: %v", err)
+		}
+		return types.PuterStreamChunk{}, err
+	}
+
+	if !s.done {
+		s.done = true
+		if s.replay {
+			log.Printf("[Puter] 回放完成, 响应: %d 字符", s.textLen)
+		} else {
+			log.Printf("[Puter] 请求完成, 耗时: %v, 响应: %d 字符", time.Since(s.startTime), s.textLen)
+		}
+	}
+	return types.PuterStreamChunk{}, io.EOF
+}
+
+// ReadAll 读取剩余的全部文本（忽略推理块和原生工具调用块）
+func (s *Stream) ReadAll() (string, error) {
+	var fullText strings.Builder
+	for {
+		chunk, err := s.Recv()
+		if err == io.EOF {
+			return fullText.String(), nil
+		}
+		if err != nil {
+			return fullText.String(), err
+		}
+		if chunk.IsToolUse() || chunk.IsReasoning() {
+			continue
+		}
+		fullText.WriteString(chunk.Text)
+	}
+}
+
+// Usage 返回上游报告的用量，上游未报告时第二个返回值为 false
+func (s *Stream) Usage() (types.Usage, bool) {
+	return s.usage, s.hasUsage
+}
+
+// Close 关闭底层响应体
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,74 @@
+package puter
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream_ToolUseChunk(t *testing.T) {
+	body := `{"type":"text","text":"Checking."}
+{"type":"tool_use","id":"toolu_1","name":"ls","input":{"path":"."}}
+`
+	s := newStream(io.NopCloser(strings.NewReader(body)), time.Now())
+
+	chunk, err := s.Recv()
+	if err != nil || chunk.Text != "Checking." {
+		t.Fatalf("unexpected text chunk: %+v, %v", chunk, err)
+	}
+	chunk, err = s.Recv()
+	if err != nil || !chunk.IsToolUse() || chunk.Name != "ls" || string(chunk.Input) != `{"path":"."}` {
+		t.Fatalf("unexpected tool chunk: %+v, %v", chunk, err)
+	}
+	if _, err := s.Recv(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestStream_ReasoningChunks(t *testing.T) {
+	body := `{"type":"reasoning","text":"step 1"}
+{"type":"text","reasoning":"step 2"}
+{"type":"text","text":"done"}
+`
+	s := newStream(io.NopCloser(strings.NewReader(body)), time.Now())
+
+	for _, want := range []string{"step 1", "step 2"} {
+		chunk, err := s.Recv()
+		if err != nil || !chunk.IsReasoning() || chunk.ReasoningText() != want {
+			t.Fatalf("expected reasoning %q, got %+v, %v", want, chunk, err)
+		}
+	}
+	text, err := s.ReadAll()
+	if err != nil || text != "done" {
+		t.Errorf("unexpected text: %q, %v", text, err)
+	}
+}
+
+func TestStream_TeeReplay(t *testing.T) {
+	body := `{"usage":{"input_tokens":12}}
+
+{"type":"text","text":"Hello"}
+{"type":"text","text":" world"}
+{"usage":{"input_tokens":12,"output_tokens":3}}
+`
+	s := newStream(io.NopCloser(strings.NewReader(body)), time.Now())
+	if err := s.Peek(); err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	// Peek 之后设置 Tee 仍能得到完整内容
+	var buf bytes.Buffer
+	s.Tee(&buf)
+	if text, err := s.ReadAll(); err != nil || text != "Hello world" {
+		t.Fatalf("ReadAll = %q, %v", text, err)
+	}
+
+	replay := NewReplayStream(io.NopCloser(&buf))
+	if text, err := replay.ReadAll(); err != nil || text != "Hello world" {
+		t.Errorf("replay = %q, %v", text, err)
+	}
+	if usage, ok := replay.Usage(); !ok || usage.InputTokens != 12 || usage.OutputTokens != 3 {
+		t.Errorf("replay usage = %+v, %v", usage, ok)
+	}
+}
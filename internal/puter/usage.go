@@ -0,0 +1,76 @@
+package puter
+
+import (
+	"encoding/json"
+
+	"puter2api/internal/types"
+)
+
+// usagePayload 可能携带用量信息的流块
+//
+//	{"type": "usage", "usage": {"input_tokens": 12, "output_tokens": 34}}
+//	{"usage": {"prompt_tokens": 12, "completion_tokens": 34}}
+//	{"metadata": {"usage": ...}}
+//	{"usage": [{"type": "prompt", "amount": 12}, {"type": "completion", "amount": 34}]}
+type usagePayload struct {
+	Usage    json.RawMessage `json:"usage"`
+	Metadata *struct {
+		Usage json.RawMessage `json:"usage"`
+	} `json:"metadata"`
+}
+
+// usageObject 对象形式的用量
+type usageObject struct {
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// usageItem Puter 计费明细形式的用量
+type usageItem struct {
+	Type   string `json:"type"`
+	Amount int    `json:"amount"`
+}
+
+// parseUsage 从 NDJSON 行中提取用量，没有用量时返回 false
+func parseUsage(line []byte) (types.Usage, bool) {
+	var p usagePayload
+	if err := json.Unmarshal(line, &p); err != nil {
+		return types.Usage{}, false
+	}
+	raw := p.Usage
+	if len(raw) == 0 && p.Metadata != nil {
+		raw = p.Metadata.Usage
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return types.Usage{}, false
+	}
+
+	var obj usageObject
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		u := types.Usage{InputTokens: obj.InputTokens, OutputTokens: obj.OutputTokens}
+		if u.InputTokens == 0 {
+			u.InputTokens = obj.PromptTokens
+		}
+		if u.OutputTokens == 0 {
+			u.OutputTokens = obj.CompletionTokens
+		}
+		return u, u.InputTokens > 0 || u.OutputTokens > 0
+	}
+
+	var items []usageItem
+	if err := json.Unmarshal(raw, &items); err == nil {
+		var u types.Usage
+		for _, item := range items {
+			switch item.Type {
+			case "prompt", "input":
+				u.InputTokens += item.Amount
+			case "completion", "output":
+				u.OutputTokens += item.Amount
+			}
+		}
+		return u, u.InputTokens > 0 || u.OutputTokens > 0
+	}
+	return types.Usage{}, false
+}
@@ -0,0 +1,35 @@
+package puter
+
+import "testing"
+
+func TestParseUsage(t *testing.T) {
+	tests := []struct {
+		line   string
+		input  int
+		output int
+	}{
+		{`{"type":"usage","usage":{"input_tokens":12,"output_tokens":34}}`, 12, 34},
+		{`{"usage":{"prompt_tokens":5,"completion_tokens":7}}`, 5, 7},
+		{`{"metadata":{"usage":{"input_tokens":1,"output_tokens":2}}}`, 1, 2},
+		{`{"usage":[{"type":"prompt","amount":100},{"type":"completion","amount":20}]}`, 100, 20},
+	}
+
+	for _, tt := range tests {
+		u, ok := parseUsage([]byte(tt.line))
+		if !ok {
+			t.Fatalf("expected usage in %s", tt.line)
+		}
+		if u.InputTokens != tt.input || u.OutputTokens != tt.output {
+			t.Errorf("%s: got %+v", tt.line, u)
+		}
+	}
+}
+
+func TestParseUsage_NoUsage(t *testing.T) {
+	if _, ok := parseUsage([]byte(`{"type":"text","text":"hi"}`)); ok {
+		t.Errorf("text chunk should not carry usage")
+	}
+	if _, ok := parseUsage([]byte(`{"usage":{}}`)); ok {
+		t.Errorf("empty usage should be ignored")
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// ModelAlias 对外模型 ID 到实际 Puter 模型的映射
+type ModelAlias struct {
+	Alias     string    `json:"alias"`
+	Target    string    `json:"target"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetAllAliases 获取所有模型别名
+func (s *Storage) GetAllAliases() ([]ModelAlias, error) {
+	rows, err := s.db.Query(`SELECT alias, target, created_at FROM model_aliases ORDER BY alias`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model aliases: %w", err)
+	}
+	defer rows.Close()
+
+	var aliases []ModelAlias
+	for rows.Next() {
+		var a ModelAlias
+		if err := rows.Scan(&a.Alias, &a.Target, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// SetAlias 添加或更新模型别名
+func (s *Storage) SetAlias(alias, target string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO model_aliases (alias, target, created_at) VALUES (?, ?, ?)
+		 ON CONFLICT(alias) DO UPDATE SET target = excluded.target`,
+		alias, target, time.Now(),
+	)
+	return err
+}
+
+// DeleteAlias 删除模型别名
+func (s *Storage) DeleteAlias(alias string) error {
+	_, err := s.db.Exec(`DELETE FROM model_aliases WHERE alias = ?`, alias)
+	return err
+}
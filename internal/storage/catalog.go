@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// CatalogModel 从上游同步的模型
+type CatalogModel struct {
+	ID              string `json:"id"`
+	Kind            string `json:"kind"`                        // chat / image / video
+	ContextWindow   int    `json:"context_window,omitempty"`    // 上游未提供时为 0
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"` // 上游未提供时为 0
+}
+
+// GetModelCatalog 读取缓存的模型目录及同步时间，从未同步时返回空列表和零值时间
+func (s *Storage) GetModelCatalog() ([]CatalogModel, time.Time, error) {
+	rows, err := s.db.Query(`SELECT id, kind, context_window, max_output_tokens, fetched_at FROM model_catalog ORDER BY rowid`)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to get model catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var models []CatalogModel
+	var fetchedAt time.Time
+	for rows.Next() {
+		var m CatalogModel
+		var t time.Time
+		if err := rows.Scan(&m.ID, &m.Kind, &m.ContextWindow, &m.MaxOutputTokens, &t); err != nil {
+			return nil, time.Time{}, err
+		}
+		if t.After(fetchedAt) {
+			fetchedAt = t
+		}
+		models = append(models, m)
+	}
+	return models, fetchedAt, rows.Err()
+}
+
+// SaveModelCatalog 用新同步的模型目录整体替换缓存
+func (s *Storage) SaveModelCatalog(models []CatalogModel, fetchedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM model_catalog`); err != nil {
+		return fmt.Errorf("failed to clear model catalog: %w", err)
+	}
+	for _, m := range models {
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO model_catalog (id, kind, context_window, max_output_tokens, fetched_at) VALUES (?, ?, ?, ?, ?)`,
+			m.ID, m.Kind, m.ContextWindow, m.MaxOutputTokens, fetchedAt,
+		); err != nil {
+			return fmt.Errorf("failed to save model catalog: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetCachedResponse 读取未过期的缓存响应
+func (s *Storage) GetCachedResponse(key string) ([]byte, bool, error) {
+	var body []byte
+	err := s.db.QueryRow(
+		`SELECT body FROM response_cache WHERE key = ? AND expires_at > ?`,
+		key, time.Now(),
+	).Scan(&body)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get cached response: %w", err)
+	}
+	return body, true, nil
+}
+
+// PutCachedResponse 写入缓存响应，已存在时覆盖
+func (s *Storage) PutCachedResponse(key string, body []byte, ttl time.Duration) error {
+	now := time.Now()
+	_, err := s.db.Exec(
+		`INSERT INTO response_cache (key, body, size, created_at, expires_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(key) DO UPDATE SET body = excluded.body, size = excluded.size,
+		 created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		key, body, len(body), now, now.Add(ttl),
+	)
+	return err
+}
+
+// TrimResponseCache 删除过期的缓存，并按写入时间从旧到新淘汰超出条数或总大小限制的缓存
+func (s *Storage) TrimResponseCache(maxEntries int, maxBytes int64) error {
+	if _, err := s.db.Exec(`DELETE FROM response_cache WHERE expires_at <= ?`, time.Now()); err != nil {
+		return fmt.Errorf("failed to delete expired responses: %w", err)
+	}
+	_, err := s.db.Exec(`
+		DELETE FROM response_cache WHERE key IN (
+			SELECT key FROM (
+				SELECT key,
+					ROW_NUMBER() OVER (ORDER BY created_at DESC) AS rn,
+					SUM(size) OVER (ORDER BY created_at DESC ROWS UNBOUNDED PRECEDING) AS total
+				FROM response_cache
+			) WHERE rn > ? OR total > ?
+		)`, maxEntries, maxBytes)
+	if err != nil {
+		return fmt.Errorf("failed to trim response cache: %w", err)
+	}
+	return nil
+}
+
+// ResponseCacheSize 返回缓存条数和总大小
+func (s *Storage) ResponseCacheSize() (int, int64, error) {
+	var entries int
+	var size int64
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM response_cache`).Scan(&entries, &size)
+	return entries, size, err
+}
+
+// ClearResponseCache 清空响应缓存
+func (s *Storage) ClearResponseCache() error {
+	_, err := s.db.Exec(`DELETE FROM response_cache`)
+	return err
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -10,14 +11,23 @@ import (
 
 // Token 表示存储的 Puter 认证 Token
 type Token struct {
-	ID        int64      `json:"id"`
-	Name      string     `json:"name"`      // 用户自定义名称
-	Token     string     `json:"token"`     // JWT Token
-	IsActive  bool       `json:"is_active"` // 是否启用
-	IsValid   bool       `json:"is_valid"`  // 是否有效（测试通过）
-	LastUsed  *time.Time `json:"last_used,omitempty"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`      // 用户自定义名称
+	Token         string     `json:"token"`     // JWT Token
+	IsActive      bool       `json:"is_active"` // 是否启用
+	IsValid       bool       `json:"is_valid"`  // 是否有效（测试通过）
+	LastUsed      *time.Time `json:"last_used,omitempty"`
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"` // 冷却截止时间（限流或余额不足）
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	TokenSettings
+}
+
+// TokenSettings Token 的上游连接设置，空值表示使用全局默认
+type TokenSettings struct {
+	BaseURL string `json:"base_url"` // 上游 API 地址
+	Proxy   string `json:"proxy"`    // 出站代理地址
+	Profile string `json:"profile"`  // 请求头指纹名称
 }
 
 // Storage 数据库存储接口
@@ -57,11 +67,85 @@ func (s *Storage) init() error {
 
 	CREATE INDEX IF NOT EXISTS idx_tokens_is_active ON tokens(is_active);
 	CREATE INDEX IF NOT EXISTS idx_tokens_is_valid ON tokens(is_valid);
+
+	CREATE TABLE IF NOT EXISTS model_aliases (
+		alias TEXT PRIMARY KEY,
+		target TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS response_cache (
+		key TEXT PRIMARY KEY,
+		body BLOB NOT NULL,
+		size INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_response_cache_created_at ON response_cache(created_at);
+
+	CREATE TABLE IF NOT EXISTS model_catalog (
+		id TEXT PRIMARY KEY,
+		kind TEXT NOT NULL DEFAULT 'chat',
+		fetched_at DATETIME NOT NULL
+	);
 	`
 	_, err := s.db.Exec(query)
 	if err != nil {
 		return fmt.Errorf("failed to create tables: %w", err)
 	}
+
+	// 旧数据库补充新增列
+	if err := s.addColumnIfMissing("tokens", "cooldown_until", "DATETIME"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("tokens", "base_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("tokens", "proxy", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("tokens", "header_profile", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("model_catalog", "context_window", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("model_catalog", "max_output_tokens", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// addColumnIfMissing 为已存在的表补充缺失的列
+func (s *Storage) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -93,10 +177,35 @@ func (s *Storage) AddToken(name, token string) (*Token, error) {
 	}, nil
 }
 
+// tokenColumns tokens 表查询列，与 scanToken 的顺序一致
+const tokenColumns = `id, name, token, is_active, is_valid, last_used, cooldown_until, created_at, updated_at, base_url, proxy, header_profile`
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanToken 扫描一行 Token 记录
+func scanToken(row rowScanner) (*Token, error) {
+	var t Token
+	var lastUsed, cooldownUntil sql.NullTime
+	err := row.Scan(&t.ID, &t.Name, &t.Token, &t.IsActive, &t.IsValid, &lastUsed, &cooldownUntil, &t.CreatedAt, &t.UpdatedAt, &t.BaseURL, &t.Proxy, &t.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		t.LastUsed = &lastUsed.Time
+	}
+	if cooldownUntil.Valid {
+		t.CooldownUntil = &cooldownUntil.Time
+	}
+	return &t, nil
+}
+
 // GetAllTokens 获取所有 Token
 func (s *Storage) GetAllTokens() ([]Token, error) {
 	rows, err := s.db.Query(
-		`SELECT id, name, token, is_active, is_valid, last_used, created_at, updated_at
+		`SELECT ` + tokenColumns + `
 		 FROM tokens ORDER BY created_at DESC`,
 	)
 	if err != nil {
@@ -106,60 +215,53 @@ func (s *Storage) GetAllTokens() ([]Token, error) {
 
 	var tokens []Token
 	for rows.Next() {
-		var t Token
-		var lastUsed sql.NullTime
-		err := rows.Scan(&t.ID, &t.Name, &t.Token, &t.IsActive, &t.IsValid, &lastUsed, &t.CreatedAt, &t.UpdatedAt)
+		t, err := scanToken(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan token: %w", err)
 		}
-		if lastUsed.Valid {
-			t.LastUsed = &lastUsed.Time
-		}
-		tokens = append(tokens, t)
+		tokens = append(tokens, *t)
 	}
 	return tokens, nil
 }
 
 // GetToken 根据 ID 获取 Token
 func (s *Storage) GetToken(id int64) (*Token, error) {
-	var t Token
-	var lastUsed sql.NullTime
-	err := s.db.QueryRow(
-		`SELECT id, name, token, is_active, is_valid, last_used, created_at, updated_at
+	t, err := scanToken(s.db.QueryRow(
+		`SELECT `+tokenColumns+`
 		 FROM tokens WHERE id = ?`, id,
-	).Scan(&t.ID, &t.Name, &t.Token, &t.IsActive, &t.IsValid, &lastUsed, &t.CreatedAt, &t.UpdatedAt)
+	))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to get token: %w", err)
 	}
-	if lastUsed.Valid {
-		t.LastUsed = &lastUsed.Time
-	}
-	return &t, nil
+	return t, nil
 }
 
-// GetActiveToken 获取一个可用的 Token（轮询策略）
-func (s *Storage) GetActiveToken() (*Token, error) {
-	var t Token
-	var lastUsed sql.NullTime
-	// 优先选择有效且最久未使用的 Token
-	err := s.db.QueryRow(
-		`SELECT id, name, token, is_active, is_valid, last_used, created_at, updated_at
+// GetActiveToken 获取一个可用的 Token（轮询策略），跳过冷却中和 exclude 中的 Token
+func (s *Storage) GetActiveToken(exclude ...int64) (*Token, error) {
+	query := `SELECT ` + tokenColumns + `
 		 FROM tokens WHERE is_active = 1 AND is_valid = 1
-		 ORDER BY last_used ASC NULLS FIRST, created_at ASC LIMIT 1`,
-	).Scan(&t.ID, &t.Name, &t.Token, &t.IsActive, &t.IsValid, &lastUsed, &t.CreatedAt, &t.UpdatedAt)
+		 AND (cooldown_until IS NULL OR cooldown_until <= ?)`
+	args := []any{time.Now()}
+	if len(exclude) > 0 {
+		query += ` AND id NOT IN (?` + strings.Repeat(`, ?`, len(exclude)-1) + `)`
+		for _, id := range exclude {
+			args = append(args, id)
+		}
+	}
+	// 优先选择有效且最久未使用的 Token
+	query += ` ORDER BY last_used ASC NULLS FIRST, created_at ASC LIMIT 1`
+
+	t, err := scanToken(s.db.QueryRow(query, args...))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to get active token: %w", err)
 	}
-	if lastUsed.Valid {
-		t.LastUsed = &lastUsed.Time
-	}
-	return &t, nil
+	return t, nil
 }
 
 // UpdateTokenUsed 更新 Token 最后使用时间
@@ -182,6 +284,48 @@ func (s *Storage) UpdateTokenValid(id int64, isValid bool) error {
 	return err
 }
 
+// SetTokenCooldown 设置 Token 冷却截止时间，冷却期内 GetActiveToken 不会返回该 Token
+func (s *Storage) SetTokenCooldown(id int64, until time.Time) error {
+	_, err := s.db.Exec(
+		`UPDATE tokens SET cooldown_until = ?, updated_at = ? WHERE id = ?`,
+		until, time.Now(), id,
+	)
+	return err
+}
+
+// TokenFault Token 本身的故障类型
+type TokenFault int
+
+const (
+	TokenInvalid     TokenFault = iota + 1 // Token 失效
+	TokenRateLimited                       // Token 被限流
+	TokenOutOfFunds                        // Token 余额不足
+)
+
+// TokenCooldowns Token 故障后的冷却时长
+type TokenCooldowns struct {
+	RateLimit time.Duration // 限流后的冷却时长
+	Funds     time.Duration // 余额不足后的冷却时长
+}
+
+// DefaultTokenCooldowns 默认冷却时长
+func DefaultTokenCooldowns() TokenCooldowns {
+	return TokenCooldowns{RateLimit: time.Minute, Funds: time.Hour}
+}
+
+// MarkTokenFault 按故障类型更新 Token 状态：失效的停用，限流和余额不足的按 cooldowns 进入冷却
+func (s *Storage) MarkTokenFault(id int64, fault TokenFault, cooldowns TokenCooldowns) error {
+	switch fault {
+	case TokenInvalid:
+		return s.UpdateTokenValid(id, false)
+	case TokenRateLimited:
+		return s.SetTokenCooldown(id, time.Now().Add(cooldowns.RateLimit))
+	case TokenOutOfFunds:
+		return s.SetTokenCooldown(id, time.Now().Add(cooldowns.Funds))
+	}
+	return nil
+}
+
 // UpdateTokenActive 更新 Token 启用状态
 func (s *Storage) UpdateTokenActive(id int64, isActive bool) error {
 	now := time.Now()
@@ -192,6 +336,15 @@ func (s *Storage) UpdateTokenActive(id int64, isActive bool) error {
 	return err
 }
 
+// UpdateTokenSettings 更新 Token 的上游连接设置
+func (s *Storage) UpdateTokenSettings(id int64, settings TokenSettings) error {
+	_, err := s.db.Exec(
+		`UPDATE tokens SET base_url = ?, proxy = ?, header_profile = ?, updated_at = ? WHERE id = ?`,
+		settings.BaseURL, settings.Proxy, settings.Profile, time.Now(), id,
+	)
+	return err
+}
+
 // DeleteToken 删除 Token
 func (s *Storage) DeleteToken(id int64) error {
 	_, err := s.db.Exec(`DELETE FROM tokens WHERE id = ?`, id)
@@ -0,0 +1,109 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+// maxMergePiece 单个预分词片段参与合并的最大字节数，超长片段分段合并，避免平方复杂度
+const maxMergePiece = 256
+
+// bpe 基于 tiktoken 格式词表的字节对编码计数器
+type bpe struct {
+	name  string
+	ranks map[string]int
+}
+
+// loadBPE 读取 tiktoken 格式的词表：每行为 base64 编码的 token 和它的合并优先级
+func loadBPE(name, path string) (*bpe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ranks := make(map[string]int, 200000)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<base64 token> <rank>\"", path, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("%s: empty vocabulary", path)
+	}
+	return &bpe{name: name, ranks: ranks}, nil
+}
+
+// Name 返回词表名
+func (b *bpe) Name() string {
+	return b.name
+}
+
+// Count 按预分词规则切分后逐段做字节对合并，返回 token 数
+func (b *bpe) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	total := 0
+	for _, piece := range pretokenizeRe.FindAllString(text, -1) {
+		for piece != "" {
+			chunk := piece
+			if len(chunk) > maxMergePiece {
+				cut := maxMergePiece
+				for cut > 0 && !utf8.RuneStart(chunk[cut]) {
+					cut--
+				}
+				chunk = chunk[:cut]
+			}
+			piece = piece[len(chunk):]
+			total += b.countPiece(chunk)
+		}
+	}
+	return total
+}
+
+// countPiece 返回单个片段合并后的 token 数
+func (b *bpe) countPiece(piece string) int {
+	if _, ok := b.ranks[piece]; ok {
+		return 1
+	}
+	// parts[i] 为第 i 个 token 的起始字节，最后一项为片段长度
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+	for len(parts) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+2 < len(parts); i++ {
+			if rank, ok := b.ranks[piece[parts[i]:parts[i+2]]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return len(parts) - 1
+}
@@ -0,0 +1,122 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"puter2api/internal/types"
+)
+
+// writeVocab 在临时目录写入 tiktoken 格式的小词表
+func writeVocab(t *testing.T, dir, name string, tokens ...string) {
+	t.Helper()
+	var sb strings.Builder
+	for rank, tok := range tokens {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(tok)), rank)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".tiktoken"), []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resetVocabs 测试结束后卸载词表，避免影响其他测试
+func resetVocabs(t *testing.T) {
+	t.Cleanup(func() {
+		vocabMu.Lock()
+		vocabs = map[string]*bpe{}
+		vocabMu.Unlock()
+	})
+}
+
+func TestForModel_Families(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o-mini", "heuristic:openai-o200k"},
+		{"gpt-4-turbo", "heuristic:openai-cl100k"},
+		{"openrouter:openai/gpt-5", "heuristic:openai-o200k"},
+		{"claude-sonnet-4-5", "heuristic:claude"},
+		{"gemini-2.5-pro", "heuristic:gemini"},
+		{"deepseek-chat", "heuristic"},
+	}
+	for _, tt := range tests {
+		if got := ForModel(tt.model).Name(); got != tt.want {
+			t.Errorf("ForModel(%q) = %s, want %s", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestForModel_HeuristicScale(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20)
+	base := Count(text)
+	if n := ForModel("claude-opus-4-5").Count(text); n <= base {
+		t.Errorf("expected claude estimate above %d, got %d", base, n)
+	}
+	if n := ForModel("gemini-2.5-flash").Count(text); n >= base {
+		t.Errorf("expected gemini estimate below %d, got %d", base, n)
+	}
+	if n := ForModel("unknown-model").Count(text); n != base {
+		t.Errorf("expected default estimate %d, got %d", base, n)
+	}
+}
+
+func TestLoadVocabs_BPE(t *testing.T) {
+	resetVocabs(t)
+	dir := t.TempDir()
+	writeVocab(t, dir, "o200k_base", "a", "b", "c", " ", "ab", "abc", " abc")
+
+	names, err := LoadVocabs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "o200k_base" {
+		t.Fatalf("expected [o200k_base], got %v", names)
+	}
+
+	enc := ForModel("gpt-4o")
+	if enc.Name() != "o200k_base" {
+		t.Fatalf("expected o200k_base encoder, got %s", enc.Name())
+	}
+	// "abc" 整体命中；" abcab" 合并为 " abc" + "ab"
+	if n := enc.Count("abc abcab"); n != 3 {
+		t.Errorf("expected 3 tokens, got %d", n)
+	}
+	// 没有词表的系列仍使用启发式估算
+	if name := ForModel("gpt-4-turbo").Name(); name != "heuristic:openai-cl100k" {
+		t.Errorf("expected heuristic for cl100k, got %s", name)
+	}
+}
+
+func TestLoadVocabs_Invalid(t *testing.T) {
+	resetVocabs(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cl100k_base.tiktoken"), []byte("not-base64!! x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVocabs(dir); err == nil {
+		t.Error("expected error for malformed vocabulary")
+	}
+}
+
+func TestCountInput_IncludesTools(t *testing.T) {
+	messages := []types.PuterMessage{{Role: "user", Content: "Hi"}}
+	tools := []types.OpenAITool{{
+		Type: "function",
+		Function: types.OpenAIToolFunction{
+			Name:       "get_weather",
+			Parameters: []byte(`{"type":"object","properties":{"city":{"type":"string"}}}`),
+		},
+	}}
+
+	enc := ForModel("gpt-4o")
+	without := CountInput(enc, messages, nil)
+	with := CountInput(enc, messages, tools)
+	if with-without != CountTools(enc, tools) || with-without <= tokensPerTool {
+		t.Errorf("expected tool tokens to be added, got %d vs %d", with, without)
+	}
+}
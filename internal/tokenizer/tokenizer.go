@@ -0,0 +1,141 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"puter2api/internal/types"
+)
+
+// 消息格式开销（与 OpenAI 的计数规则一致）
+const (
+	tokensPerMessage = 4    // 每条消息的角色和分隔符
+	tokensPerReply   = 3    // 回复的起始标记
+	tokensPerImage   = 1600 // 每张图片的估算值（约 1.15 MP 图片的开销）
+	tokensPerTool    = 8    // 每个工具定义的包装开销
+)
+
+// pretokenizeRe 近似 cl100k 的预分词规则（RE2 不支持前瞻，空白处理略有差异）
+var pretokenizeRe = regexp.MustCompile(`'(?i:[sdmt]|ll|ve|re)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// Encoder 某个模型系列的 token 计数器
+type Encoder interface {
+	// Name 计数方式，如 o200k_base 或 heuristic:claude
+	Name() string
+	// Count 返回文本的 token 数
+	Count(text string) int
+}
+
+// Count 用默认的启发式规则估算文本的 token 数（按 cl100k 校准）
+//
+// 先按 BPE 分词器的预分词规则切分，再按片段类型估算每段的 token 数：
+// 常见英文单词多为 1 个 token，长词约每 5 个字母 1 个；CJK 约每字 1 个。
+func Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	total := 0
+	for _, piece := range pretokenizeRe.FindAllString(text, -1) {
+		total += countPiece(piece)
+	}
+	return total
+}
+
+// CountMessages 用默认规则估算消息列表作为模型输入时的 token 数
+func CountMessages(messages []types.PuterMessage) int {
+	return CountMessagesWith(defaultEncoder, messages)
+}
+
+// CountMessagesWith 用指定计数器估算消息列表作为模型输入时的 token 数
+func CountMessagesWith(enc Encoder, messages []types.PuterMessage) int {
+	total := tokensPerReply
+	for _, m := range messages {
+		total += CountMessage(enc, m)
+	}
+	return total
+}
+
+// CountMessage 估算单条消息的 token 数，包括格式开销和图片（Content 已包含全部文本）
+func CountMessage(enc Encoder, m types.PuterMessage) int {
+	total := tokensPerMessage + enc.Count(m.Content)
+	for _, p := range m.Parts {
+		if p.Type == "image_url" {
+			total += tokensPerImage
+		}
+	}
+	return total
+}
+
+// CountTools 估算原生工具定义的 token 数：按序列化后的 JSON 计数，另加每个工具的格式开销
+func CountTools(enc Encoder, tools []types.OpenAITool) int {
+	total := 0
+	for _, t := range tools {
+		data, _ := json.Marshal(t.Function)
+		total += tokensPerTool + enc.Count(string(data))
+	}
+	return total
+}
+
+// CountInput 估算一次请求的输入 token 数：转换后的消息（含 system prompt）和原生工具定义
+func CountInput(enc Encoder, messages []types.PuterMessage, tools []types.OpenAITool) int {
+	return CountMessagesWith(enc, messages) + CountTools(enc, tools)
+}
+
+// countPiece 估算单个预分词片段的 token 数
+func countPiece(piece string) int {
+	first, _ := utf8.DecodeRuneInString(piece)
+	switch {
+	case unicode.IsSpace(first) && isAllSpace(piece):
+		// 连续空白通常会被合并
+		return ceilDiv(utf8.RuneCountInString(piece), 8)
+	case unicode.IsNumber(first):
+		return 1
+	}
+
+	letters, cjk, other := 0, 0, 0
+	for _, r := range piece {
+		switch {
+		case isCJK(r):
+			cjk++
+		case r < utf8.RuneSelf && unicode.IsLetter(r):
+			letters++
+		case unicode.IsLetter(r):
+			// 其他非 ASCII 文字（西里尔、阿拉伯等）
+			other++
+		}
+	}
+
+	switch {
+	case cjk > 0 || other > 0:
+		return cjk + ceilDiv(other, 3) + ceilDiv(letters, 5)
+	case letters > 0:
+		return int(math.Max(1, math.Ceil(float64(letters)/5)))
+	default:
+		// 标点、符号：约每 2 个字符 1 个 token
+		return ceilDiv(utf8.RuneCountInString(piece), 2)
+	}
+}
+
+func isAllSpace(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+func ceilDiv(a, b int) int {
+	if a <= 0 {
+		return 0
+	}
+	return (a + b - 1) / b
+}
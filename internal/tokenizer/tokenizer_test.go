@@ -0,0 +1,56 @@
+package tokenizer
+
+import (
+	"strings"
+	"testing"
+
+	"puter2api/internal/types"
+)
+
+func TestCount_Empty(t *testing.T) {
+	if n := Count(""); n != 0 {
+		t.Errorf("expected 0 tokens, got %d", n)
+	}
+}
+
+func TestCount_EnglishProse(t *testing.T) {
+	// cl100k 对这句话的实际计数为 10
+	n := Count("The quick brown fox jumps over the lazy dog.")
+	if n < 8 || n > 12 {
+		t.Errorf("expected about 10 tokens, got %d", n)
+	}
+}
+
+func TestCount_CJK(t *testing.T) {
+	n := Count("你好，世界")
+	if n < 4 || n > 7 {
+		t.Errorf("expected about 5 tokens, got %d", n)
+	}
+}
+
+func TestCount_Numbers(t *testing.T) {
+	// 数字按最多 3 位一组切分
+	if n := Count("1234567"); n != 3 {
+		t.Errorf("expected 3 tokens, got %d", n)
+	}
+}
+
+func TestCount_ScalesWithLength(t *testing.T) {
+	short := Count(strings.Repeat("hello world ", 10))
+	long := Count(strings.Repeat("hello world ", 100))
+	if long < short*9 || long > short*11 {
+		t.Errorf("expected roughly linear growth, got %d vs %d", short, long)
+	}
+}
+
+func TestCountMessages_IncludesOverhead(t *testing.T) {
+	messages := []types.PuterMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Hi"},
+	}
+
+	want := tokensPerReply + 2*tokensPerMessage + Count("Be brief.") + Count("Hi")
+	if n := CountMessages(messages); n != want {
+		t.Errorf("expected %d tokens, got %d", want, n)
+	}
+}
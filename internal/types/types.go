@@ -6,12 +6,28 @@ import "encoding/json"
 
 // ClaudeRequest Claude API 请求结构
 type ClaudeRequest struct {
-	Model     string          `json:"model,omitempty"`
-	MaxTokens int             `json:"max_tokens"`
-	Messages  []ClaudeMessage `json:"messages"`
-	Stream    bool            `json:"stream"`
-	Tools     json.RawMessage `json:"tools,omitempty"`
-	System    json.RawMessage `json:"system,omitempty"`
+	Model         string          `json:"model,omitempty"`
+	MaxTokens     int             `json:"max_tokens"`
+	Messages      []ClaudeMessage `json:"messages"`
+	Stream        bool            `json:"stream"`
+	Tools         json.RawMessage `json:"tools,omitempty"`
+	System        json.RawMessage `json:"system,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          *int            `json:"top_k,omitempty"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Thinking      *ThinkingConfig `json:"thinking,omitempty"`
+}
+
+// ThinkingConfig 扩展思考配置
+type ThinkingConfig struct {
+	Type         string `json:"type"` // enabled / disabled
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// Enabled 是否开启扩展思考
+func (t *ThinkingConfig) Enabled() bool {
+	return t != nil && t.Type == "enabled"
 }
 
 // ClaudeMessage Claude 消息
@@ -29,6 +45,15 @@ type ContentBlock struct {
 	Input     json.RawMessage `json:"input,omitempty"`
 	ToolUseID string          `json:"tool_use_id,omitempty"`
 	Content   json.RawMessage `json:"content,omitempty"`
+	Source    *ImageSource    `json:"source,omitempty"`
+}
+
+// ImageSource image 内容块的图片来源（base64 或 url）
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
 }
 
 // TextContentBlock 文本内容块（text 字段始终存在）
@@ -37,6 +62,13 @@ type TextContentBlock struct {
 	Text string `json:"text"`
 }
 
+// ThinkingContentBlock 思考内容块
+type ThinkingContentBlock struct {
+	Type      string `json:"type"`
+	Thinking  string `json:"thinking"`
+	Signature string `json:"signature,omitempty"`
+}
+
 // ToolUseContentBlock 工具使用内容块
 type ToolUseContentBlock struct {
 	Type  string          `json:"type"`
@@ -47,13 +79,16 @@ type ToolUseContentBlock struct {
 
 // Usage token 使用量
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens          int `json:"input_tokens"`
+	OutputTokens         int `json:"output_tokens"`
+	CacheReadInputTokens int `json:"cache_read_input_tokens,omitempty"` // 命中响应缓存时的输入用量，未消耗上游额度
 }
 
-// DeltaUsage 增量使用量
+// DeltaUsage 增量使用量（累计值；上游在结束时才报告输入用量时附带 input_tokens）
 type DeltaUsage struct {
-	OutputTokens int `json:"output_tokens"`
+	InputTokens          int `json:"input_tokens,omitempty"`
+	OutputTokens         int `json:"output_tokens"`
+	CacheReadInputTokens int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // ToolDef 工具定义
@@ -90,6 +125,18 @@ type MessageStartDetail struct {
 	Usage        Usage          `json:"usage"`
 }
 
+// ClaudeResponse 非流式 /v1/messages 响应
+type ClaudeResponse struct {
+	ID           string  `json:"id"`
+	Type         string  `json:"type"`
+	Role         string  `json:"role"`
+	Content      []any   `json:"content"` // TextContentBlock / ThinkingContentBlock / ToolUseContentBlock
+	Model        string  `json:"model"`
+	StopReason   *string `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+	Usage        Usage   `json:"usage"`
+}
+
 // ContentBlockStartEvent content_block_start 事件
 type ContentBlockStartEvent struct {
 	Type         string      `json:"type"`
@@ -110,6 +157,18 @@ type TextDelta struct {
 	Text string `json:"text"`
 }
 
+// ThinkingDelta 思考内容增量
+type ThinkingDelta struct {
+	Type     string `json:"type"`
+	Thinking string `json:"thinking"`
+}
+
+// SignatureDelta 思考块签名
+type SignatureDelta struct {
+	Type      string `json:"type"`
+	Signature string `json:"signature"`
+}
+
 // InputJSONDelta JSON 输入增量
 type InputJSONDelta struct {
 	Type        string `json:"type"`
@@ -140,6 +199,18 @@ type MessageStopEvent struct {
 	Type string `json:"type"`
 }
 
+// ErrorEvent error 事件
+type ErrorEvent struct {
+	Type  string      `json:"type"`
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail 错误详情
+type ErrorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
 // ==================== Puter API 类型 ====================
 
 // PuterRequest Puter API 请求
@@ -154,39 +225,142 @@ type PuterRequest struct {
 
 // PuterArgs Puter 请求参数
 type PuterArgs struct {
-	Messages []PuterMessage `json:"messages"`
-	Model    string         `json:"model"`
-	Stream   bool           `json:"stream"`
+	Messages         []PuterMessage  `json:"messages"`
+	Model            string          `json:"model"`
+	Stream           bool            `json:"stream"`
+	MaxTokens        int             `json:"max_tokens,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	TopK             *int            `json:"top_k,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	Tools            []OpenAITool    `json:"tools,omitempty"`
+	Thinking         *ThinkingConfig `json:"thinking,omitempty"`
+	ReasoningEffort  string          `json:"reasoning_effort,omitempty"`
+}
+
+// SamplingParams 采样与推理参数，零值 / nil 表示未设置
+type SamplingParams struct {
+	MaxTokens        int
+	Temperature      *float64
+	TopP             *float64
+	TopK             *int
+	Stop             []string
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	Thinking         *ThinkingConfig // Claude 扩展思考
+	ReasoningEffort  string          // OpenAI reasoning_effort：low / medium / high
 }
 
 // PuterMessage Puter 消息
+//
+// Content 为纯文本内容（用于估算用量和截断）；Parts 非空时消息含图片或原生工具调用，
+// 发送给上游的 content 为 Parts 数组。
 type PuterMessage struct {
-	Role    string `json:"role,omitempty"`
-	Content string `json:"content"`
+	Role    string             `json:"role,omitempty"`
+	Content string             `json:"content"`
+	Parts   []PuterContentPart `json:"-"`
+}
+
+// PuterContentPart 结构化消息内容片段（text、image_url，原生工具调用时还有 tool_use、tool_result）
+type PuterContentPart struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ImageURL  *PuterImageURL  `json:"image_url,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// PuterImageURL 图片地址，base64 图片使用 data URL
+type PuterImageURL struct {
+	URL string `json:"url"`
 }
 
-// PuterStreamChunk Puter 流式响应块
+// HasImages 消息是否包含图片
+func (m PuterMessage) HasImages() bool {
+	for _, p := range m.Parts {
+		if p.Type == "image_url" {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON 有 Parts 时以内容片段数组发送
+func (m PuterMessage) MarshalJSON() ([]byte, error) {
+	if len(m.Parts) == 0 {
+		type plain PuterMessage
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		Role    string             `json:"role,omitempty"`
+		Content []PuterContentPart `json:"content"`
+	}{m.Role, m.Parts})
+}
+
+// PuterStreamChunk Puter 流式响应块（text、推理内容或原生工具调用 tool_use）
 type PuterStreamChunk struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	Reasoning string          `json:"reasoning,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+// ReasoningText 推理内容；不同驱动分别使用 reasoning、thinking 字段或 type 为 reasoning / thinking 的 text
+func (c PuterStreamChunk) ReasoningText() string {
+	switch {
+	case c.Reasoning != "":
+		return c.Reasoning
+	case c.Thinking != "":
+		return c.Thinking
+	case c.Type == "reasoning" || c.Type == "thinking":
+		return c.Text
+	}
+	return ""
+}
+
+// IsReasoning 是否为推理内容块（含仅携带签名的块）
+func (c PuterStreamChunk) IsReasoning() bool {
+	return c.ReasoningText() != "" || (c.Type == "thinking" && c.Signature != "")
+}
+
+// IsToolUse 是否为原生工具调用块
+func (c PuterStreamChunk) IsToolUse() bool {
+	return c.Type == "tool_use" && c.Name != ""
 }
 
 // ==================== OpenAI API 类型 ====================
 
 // OpenAIRequest OpenAI Chat Completion 请求
 type OpenAIRequest struct {
-	Model            string          `json:"model"`
-	Messages         []OpenAIMessage `json:"messages"`
-	MaxTokens        int             `json:"max_tokens,omitempty"`
-	Temperature      float64         `json:"temperature,omitempty"`
-	TopP             float64         `json:"top_p,omitempty"`
-	N                int             `json:"n,omitempty"`
-	Stream           bool            `json:"stream,omitempty"`
-	Stop             json.RawMessage `json:"stop,omitempty"`
-	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
-	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
-	Tools            []OpenAITool    `json:"tools,omitempty"`
-	ToolChoice       json.RawMessage `json:"tool_choice,omitempty"`
+	Model               string          `json:"model"`
+	Messages            []OpenAIMessage `json:"messages"`
+	MaxTokens           int             `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	N                   int             `json:"n,omitempty"`
+	Stream              bool            `json:"stream,omitempty"`
+	Stop                json.RawMessage `json:"stop,omitempty"`
+	PresencePenalty     *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64        `json:"frequency_penalty,omitempty"`
+	Tools               []OpenAITool    `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage `json:"tool_choice,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+	ReasoningEffort     string          `json:"reasoning_effort,omitempty"`
+}
+
+// StreamOptions OpenAI 流式选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // OpenAIMessage OpenAI 消息
@@ -247,14 +421,21 @@ type OpenAIChoice struct {
 
 // OpenAIResponseMsg OpenAI 响应消息
 type OpenAIResponseMsg struct {
-	Role      string           `json:"role,omitempty"`
-	Content   *string          `json:"content"`
-	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+	Role             string           `json:"role,omitempty"`
+	Content          *string          `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
 }
 
 // OpenAIUsage OpenAI 使用量
 type OpenAIUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                        `json:"prompt_tokens"`
+	CompletionTokens    int                        `json:"completion_tokens"`
+	TotalTokens         int                        `json:"total_tokens"`
+	PromptTokensDetails *OpenAIPromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// OpenAIPromptTokensDetails 输入用量明细
+type OpenAIPromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
 }
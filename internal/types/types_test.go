@@ -552,3 +552,24 @@ func TestParsedToolCall_Deserialization(t *testing.T) {
 		t.Errorf("expected expression '1+1'")
 	}
 }
+
+func TestPuterMessage_MarshalParts(t *testing.T) {
+	plain, _ := json.Marshal(PuterMessage{Role: "user", Content: "hi"})
+	if string(plain) != `{"role":"user","content":"hi"}` {
+		t.Errorf("unexpected plain message: %s", plain)
+	}
+
+	msg := PuterMessage{
+		Role:    "user",
+		Content: "look",
+		Parts: []PuterContentPart{
+			{Type: "text", Text: "look"},
+			{Type: "image_url", ImageURL: &PuterImageURL{URL: "data:image/png;base64,AAAA"}},
+		},
+	}
+	data, _ := json.Marshal(msg)
+	want := `{"role":"user","content":[{"type":"text","text":"look"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]}`
+	if string(data) != want {
+		t.Errorf("got %s", data)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -9,14 +10,22 @@ import (
 	"os"
 	"time"
 
+	"puter2api/internal/breaker"
+	"puter2api/internal/cache"
+	"puter2api/internal/catalog"
 	"puter2api/internal/handler"
+	"puter2api/internal/puter"
 	"puter2api/internal/storage"
+	"puter2api/internal/tokenizer"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// routesReloadInterval 路由文件的检查间隔
+const routesReloadInterval = 5 * time.Second
+
 //go:embed web/*
 var webFS embed.FS
 
@@ -55,11 +64,77 @@ func main() {
 	if err := json.Unmarshal(modelJSON, &modelFile); err != nil {
 		log.Fatal().Err(err).Msg("解析 model.json 失败")
 	}
-	log.Info().Int("count", len(modelFile.Models)).Msg("加载模型列表")
+	log.Info().Int("count", len(modelFile.Models)).Msg("加载内置模型列表")
 
 	// 创建处理器 - 从数据库获取 Token
-	h := handler.NewHandler(store, modelFile.Models)
-	th := handler.NewTokenHandler(store)
+	endpoint := puter.EndpointFromEnv()
+	log.Info().Str("base_url", endpoint.BaseURL).Str("models_url", endpoint.ModelsEndpoint()).Msg("上游地址")
+	profiles, err := puter.ProfilesFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载请求头指纹失败")
+	}
+	log.Info().Strs("profiles", profiles.Names()).Bool("rotate", profiles.Rotate()).Msg("请求头指纹")
+	client := puter.NewClient(endpoint, profiles)
+
+	// 模型路由表，配置文件修改后自动重新加载
+	routesPath, err := puter.RoutesFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载路由表失败")
+	}
+	if routesPath != "" {
+		go puter.WatchRoutes(context.Background(), routesPath, routesReloadInterval)
+	}
+	log.Info().Str("source", routesPath).Int("rules", len(puter.Routes().Routes)).Msg("模型路由表")
+
+	// 模型目录：定期从上游同步并缓存到数据库，从未同步成功时使用内置列表
+	catalogCfg, err := catalog.ConfigFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载模型目录配置失败")
+	}
+	catalogCfg.Cooldowns = handler.RetryPolicyFromEnv().Cooldowns()
+	models := catalog.New(client, store, modelFile.Models, catalogCfg)
+	if err := models.Load(); err != nil {
+		log.Fatal().Err(err).Msg("加载模型目录缓存失败")
+	}
+	st := models.Status()
+	log.Info().Str("source", st.Source).Int("count", st.Count).Dur("ttl", catalogCfg.TTL).Msg("模型目录")
+	go models.Run(context.Background())
+
+	aliases, err := catalog.NewAliases(store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载模型别名失败")
+	}
+	log.Info().Int("count", len(aliases.List())).Msg("模型别名")
+
+	// 分词词表：没有词表的模型系列使用校准后的启发式估算
+	vocabs, err := tokenizer.VocabsFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载分词词表失败")
+	}
+	if len(vocabs) > 0 {
+		log.Info().Strs("vocabs", vocabs).Msg("分词词表")
+	}
+
+	// 响应缓存：RESPONSE_CACHE 未设置时关闭
+	cacheCfg, err := cache.ConfigFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载响应缓存配置失败")
+	}
+	responses := cache.New(cacheCfg, store)
+	if responses.Enabled() {
+		log.Info().Str("backend", cacheCfg.Backend).Dur("ttl", cacheCfg.TTL).Int("max_entries", cacheCfg.MaxEntries).Int64("max_bytes", cacheCfg.MaxBytes).Msg("响应缓存")
+	}
+
+	// 驱动熔断：连续失败后直接拒绝，冷却结束后放行探测请求
+	breakerCfg, err := breaker.ConfigFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载熔断配置失败")
+	}
+	breakers := breaker.New(breakerCfg)
+	log.Info().Int("threshold", breakerCfg.Threshold).Dur("cooldown", breakerCfg.Cooldown).Bool("per_model", breakerCfg.PerModel).Msg("驱动熔断")
+
+	h := handler.NewHandler(store, client, models, aliases, responses, breakers)
+	th := handler.NewTokenHandler(store, client)
 
 	// 设置 Gin 使用 zerolog
 	gin.SetMode(gin.ReleaseMode)
@@ -70,6 +145,8 @@ func main() {
 	// Claude API 兼容端点
 	r.POST("/v1/messages", h.HandleMessages)
 	r.POST("/messages", h.HandleMessages)
+	r.POST("/v1/messages/count_tokens", h.HandleCountTokens)
+	r.POST("/messages/count_tokens", h.HandleCountTokens)
 
 	// OpenAI API 兼容端点
 	r.POST("/v1/chat/completions", h.HandleOpenAIChat)
@@ -83,10 +160,23 @@ func main() {
 		api.GET("/tokens", th.ListTokens)
 		api.POST("/tokens", th.AddToken)
 		api.DELETE("/tokens/:id", th.DeleteToken)
-		api.PUT("/tokens/:id", th.UpdateTokenName)
+		api.PUT("/tokens/:id", th.UpdateToken)
 		api.PUT("/tokens/:id/toggle", th.ToggleToken)
 		api.POST("/tokens/:id/test", th.TestToken)
 		api.POST("/tokens/test-all", th.TestAllTokens)
+		api.POST("/proxy/test", th.TestProxy)
+		api.GET("/profiles", th.ListProfiles)
+		api.GET("/routes", h.ListRoutes)
+		api.GET("/routes/resolve", h.ResolveRoute)
+		api.GET("/models/catalog", h.CatalogStatus)
+		api.POST("/models/refresh", h.RefreshCatalog)
+		api.GET("/aliases", h.ListAliases)
+		api.POST("/aliases", h.SetAlias)
+		api.DELETE("/aliases", h.DeleteAlias)
+		api.GET("/cache/stats", h.CacheStats)
+		api.DELETE("/cache", h.ClearCache)
+		api.GET("/breakers", h.ListBreakers)
+		api.DELETE("/breakers", h.ResetBreaker)
 	}
 
 	// 静态文件服务 (Web UI)